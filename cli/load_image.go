@@ -2,11 +2,13 @@ package main
 
 import (
 	"context"
+	"github.com/alphabatem/nft-proxy/config"
 	"github.com/gagliardetto/solana-go"
 	"github.com/gagliardetto/solana-go/rpc"
 	"github.com/joho/godotenv"
 	"log"
 	"os"
+	"strings"
 )
 
 func init() {
@@ -17,8 +19,12 @@ func init() {
 }
 
 func main() {
-	// Consider having a config object that holds all the enviroment variable
-	client := rpc.New(os.Getenv("RPC_URL"))
+	cfg, _, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	client := rpc.New(strings.Split(cfg.RPC.Endpoints[0], "|")[0])
 	resp, err := client.GetAccountInfo(context.TODO(), solana.MustPublicKeyFromBase58("9FmQSV65qhKC4uDe1U2npGxAeMD7Ym2AQgBtkKyjKaax"))
 	if err != nil {
 		log.Fatal(err)
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	nft_proxy "github.com/alphabatem/nft-proxy"
+	"github.com/alphabatem/nft-proxy/config"
 	"github.com/alphabatem/nft-proxy/service"
 	"github.com/babilu-online/common/context"
 	"github.com/joho/godotenv"
@@ -28,13 +29,20 @@ func init() {
 type Hashlist []string
 
 func main() {
+	cfg, _, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	mainContext, err := context.NewCtx(
-		&services.SqliteService{},
-		&services.SolanaImageService{},
-		&services.ImageService{},
-		&services.ResizeService{},
-		&services.SolanaService{},
+		&services.SqliteService{Config: cfg},
+		&services.StatService{Config: cfg},
+		&services.StoreService{Config: cfg},
+		&services.GatewayService{Config: cfg},
+		&services.SolanaImageService{Config: cfg},
+		&services.ImageService{Config: cfg},
+		&services.ResizeService{Config: cfg},
+		&services.SolanaService{Config: cfg},
 	)
 	if err != nil {
 		log.Fatal(err)
@@ -89,6 +97,10 @@ func run(ctx *context.Context) error {
 	//img := ctx.Service(services.SOLANA_IMG_SVC).(*services.SolanaImageService)
 	//reloadLocally(img, hashes)
 
+	//Use to rebuild resized images from stored originals (no downloads)
+	//imgSvc := ctx.Service(services.IMG_SVC).(*services.ImageService)
+	//regenerateLocally(imgSvc, hashes)
+
 	return nil
 }
 
@@ -108,11 +120,34 @@ func reloadRemote(hashes Hashlist) error {
 }
 
 func reloadLocally(img *services.SolanaImageService, hashes Hashlist) error {
+	//Batched so compressed NFTs are resolved with a single DAS call per batch
+	for start := 0; start < len(hashes); start += 100 {
+		end := start + 100
+		if end > len(hashes) {
+			end = len(hashes)
+		}
+
+		log.Printf("Loading hashes: %v-%v", start, end)
+		media, err := img.FetchMetadataBatch(hashes[start:end])
+		if err != nil {
+			log.Printf("Failed batch: %v-%v - %s", start, end, err)
+			continue
+		}
+		for i, m := range media {
+			if m == nil {
+				log.Printf("Failed media: %s", hashes[start+i])
+			}
+		}
+	}
+	return nil
+}
+
+func regenerateLocally(img *services.ImageService, hashes Hashlist) error {
 	for _, h := range hashes {
-		log.Printf("Loading hash: %s", h)
-		_, err := img.Media(h, true)
+		log.Printf("Regenerating hash: %s", h)
+		err := img.RegenerateVariants(h)
 		if err != nil {
-			log.Printf("Failed media: %s - %s", h, err)
+			log.Printf("Failed variants: %s - %s", h, err)
 		}
 	}
 	return nil
@@ -0,0 +1,284 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	ErrInvalidConfig = errors.New("invalid configuration")
+	ErrMissingConfig = errors.New("service started without a configuration")
+)
+
+// Config of every service, read from defaults, a yaml file, the environment & flags in increasing priority
+type Config struct {
+	HTTP     HTTP     `yaml:"http"`
+	Database Database `yaml:"database"`
+	RPC      RPC      `yaml:"rpc"`
+	Retry    Retries  `yaml:"retry"`
+	Gateways Gateways `yaml:"gateways"`
+	Media    Media    `yaml:"media"`
+	Resize   Resize   `yaml:"resize"`
+	Watcher  Watcher  `yaml:"watcher"`
+	Webhook  Webhook  `yaml:"webhook"`
+	Tokens   Tokens   `yaml:"tokens"`
+}
+
+type HTTP struct {
+	Port            int           `yaml:"port"`
+	DefaultImage    string        `yaml:"default_image"`    //Served with a 404 when an image cannot be loaded
+	CORSOrigins     []string      `yaml:"cors_origins"`     //"*" allows every origin
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"` //How long in-flight requests & background refreshes are waited for on shutdown
+}
+
+type Database struct {
+	Path string `yaml:"path"`
+}
+
+type RPC struct {
+	Endpoints    []string    `yaml:"endpoints"`     //url[|weight[|requests per second]]
+	DASEndpoints []string    `yaml:"das_endpoints"` //Same format as the endpoints, defaults to the rpc endpoints
+	WSURL        string      `yaml:"ws_url"`        //Enables the metadata watcher
+	Commitment   Commitments `yaml:"commitment"`
+}
+
+type Commitments struct {
+	Metadata  string `yaml:"metadata"` //Metadata is cached, processed data may still be rolled back
+	Blockhash string `yaml:"blockhash"`
+}
+
+// Retries of requests to each dependency, using exponential backoff with full jitter
+type Retries struct {
+	RPC    Retry `yaml:"rpc"`
+	Origin Retry `yaml:"origin"`
+}
+
+type Retry struct {
+	Attempts  int           `yaml:"attempts"` //Total attempts including the first, 1 disables retries
+	BaseDelay time.Duration `yaml:"base_delay"`
+	MaxDelay  time.Duration `yaml:"max_delay"` //Also the longest Retry-After we are willing to wait
+}
+
+type Gateways struct {
+	IPFS    []string `yaml:"ipfs"`
+	Arweave []string `yaml:"arweave"`
+}
+
+type Media struct {
+	DefaultSize     int           `yaml:"default_size"` //Height images are resized to, gifs are half the size
+	CacheDir        string        `yaml:"cache_dir"`
+	MetadataTimeout time.Duration `yaml:"metadata_timeout"`
+	DownloadTimeout time.Duration `yaml:"download_timeout"`
+	ExemptImages    []string      `yaml:"exempt_images"` //Mints whose cached image is never cleared, older & core tokens without active metadata
+}
+
+// Resize pool & the limits images are checked against before decoding, a zero limit disables it
+type Resize struct {
+	Workers              int           `yaml:"workers"` //0 uses one per cpu
+	QueueDepth           int           `yaml:"queue_depth"`
+	JobTimeout           time.Duration `yaml:"job_timeout"` //Including time queued, 0 disables
+	MaxBytes             int           `yaml:"max_bytes"`
+	MaxPixels            int           `yaml:"max_pixels"`
+	MaxFrames            int           `yaml:"max_frames"`
+	MaxAnimationFrames   int           `yaml:"max_animation_frames"` //Animations with more frames keep every nth frame
+	MaxAnimationDuration time.Duration `yaml:"max_animation_duration"`
+}
+
+// Watcher subscribes to each of the 4 accounts metadata of a cached mint is read from while at most MaxMints
+// are cached. Above that it subscribes to the metadata programs instead, filtered by the node to metadata, mint
+// & asset accounts. On mainnet those still stream every metadata change whatever the cache size, so prefer
+// the webhook for large caches.
+type Watcher struct {
+	MaxMints int `yaml:"max_mints"`
+}
+
+type Webhook struct {
+	Secret  string `yaml:"secret"` //Enables the metadata webhook
+	Workers int    `yaml:"workers"`
+}
+
+type Tokens struct {
+	SupplyRefresh time.Duration `yaml:"supply_refresh"` //Fungible supply older than this is refreshed
+}
+
+// Default returns the configuration used for anything not set, the database & rpc endpoints have no default
+func Default() *Config {
+	return &Config{
+		HTTP: HTTP{
+			Port:            8080,
+			DefaultImage:    "./docs/failed_image.jpg",
+			CORSOrigins:     []string{"*"},
+			ShutdownTimeout: 30 * time.Second,
+		},
+		RPC: RPC{
+			Commitment: Commitments{Metadata: "confirmed", Blockhash: "finalized"},
+		},
+		Retry: Retries{
+			RPC:    Retry{Attempts: 4, BaseDelay: 250 * time.Millisecond, MaxDelay: 5 * time.Second},
+			Origin: Retry{Attempts: 2, BaseDelay: 500 * time.Millisecond, MaxDelay: 3 * time.Second}, //Gateways also fail over
+		},
+		Gateways: Gateways{
+			IPFS:    []string{"https://w3s.link", "https://ipfs.io", "https://dweb.link"},
+			Arweave: []string{"https://arweave.net", "https://ar-io.net"},
+		},
+		Media: Media{
+			DefaultSize:     720,
+			CacheDir:        "./cache",
+			MetadataTimeout: 5 * time.Second,
+			DownloadTimeout: 10 * time.Second,
+			ExemptImages: []string{
+				"2kMpEJCZL8vEDZe7YPLMCS9Y3WKSAMedXBn7xHPvsWvi",
+				"7xKXtg2CW87d97TXJSDpbD5jBkheTqA83TZRuJosgAsU",
+				"AFbX8oGjGpmVFywbVouvhQSRmiW2aR1mohfahi4Y2AdB",
+				"CKfatsPMUf8SkiURsDXs7eK6GWb4Jsd6UDbs7twMCWxo",
+				"EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v",
+				"Es9vMFrzaCERmJfrF4H2FYD4KCoNkY11McCe8BenwNYB",
+				"mSoLzYCxHdYgdzU16g5QSh3i5K3z3KZK7ytfqcJm7So",
+				"So11111111111111111111111111111111111111112",
+			},
+		},
+		Resize: Resize{
+			QueueDepth:           64,
+			JobTimeout:           30 * time.Second,
+			MaxBytes:             50 << 20,
+			MaxPixels:            40_000_000,
+			MaxFrames:            1000,
+			MaxAnimationFrames:   150,
+			MaxAnimationDuration: 30 * time.Second,
+		},
+		//The ws client preallocates ~5 MB of buffers per subscription, so 4 mints already hold ~80 MB,
+		//more than the 5 program subscriptions used above it
+		Watcher: Watcher{MaxMints: 4},
+		Webhook: Webhook{Workers: 4},
+		Tokens:  Tokens{SupplyRefresh: 5 * time.Minute},
+	}
+}
+
+// Validate reports every invalid setting at once
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.HTTP.Port > 0 && c.HTTP.Port < 1<<16, "http.port: %d is not a valid port", c.HTTP.Port)
+	check(c.HTTP.DefaultImage != "", "http.default_image: required")
+	check(c.HTTP.ShutdownTimeout > 0, "http.shutdown_timeout: must be positive")
+	check(len(c.HTTP.CORSOrigins) > 0, "http.cors_origins: required, use * to allow every origin")
+	check(c.Database.Path != "", "database.path: required")
+
+	check(len(c.RPC.Endpoints) > 0, "rpc.endpoints: required")
+	for _, e := range c.RPC.Endpoints {
+		check(validURL(strings.Split(e, "|")[0], "http", "https"), "rpc.endpoints: invalid endpoint %s", redactEndpoint(e))
+	}
+	for _, e := range c.RPC.DASEndpoints {
+		check(validURL(strings.Split(e, "|")[0], "http", "https"), "rpc.das_endpoints: invalid endpoint %s", redactEndpoint(e))
+	}
+	check(c.RPC.WSURL == "" || validURL(c.RPC.WSURL, "ws", "wss"), "rpc.ws_url: invalid url %s", redactURL(c.RPC.WSURL))
+	check(validCommitment(c.RPC.Commitment.Metadata), "rpc.commitment.metadata: invalid commitment %s", c.RPC.Commitment.Metadata)
+	check(validCommitment(c.RPC.Commitment.Blockhash), "rpc.commitment.blockhash: invalid commitment %s", c.RPC.Commitment.Blockhash)
+
+	for name, r := range map[string]Retry{"rpc": c.Retry.RPC, "origin": c.Retry.Origin} {
+		check(r.Attempts > 0, "retry.%s.attempts: must be at least 1", name)
+		check(r.BaseDelay > 0 && r.MaxDelay >= r.BaseDelay, "retry.%s: delays must be positive with max_delay >= base_delay", name)
+	}
+
+	check(len(c.Gateways.IPFS) > 0, "gateways.ipfs: required")
+	check(len(c.Gateways.Arweave) > 0, "gateways.arweave: required")
+	for _, g := range append(append([]string{}, c.Gateways.IPFS...), c.Gateways.Arweave...) {
+		check(validURL(g, "http", "https"), "gateways: invalid gateway %s", g)
+	}
+
+	check(c.Media.DefaultSize > 0, "media.default_size: must be positive")
+	check(c.Media.CacheDir != "", "media.cache_dir: required")
+	check(c.Media.MetadataTimeout > 0, "media.metadata_timeout: must be positive")
+	check(c.Media.DownloadTimeout > 0, "media.download_timeout: must be positive")
+
+	check(c.Resize.Workers >= 0, "resize.workers: must not be negative")
+	check(c.Resize.QueueDepth > 0, "resize.queue_depth: must be positive")
+	check(c.Resize.JobTimeout >= 0, "resize.job_timeout: must not be negative")
+	check(c.Resize.MaxBytes >= 0 && c.Resize.MaxPixels >= 0 && c.Resize.MaxFrames >= 0, "resize: limits must not be negative")
+	check(c.Resize.MaxAnimationFrames >= 0 && c.Resize.MaxAnimationDuration >= 0, "resize: animation limits must not be negative")
+
+	check(c.Watcher.MaxMints > 0, "watcher.max_mints: must be positive")
+	check(c.Webhook.Workers > 0, "webhook.workers: must be positive")
+	check(c.Tokens.SupplyRefresh > 0, "tokens.supply_refresh: must be positive")
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %w", ErrInvalidConfig, errors.Join(errs...))
+	}
+	return nil
+}
+
+// Redacted returns a copy safe to print, without the webhook secret or the paths & credentials of rpc urls
+func (c *Config) Redacted() *Config {
+	r := *c
+	r.RPC.Endpoints = redactEndpoints(c.RPC.Endpoints)
+	r.RPC.DASEndpoints = redactEndpoints(c.RPC.DASEndpoints)
+	r.RPC.WSURL = redactURL(c.RPC.WSURL)
+	if r.Webhook.Secret != "" {
+		r.Webhook.Secret = "REDACTED"
+	}
+	return &r
+}
+
+func (c *Config) YAML() ([]byte, error) {
+	return yaml.Marshal(c)
+}
+
+func validURL(raw string, schemes ...string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	for _, s := range schemes {
+		if u.Scheme == s {
+			return true
+		}
+	}
+	return false
+}
+
+func validCommitment(c string) bool {
+	return c == "processed" || c == "confirmed" || c == "finalized"
+}
+
+func redactEndpoints(endpoints []string) []string {
+	if endpoints == nil {
+		return nil
+	}
+	redacted := make([]string, len(endpoints))
+	for i, e := range endpoints {
+		redacted[i] = redactEndpoint(e)
+	}
+	return redacted
+}
+
+// redactEndpoint redacts the url of a url[|weight[|requests per second]] endpoint
+func redactEndpoint(e string) string {
+	parts := strings.SplitN(e, "|", 2)
+	parts[0] = redactURL(parts[0])
+	return strings.Join(parts, "|")
+}
+
+// redactURL keeps the scheme & host of raw, api keys are commonly in the path, query or user info
+func redactURL(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return "REDACTED"
+	}
+	if u.User == nil && strings.Trim(u.Path, "/") == "" && u.RawQuery == "" {
+		return raw
+	}
+	return fmt.Sprintf("%s://%s/REDACTED", u.Scheme, u.Host)
+}
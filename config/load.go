@@ -0,0 +1,191 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// setting is a single configuration value, settable from the yaml file, the environment & a flag
+type setting struct {
+	flag  string
+	env   []string //Later keys take priority
+	usage string
+	value flag.Value
+}
+
+// settings binds every configuration value of c to its environment keys & flag
+func (c *Config) settings() []setting {
+	return []setting{
+		{"http-port", []string{"HTTP_PORT"}, "port the api listens on", (*intValue)(&c.HTTP.Port)},
+		{"http-default-image", []string{"HTTP_DEFAULT_IMAGE"}, "image served when an image cannot be loaded", (*stringValue)(&c.HTTP.DefaultImage)},
+		{"http-cors-origins", []string{"HTTP_CORS_ORIGINS"}, "comma separated allowed origins, * allows every origin", (*listValue)(&c.HTTP.CORSOrigins)},
+		{"http-shutdown-timeout", []string{"HTTP_SHUTDOWN_TIMEOUT"}, "how long in-flight requests & background refreshes are waited for on shutdown", (*durationValue)(&c.HTTP.ShutdownTimeout)},
+		{"db", []string{"DB_DATABASE"}, "sqlite database path", (*stringValue)(&c.Database.Path)},
+		{"rpc-endpoints", []string{"RPC_URL", "RPC_ENDPOINTS"}, "comma separated url[|weight[|requests per second]] rpc endpoints", (*listValue)(&c.RPC.Endpoints)},
+		{"das-endpoints", []string{"DAS_URL"}, "comma separated DAS api endpoints in the rpc endpoint format, defaults to the rpc endpoints", (*listValue)(&c.RPC.DASEndpoints)},
+		{"rpc-ws-url", []string{"RPC_WS_URL"}, "rpc websocket url, enables the metadata watcher", (*stringValue)(&c.RPC.WSURL)},
+		{"rpc-commitment-metadata", []string{"RPC_COMMITMENT_METADATA"}, "commitment metadata is read at", (*stringValue)(&c.RPC.Commitment.Metadata)},
+		{"rpc-commitment-blockhash", []string{"RPC_COMMITMENT_BLOCKHASH"}, "commitment blockhashes are read at", (*stringValue)(&c.RPC.Commitment.Blockhash)},
+		{"retry-rpc-attempts", []string{"RETRY_RPC_ATTEMPTS"}, "attempts per rpc request", (*intValue)(&c.Retry.RPC.Attempts)},
+		{"retry-rpc-base-delay", []string{"RETRY_RPC_BASE_DELAY"}, "rpc retry backoff base", (*durationValue)(&c.Retry.RPC.BaseDelay)},
+		{"retry-rpc-max-delay", []string{"RETRY_RPC_MAX_DELAY"}, "longest rpc retry backoff", (*durationValue)(&c.Retry.RPC.MaxDelay)},
+		{"retry-origin-attempts", []string{"RETRY_ORIGIN_ATTEMPTS"}, "attempts per metadata & image download", (*intValue)(&c.Retry.Origin.Attempts)},
+		{"retry-origin-base-delay", []string{"RETRY_ORIGIN_BASE_DELAY"}, "download retry backoff base", (*durationValue)(&c.Retry.Origin.BaseDelay)},
+		{"retry-origin-max-delay", []string{"RETRY_ORIGIN_MAX_DELAY"}, "longest download retry backoff", (*durationValue)(&c.Retry.Origin.MaxDelay)},
+		{"ipfs-gateways", []string{"IPFS_GATEWAYS"}, "comma separated ipfs gateways", (*listValue)(&c.Gateways.IPFS)},
+		{"arweave-gateways", []string{"ARWEAVE_GATEWAYS"}, "comma separated arweave gateways", (*listValue)(&c.Gateways.Arweave)},
+		{"media-default-size", []string{"MEDIA_DEFAULT_SIZE"}, "height images are resized to", (*intValue)(&c.Media.DefaultSize)},
+		{"media-cache-dir", []string{"MEDIA_CACHE_DIR"}, "directory originals & resized images are stored in", (*stringValue)(&c.Media.CacheDir)},
+		{"media-metadata-timeout", []string{"MEDIA_METADATA_TIMEOUT"}, "timeout of metadata file downloads", (*durationValue)(&c.Media.MetadataTimeout)},
+		{"media-download-timeout", []string{"MEDIA_DOWNLOAD_TIMEOUT"}, "timeout of image & media downloads", (*durationValue)(&c.Media.DownloadTimeout)},
+		{"media-exempt-images", []string{"MEDIA_EXEMPT_IMAGES"}, "comma separated mints whose cached image is never cleared", (*listValue)(&c.Media.ExemptImages)},
+		{"resize-workers", []string{"RESIZE_WORKERS"}, "concurrent resizes, 0 uses one per cpu", (*intValue)(&c.Resize.Workers)},
+		{"resize-queue-depth", []string{"RESIZE_QUEUE_DEPTH"}, "resizes queued before requests are rejected", (*intValue)(&c.Resize.QueueDepth)},
+		{"resize-job-timeout", []string{"RESIZE_JOB_TIMEOUT"}, "longest a resize may take including time queued, 0 disables", (*durationValue)(&c.Resize.JobTimeout)},
+		{"resize-max-bytes", []string{"RESIZE_MAX_BYTES"}, "largest image decoded, 0 disables", (*intValue)(&c.Resize.MaxBytes)},
+		{"resize-max-pixels", []string{"RESIZE_MAX_PIXELS"}, "most pixels of a decoded image, 0 disables", (*intValue)(&c.Resize.MaxPixels)},
+		{"resize-max-frames", []string{"RESIZE_MAX_FRAMES"}, "most frames of a decoded animation, 0 disables", (*intValue)(&c.Resize.MaxFrames)},
+		{"resize-max-animation-frames", []string{"RESIZE_MAX_ANIMATION_FRAMES"}, "frames kept when resizing an animation, 0 disables", (*intValue)(&c.Resize.MaxAnimationFrames)},
+		{"resize-max-animation-duration", []string{"RESIZE_MAX_ANIMATION_DURATION"}, "duration kept when resizing an animation, 0 disables", (*durationValue)(&c.Resize.MaxAnimationDuration)},
+		{"watch-max-mints", []string{"WATCH_MAX_MINTS"}, "cached mints above which filtered program subscriptions are used", (*intValue)(&c.Watcher.MaxMints)},
+		{"webhook-secret", []string{"WEBHOOK_SECRET"}, "webhook signing secret, enables the metadata webhook", (*stringValue)(&c.Webhook.Secret)},
+		{"webhook-workers", []string{"WEBHOOK_WORKERS"}, "concurrent webhook refreshes", (*intValue)(&c.Webhook.Workers)},
+		{"token-supply-refresh", []string{"TOKEN_SUPPLY_REFRESH"}, "age at which fungible token supply is refreshed", (*durationValue)(&c.Tokens.SupplyRefresh)},
+	}
+}
+
+// Load reads the configuration from the yaml file given by --config or CONFIG_FILE, the environment & args.
+// printConfig is set when --print-config was passed. The configuration is validated before it is returned.
+func Load(args []string) (_ *Config, printConfig bool, err error) {
+	c := Default()
+	settings := c.settings()
+
+	fs := flag.NewFlagSet("nft-proxy", flag.ContinueOnError)
+	file := fs.String("config", os.Getenv("CONFIG_FILE"), "yaml configuration file (env CONFIG_FILE)")
+	fs.BoolVar(&printConfig, "print-config", false, "print the configuration & exit")
+
+	//Flags are applied last, so only their raw values are kept while parsing
+	flags := make([]rawValue, len(settings))
+	for i, s := range settings {
+		fs.Var(&flags[i], s.flag, fmt.Sprintf("%s (env %s)", s.usage, strings.Join(s.env, ", ")))
+	}
+	err = fs.Parse(args)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if *file != "" {
+		data, err := os.ReadFile(*file)
+		if err != nil {
+			return nil, false, err
+		}
+		err = yaml.UnmarshalStrict(data, c)
+		if err != nil {
+			return nil, false, fmt.Errorf("%w: %s: %s", ErrInvalidConfig, *file, err)
+		}
+	}
+
+	for _, s := range settings {
+		for _, key := range s.env {
+			v := os.Getenv(key)
+			if v == "" {
+				continue
+			}
+			err = s.value.Set(v)
+			if err != nil {
+				return nil, false, fmt.Errorf("%w: %s: %s", ErrInvalidConfig, key, err)
+			}
+		}
+	}
+
+	for i, s := range settings {
+		if !flags[i].set {
+			continue
+		}
+		err = s.value.Set(flags[i].value)
+		if err != nil {
+			return nil, false, fmt.Errorf("%w: --%s: %s", ErrInvalidConfig, s.flag, err)
+		}
+	}
+
+	return c, printConfig, c.Validate()
+}
+
+type rawValue struct {
+	value string
+	set   bool
+}
+
+func (v *rawValue) String() string {
+	return v.value
+}
+
+func (v *rawValue) Set(s string) error {
+	v.value, v.set = s, true
+	return nil
+}
+
+type stringValue string
+
+func (v *stringValue) String() string {
+	return string(*v)
+}
+
+func (v *stringValue) Set(s string) error {
+	*v = stringValue(strings.TrimSpace(s))
+	return nil
+}
+
+type intValue int
+
+func (v *intValue) String() string {
+	return strconv.Itoa(int(*v))
+}
+
+func (v *intValue) Set(s string) error {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return err
+	}
+	*v = intValue(n)
+	return nil
+}
+
+type durationValue time.Duration
+
+func (v *durationValue) String() string {
+	return time.Duration(*v).String()
+}
+
+func (v *durationValue) Set(s string) error {
+	d, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil {
+		return err
+	}
+	*v = durationValue(d)
+	return nil
+}
+
+// listValue is a comma separated list, empty entries are dropped
+type listValue []string
+
+func (v *listValue) String() string {
+	return strings.Join(*v, ",")
+}
+
+func (v *listValue) Set(s string) error {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	*v = list
+	return nil
+}
@@ -0,0 +1,191 @@
+package libreplex
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	bin "github.com/gagliardetto/binary"
+	"github.com/gagliardetto/solana-go"
+)
+
+var ProgramID = solana.MustPublicKeyFromBase58("LibrQsXf9V1DmTtJLkEghoaF1kjJcAzWiEGoJn8mz7p")
+
+// MetadataDiscriminator is the anchor account discriminator, sha256("account:Metadata")[:8]
+var MetadataDiscriminator = []byte{72, 11, 121, 26, 111, 181, 85, 93}
+
+var ErrNotMetadata = errors.New("not a libreplex metadata account")
+
+// AssetType is the variant of the asset a metadata account points to
+type AssetType uint8
+
+const (
+	AssetNone AssetType = iota
+	AssetJson
+	AssetChainRenderer
+	AssetImage
+	AssetInscription
+)
+
+// Asset is the content of a metadata account, a json file, an image or rendered on-chain
+type Asset struct {
+	Type        AssetType
+	Url         string            //Json & Image
+	Description *string           //Image & Inscription
+	ProgramId   *solana.PublicKey //ChainRenderer
+	Inscription *Inscription
+}
+
+type Inscription struct {
+	BaseDataAccountId solana.PublicKey
+	InscriptionId     solana.PublicKey
+	DataType          string
+	Chunks            uint32
+}
+
+type Metadata struct {
+	Mint            solana.PublicKey
+	UpdateAuthority solana.PublicKey
+	Creator         solana.PublicKey
+	IsMutable       bool
+	Group           *solana.PublicKey //Group (collection) the metadata is a member of
+	Name            string
+	Symbol          string
+	Asset           Asset
+	//Extensions follow the asset, we have no use for them
+}
+
+// FindMetadataAddress returns the metadata PDA of mint
+func FindMetadataAddress(mint solana.PublicKey) (solana.PublicKey, uint8, error) {
+	return solana.FindProgramAddress([][]byte{[]byte("metadata"), mint[:]}, ProgramID)
+}
+
+func (m *Metadata) UnmarshalWithDecoder(dec *bin.Decoder) (err error) {
+	discriminator, err := dec.ReadBytes(8)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(discriminator, MetadataDiscriminator) {
+		return ErrNotMetadata
+	}
+
+	for _, pk := range []*solana.PublicKey{&m.Mint, &m.UpdateAuthority, &m.Creator} {
+		*pk, err = readPublicKey(dec)
+		if err != nil {
+			return err
+		}
+	}
+
+	m.IsMutable, err = dec.ReadBool()
+	if err != nil {
+		return err
+	}
+
+	m.Group, err = readOptionalPublicKey(dec)
+	if err != nil {
+		return err
+	}
+
+	m.Name, err = readString(dec)
+	if err != nil {
+		return err
+	}
+	m.Symbol, err = readString(dec)
+	if err != nil {
+		return err
+	}
+
+	return m.Asset.UnmarshalWithDecoder(dec)
+}
+
+func (a *Asset) UnmarshalWithDecoder(dec *bin.Decoder) (err error) {
+	variant, err := dec.ReadUint8()
+	if err != nil {
+		return err
+	}
+	a.Type = AssetType(variant)
+
+	switch a.Type {
+	case AssetNone:
+	case AssetJson:
+		a.Url, err = readString(dec)
+	case AssetChainRenderer:
+		var pk solana.PublicKey
+		pk, err = readPublicKey(dec)
+		a.ProgramId = &pk
+	case AssetImage:
+		a.Url, err = readString(dec)
+		if err != nil {
+			return err
+		}
+		a.Description, err = readOptionalString(dec)
+	case AssetInscription:
+		var ins Inscription
+		ins.BaseDataAccountId, err = readPublicKey(dec)
+		if err != nil {
+			return err
+		}
+		ins.InscriptionId, err = readPublicKey(dec)
+		if err != nil {
+			return err
+		}
+		ins.DataType, err = readString(dec)
+		if err != nil {
+			return err
+		}
+		a.Description, err = readOptionalString(dec)
+		if err != nil {
+			return err
+		}
+		ins.Chunks, err = dec.ReadUint32(bin.LE)
+		a.Inscription = &ins
+	default:
+		return fmt.Errorf("unknown libreplex asset variant %v", variant)
+	}
+	return err
+}
+
+// readString reads a borsh string, prefixed with a u32 length
+func readString(dec *bin.Decoder) (string, error) {
+	size, err := dec.ReadUint32(bin.LE)
+	if err != nil {
+		return "", err
+	}
+	b, err := dec.ReadBytes(int(size))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func readPublicKey(dec *bin.Decoder) (solana.PublicKey, error) {
+	b, err := dec.ReadBytes(32)
+	if err != nil {
+		return solana.PublicKey{}, err
+	}
+	return solana.PublicKeyFromBytes(b), nil
+}
+
+func readOptionalPublicKey(dec *bin.Decoder) (*solana.PublicKey, error) {
+	ok, err := dec.ReadBool()
+	if err != nil || !ok {
+		return nil, err
+	}
+	pk, err := readPublicKey(dec)
+	if err != nil {
+		return nil, err
+	}
+	return &pk, nil
+}
+
+func readOptionalString(dec *bin.Decoder) (*string, error) {
+	ok, err := dec.ReadBool()
+	if err != nil || !ok {
+		return nil, err
+	}
+	s, err := readString(dec)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
@@ -3,33 +3,40 @@ package nft_proxy
 import "time"
 
 type Media struct {
-	ID              uint      `json:"-" gorm:"primaryKey"`
-	Mint            string    `json:"mint" gorm:"uniqueIndex"`
-	MintDecimals    uint8     `json:"decimals"`
-	ImageUri        string    `json:"imageUri"`
-	ImageType       string    `json:"imageType"`
-	MediaUri        string    `json:"mediaUri,omitempty"`
-	MediaType       string    `json:"mediaType,omitempty"`
-	LocalPath       string    `json:"-"`
-	Name            string    `json:"name,omitempty"`
-	Symbol          string    `json:"symbol,omitempty"`
-	UpdateAuthority string    `json:"updateAuthority,omitempty"`
-	CreatedAt       time.Time `json:"-"`
+	ID              uint             `json:"-" gorm:"primaryKey"`
+	Mint            string           `json:"mint" gorm:"uniqueIndex"`
+	MintDecimals    uint8            `json:"decimals"`
+	ImageUri        string           `json:"imageUri"`
+	ImageType       string           `json:"imageType"`
+	MediaUri        string           `json:"mediaUri,omitempty"`
+	MediaType       string           `json:"mediaType,omitempty"`
+	LocalPath       string           `json:"-"`
+	Name            string           `json:"name,omitempty"`
+	Symbol          string           `json:"symbol,omitempty"`
+	UpdateAuthority string           `json:"updateAuthority,omitempty"`
+	TokenStandard   TokenStandard    `json:"tokenStandard,omitempty"`
+	Edition         *Edition         `json:"edition,omitempty"`
+	Extensions      *TokenExtensions `json:"extensions,omitempty"`
+	CreatedAt       time.Time        `json:"-"`
 }
 
 type SolanaMedia struct {
-	ID              uint      `json:"-" gorm:"primaryKey"`
-	Mint            string    `json:"mint" gorm:"uniqueIndex"`
-	MintDecimals    uint8     `json:"decimals"`
-	ImageUri        string    `json:"imageUri"`
-	ImageType       string    `json:"ImageType"`
-	MediaUri        string    `json:"mediaUri"`
-	MediaType       string    `json:"mediaType"`
-	LocalPath       string    `json:"-"`
-	Name            string    `json:"name"`
-	Symbol          string    `json:"symbol"`
-	UpdateAuthority string    `json:"updateAuthority"`
-	CreatedAt       time.Time `json:"-"`
+	ID              uint             `json:"-" gorm:"primaryKey"`
+	Mint            string           `json:"mint" gorm:"uniqueIndex"`
+	MintDecimals    uint8            `json:"decimals"`
+	ImageUri        string           `json:"imageUri"`
+	ImageType       string           `json:"ImageType"`
+	MediaUri        string           `json:"mediaUri"`
+	MediaType       string           `json:"mediaType"`
+	LocalPath       string           `json:"-"`
+	Name            string           `json:"name"`
+	Symbol          string           `json:"symbol"`
+	UpdateAuthority string           `json:"updateAuthority"`
+	Slot            uint64           `json:"slot"` //Context slot the metadata was read at, 0 when unknown
+	TokenStandard   TokenStandard    `json:"tokenStandard"`
+	Edition         *Edition         `json:"edition,omitempty" gorm:"serializer:json"`
+	Extensions      *TokenExtensions `json:"extensions,omitempty" gorm:"serializer:json"`
+	CreatedAt       time.Time        `json:"-"`
 }
 
 func (m *SolanaMedia) Media() *Media {
@@ -45,6 +52,84 @@ func (m *SolanaMedia) Media() *Media {
 		Name:            m.Name,
 		Symbol:          m.Symbol,
 		UpdateAuthority: m.UpdateAuthority,
+		TokenStandard:   m.TokenStandard,
+		Edition:         m.Edition,
+		Extensions:      m.Extensions,
 		CreatedAt:       m.CreatedAt,
 	}
 }
+
+// MediaOriginal links a mint to the content hash of its original downloaded image
+type MediaOriginal struct {
+	ID        uint      `json:"-" gorm:"primaryKey"`
+	Mint      string    `json:"mint" gorm:"uniqueIndex"`
+	ImageUri  string    `json:"imageUri"`
+	Hash      string    `json:"hash" gorm:"index"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"-"`
+	UpdatedAt time.Time `json:"-"`
+}
+
+// MediaBlob is a unique original image, shared by every mint whose image has the same content
+type MediaBlob struct {
+	Hash      string    `json:"hash" gorm:"primaryKey"`
+	Size      int64     `json:"size"`
+	RefCount  int       `json:"refCount"`
+	CreatedAt time.Time `json:"-"`
+}
+
+const (
+	EditionMaster = "master"
+	EditionPrint  = "print"
+)
+
+// Edition labels master editions & the numbered prints minted from them
+type Edition struct {
+	Type          string  `json:"type"`
+	Number        uint64  `json:"number,omitempty"`        //Print number
+	Supply        *uint64 `json:"supply,omitempty"`        //Prints minted from the master edition
+	MaxSupply     *uint64 `json:"maxSupply,omitempty"`     //Nil when unlimited or unknown
+	MasterEdition string  `json:"masterEdition,omitempty"` //Master edition account of a print
+}
+
+// TokenExtensions are the Token-2022 extensions of a mint, so wallets can warn about fees & transfer restrictions
+type TokenExtensions struct {
+	AdditionalMetadata  []MetadataField    `json:"additionalMetadata,omitempty"`
+	TransferFee         *TransferFeeConfig `json:"transferFee,omitempty"`
+	NonTransferable     bool               `json:"nonTransferable,omitempty"`
+	PermanentDelegate   string             `json:"permanentDelegate,omitempty"`
+	TransferHookProgram string             `json:"transferHookProgram,omitempty"`
+	MetadataPointer     string             `json:"metadataPointer,omitempty"`
+	GroupPointer        string             `json:"groupPointer,omitempty"`
+	GroupMemberPointer  string             `json:"groupMemberPointer,omitempty"`
+	Group               *TokenGroup        `json:"group,omitempty"`       //Set when the mint is a group (collection)
+	GroupMember         *TokenGroupMember  `json:"groupMember,omitempty"` //Set when the mint is a member of a group
+}
+
+type MetadataField struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type TransferFeeConfig struct {
+	ConfigAuthority   string      `json:"configAuthority,omitempty"`
+	WithdrawAuthority string      `json:"withdrawAuthority,omitempty"`
+	Older             TransferFee `json:"older"`
+	Newer             TransferFee `json:"newer"` //Applies from its epoch onwards
+}
+
+type TransferFee struct {
+	Epoch       uint64 `json:"epoch"`
+	MaximumFee  uint64 `json:"maximumFee"`
+	BasisPoints uint16 `json:"basisPoints"`
+}
+
+type TokenGroup struct {
+	Size    uint64 `json:"size"`
+	MaxSize uint64 `json:"maxSize"`
+}
+
+type TokenGroupMember struct {
+	Group        string `json:"group"`
+	MemberNumber uint64 `json:"memberNumber"`
+}
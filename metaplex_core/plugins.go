@@ -0,0 +1,269 @@
+package metaplex_core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	bin "github.com/gagliardetto/binary"
+	"github.com/gagliardetto/solana-go"
+)
+
+var ErrInvalidPlugins = errors.New("invalid metaplex core plugin data")
+
+var (
+	errLength = errors.New("length exceeds the remaining data")
+	errOption = errors.New("option flag is neither 0 nor 1")
+)
+
+type PluginType uint8
+
+const PluginRoyalties PluginType = 0
+
+// Authority enum variants, only Address carries a key
+const authorityAddress = 3
+
+type RuleSetType uint8
+
+const (
+	RuleSetNone RuleSetType = iota
+	RuleSetProgramAllowList
+	RuleSetProgramDenyList
+)
+
+type Creator struct {
+	Address    solana.PublicKey
+	Percentage uint8
+}
+
+// RuleSet restricts which programs may transfer the asset
+type RuleSet struct {
+	Type     RuleSetType
+	Programs []solana.PublicKey
+}
+
+type Royalties struct {
+	BasisPoints uint16
+	Creators    []Creator
+	RuleSet     RuleSet
+}
+
+// CollectionV1 is the base of a core collection account, followed by the plugins shared by its assets
+type CollectionV1 struct {
+	Key             uint8
+	UpdateAuthority solana.PublicKey
+	Name            string
+	Uri             string
+	NumMinted       uint32
+	CurrentSize     uint32
+}
+
+func (c *CollectionV1) UnmarshalWithDecoder(dec *bin.Decoder) (err error) {
+	defer func() { err = invalid(err, ErrInvalidAsset) }()
+
+	if c.Key, err = dec.ReadUint8(); err != nil {
+		return err
+	}
+	if c.Key != KeyCollectionV1 {
+		return fmt.Errorf("%w: key %d", ErrInvalidAsset, c.Key)
+	}
+	if c.UpdateAuthority, err = readPublicKey(dec); err != nil {
+		return err
+	}
+	if c.Name, err = readString(dec); err != nil {
+		return err
+	}
+	if c.Uri, err = readString(dec); err != nil {
+		return err
+	}
+	if c.NumMinted, err = dec.ReadUint32(binary.LittleEndian); err != nil {
+		return err
+	}
+	c.CurrentSize, err = dec.ReadUint32(binary.LittleEndian)
+	return err
+}
+
+// FindRoyalties returns the royalties plugin of an asset or collection account, nil when it has none
+func FindRoyalties(data []byte) (_ *Royalties, err error) {
+	defer func() { err = invalid(err, ErrInvalidPlugins) }()
+
+	if len(data) == 0 {
+		return nil, ErrInvalidPlugins
+	}
+
+	dec := bin.NewBinDecoder(data)
+	switch data[0] {
+	case KeyAssetV1:
+		err = new(Asset).UnmarshalWithDecoder(dec)
+	case KeyCollectionV1:
+		err = new(CollectionV1).UnmarshalWithDecoder(dec)
+	default:
+		return nil, ErrInvalidPlugins
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	//The plugin header follows the base account when the account has plugins
+	if dec.Remaining() == 0 {
+		return nil, nil
+	}
+	if key, err := dec.ReadUint8(); err != nil || key != KeyPluginHeaderV1 {
+		return nil, ErrInvalidPlugins
+	}
+	registryOffset, err := dec.ReadUint64(binary.LittleEndian)
+	if err != nil || registryOffset >= uint64(len(data)) {
+		return nil, ErrInvalidPlugins
+	}
+
+	offset, err := findPlugin(data[registryOffset:], PluginRoyalties)
+	if err != nil || offset == 0 {
+		return nil, err
+	}
+	if offset >= uint64(len(data)) {
+		return nil, ErrInvalidPlugins
+	}
+	return readRoyalties(bin.NewBinDecoder(data[offset:]))
+}
+
+// findPlugin returns the offset of the plugin of typ from the plugin registry, 0 when the account does not have it
+func findPlugin(registry []byte, typ PluginType) (uint64, error) {
+	dec := bin.NewBinDecoder(registry)
+	if key, err := dec.ReadUint8(); err != nil || key != KeyPluginRegistry {
+		return 0, ErrInvalidPlugins
+	}
+
+	count, err := readLength(dec, 10) //Smallest record is a type, an authority without a key & an offset
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < count; i++ {
+		pluginType, err := dec.ReadUint8()
+		if err != nil {
+			return 0, err
+		}
+		authority, err := dec.ReadUint8()
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case authority == authorityAddress:
+			if _, err = dec.ReadBytes(32); err != nil {
+				return 0, err
+			}
+		case authority > authorityAddress:
+			return 0, fmt.Errorf("%w: authority %d", ErrInvalidPlugins, authority)
+		}
+		offset, err := dec.ReadUint64(binary.LittleEndian)
+		if err != nil {
+			return 0, err
+		}
+
+		if PluginType(pluginType) == typ {
+			return offset, nil
+		}
+	}
+	return 0, nil
+}
+
+func readRoyalties(dec *bin.Decoder) (*Royalties, error) {
+	if typ, err := dec.ReadUint8(); err != nil || PluginType(typ) != PluginRoyalties {
+		return nil, ErrInvalidPlugins
+	}
+
+	var r Royalties
+	var err error
+	if r.BasisPoints, err = dec.ReadUint16(binary.LittleEndian); err != nil {
+		return nil, err
+	}
+
+	count, err := readLength(dec, 33)
+	if err != nil {
+		return nil, err
+	}
+	r.Creators = make([]Creator, count)
+	for i := range r.Creators {
+		if r.Creators[i].Address, err = readPublicKey(dec); err != nil {
+			return nil, err
+		}
+		if r.Creators[i].Percentage, err = dec.ReadUint8(); err != nil {
+			return nil, err
+		}
+	}
+
+	ruleSet, err := dec.ReadUint8()
+	if err != nil {
+		return nil, err
+	}
+	r.RuleSet.Type = RuleSetType(ruleSet)
+	switch r.RuleSet.Type {
+	case RuleSetNone:
+	case RuleSetProgramAllowList, RuleSetProgramDenyList:
+		count, err = readLength(dec, 32)
+		if err != nil {
+			return nil, err
+		}
+		r.RuleSet.Programs = make([]solana.PublicKey, count)
+		for i := range r.RuleSet.Programs {
+			if r.RuleSet.Programs[i], err = readPublicKey(dec); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, ErrInvalidPlugins
+	}
+	return &r, nil
+}
+
+// readOption reads the flag of a borsh option, rejecting anything but 0 or 1
+func readOption(dec *bin.Decoder) (bool, error) {
+	b, err := dec.ReadUint8()
+	if err != nil {
+		return false, err
+	}
+	if b > 1 {
+		return false, errOption
+	}
+	return b == 1, nil
+}
+
+// invalid wraps decoding errors, such as running out of data, in target
+func invalid(err error, target error) error {
+	if err == nil || errors.Is(err, target) {
+		return err
+	}
+	return fmt.Errorf("%w: %s", target, err)
+}
+
+// readLength reads a borsh vec length, rejecting lengths the remaining data cannot hold
+func readLength(dec *bin.Decoder, itemSize int) (int, error) {
+	count, err := dec.ReadUint32(binary.LittleEndian)
+	if err != nil {
+		return 0, err
+	}
+	if int(count) > dec.Remaining()/itemSize {
+		return 0, errLength
+	}
+	return int(count), nil
+}
+
+// readString reads a borsh string, prefixed with a u32 length
+func readString(dec *bin.Decoder) (string, error) {
+	size, err := readLength(dec, 1)
+	if err != nil {
+		return "", err
+	}
+	b, err := dec.ReadBytes(size)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func readPublicKey(dec *bin.Decoder) (solana.PublicKey, error) {
+	b, err := dec.ReadBytes(32)
+	if err != nil {
+		return solana.PublicKey{}, err
+	}
+	return solana.PublicKeyFromBytes(b), nil
+}
@@ -2,52 +2,91 @@ package metaplex_core
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
+
 	bin "github.com/gagliardetto/binary"
 	"github.com/gagliardetto/solana-go"
 )
 
+var ErrInvalidAsset = errors.New("invalid metaplex core account")
+
 type Asset struct {
 	Key             uint8
 	Owner           solana.PublicKey
 	UpdateAuthority *solana.PublicKey `bin:"optional"`
+	Collection      *solana.PublicKey //Set when the update authority is a collection, which also holds shared plugins
 	Name            string
 	Uri             string
-	Seq             []uint64 `bin:"optional"`
+	Seq             *uint64 `bin:"optional"`
 }
 
+const (
+	KeyAssetV1        = 1
+	KeyPluginHeaderV1 = 3
+	KeyPluginRegistry = 4
+	KeyCollectionV1   = 5
+)
+
+// UpdateAuthority enum variants
+const (
+	updateAuthorityNone = iota
+	updateAuthorityAddress
+	updateAuthorityCollection
+)
+
+// UnmarshalWithDecoder decodes the base of an asset account, any plugins follow it
 func (asset *Asset) UnmarshalWithDecoder(dec *bin.Decoder) (err error) {
-	asset.Key, err = dec.ReadUint8()
-	if err != nil {
+	defer func() { err = invalid(err, ErrInvalidAsset) }()
+
+	if asset.Key, err = dec.ReadUint8(); err != nil {
+		return err
+	}
+	if asset.Key != KeyAssetV1 {
+		return fmt.Errorf("%w: key %d", ErrInvalidAsset, asset.Key)
+	}
+	if asset.Owner, err = readPublicKey(dec); err != nil {
 		return err
 	}
 
-	_o, err := dec.ReadBytes(32)
+	uaType, err := dec.ReadUint8()
 	if err != nil {
 		return err
 	}
-	asset.Owner = solana.PublicKeyFromBytes(_o)
-
-	if v, _ := dec.ReadBool(); v {
-		_ua, err := dec.ReadBytes(32)
+	switch uaType {
+	case updateAuthorityNone:
+	case updateAuthorityAddress, updateAuthorityCollection:
+		pk, err := readPublicKey(dec)
 		if err != nil {
 			return err
 		}
-		pk := solana.PublicKeyFromBytes(_ua)
 		asset.UpdateAuthority = &pk
+		if uaType == updateAuthorityCollection {
+			asset.Collection = &pk
+		}
+	default:
+		return fmt.Errorf("%w: update authority %d", ErrInvalidAsset, uaType)
 	}
 
-	size, err := dec.ReadUint32(binary.LittleEndian)
-	_n, err := dec.ReadBytes(int(size))
-	if err != nil {
+	if asset.Name, err = readString(dec); err != nil {
+		return err
+	}
+	if asset.Uri, err = readString(dec); err != nil {
 		return err
 	}
-	asset.Name = string(_n)
 
-	size, err = dec.ReadUint32(binary.LittleEndian)
-	_uri, err := dec.ReadBytes(int(size))
+	//Seq is set once the asset has been compressed
+	if dec.Remaining() == 0 {
+		return nil
+	}
+	hasSeq, err := readOption(dec)
+	if err != nil || !hasSeq {
+		return err
+	}
+	seq, err := dec.ReadUint64(binary.LittleEndian)
 	if err != nil {
 		return err
 	}
-	asset.Uri = string(_uri)
+	asset.Seq = &seq
 	return nil
 }
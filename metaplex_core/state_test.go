@@ -0,0 +1,146 @@
+package metaplex_core
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	bin "github.com/gagliardetto/binary"
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+)
+
+// loadAccount reads the data of a getAccountInfo shaped account fixture
+func loadAccount(t testing.TB, name string) []byte {
+	t.Helper()
+	raw, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var acc rpc.KeyedAccount
+	if err := json.Unmarshal(raw, &acc); err != nil {
+		t.Fatal(err)
+	}
+	return acc.Account.Data.GetBinary()
+}
+
+func TestAsset_Golden(t *testing.T) {
+	t.Run("Plugins", func(t *testing.T) {
+		data := loadAccount(t, "asset.json")
+
+		var asset Asset
+		if err := asset.UnmarshalWithDecoder(bin.NewBinDecoder(data)); err != nil {
+			t.Fatal(err)
+		}
+		if asset.Name != "Core Asset #42" || asset.Uri != "https://arweave.net/core-asset-42.json" || asset.Collection == nil || asset.UpdateAuthority == nil || *asset.UpdateAuthority != *asset.Collection || asset.Seq != nil {
+			t.Fatalf("Unexpected asset %+v", asset)
+		}
+
+		royalties, err := FindRoyalties(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if royalties == nil || royalties.BasisPoints != 500 || len(royalties.Creators) != 2 || royalties.Creators[0].Percentage != 60 {
+			t.Fatalf("Unexpected royalties %+v", royalties)
+		}
+		if rs := royalties.RuleSet; rs.Type != RuleSetProgramDenyList || len(rs.Programs) != 1 || rs.Programs[0] != solana.MustPublicKeyFromBase58("M2mx93ekt1fmXSVkTrUL9xVFHkmME8HTUi5Cyc5aF7K") {
+			t.Fatalf("Unexpected rule set %+v", rs)
+		}
+	})
+
+	t.Run("No Plugins", func(t *testing.T) {
+		data := loadAccount(t, "asset_no_plugins.json")
+
+		var asset Asset
+		if err := asset.UnmarshalWithDecoder(bin.NewBinDecoder(data)); err != nil {
+			t.Fatal(err)
+		}
+		if asset.UpdateAuthority == nil || asset.Collection != nil || asset.Seq == nil || *asset.Seq != 7 {
+			t.Fatalf("Unexpected asset %+v", asset)
+		}
+
+		royalties, err := FindRoyalties(data)
+		if err != nil || royalties != nil {
+			t.Fatalf("Expected no royalties, got %+v (%v)", royalties, err)
+		}
+	})
+
+	t.Run("Collection", func(t *testing.T) {
+		data := loadAccount(t, "collection.json")
+
+		var collection CollectionV1
+		if err := collection.UnmarshalWithDecoder(bin.NewBinDecoder(data)); err != nil {
+			t.Fatal(err)
+		}
+		if collection.Name != "Core Collection" || collection.NumMinted != 1200 || collection.CurrentSize != 1187 {
+			t.Fatalf("Unexpected collection %+v", collection)
+		}
+
+		royalties, err := FindRoyalties(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if royalties == nil || royalties.BasisPoints != 250 || len(royalties.Creators) != 1 || royalties.RuleSet.Type != RuleSetNone {
+			t.Fatalf("Unexpected royalties %+v", royalties)
+		}
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		data := loadAccount(t, "asset.json")
+		for _, n := range []int{0, 1, 33, 34, 66, 80, 125} {
+			if err := new(Asset).UnmarshalWithDecoder(bin.NewBinDecoder(data[:n])); !errors.Is(err, ErrInvalidAsset) {
+				t.Fatalf("Expected ErrInvalidAsset for %d bytes, got %v", n, err)
+			}
+		}
+		for _, n := range []int{0, 150, 200, len(data) - 20} {
+			if _, err := FindRoyalties(data[:n]); !errors.Is(err, ErrInvalidPlugins) {
+				t.Fatalf("Expected ErrInvalidPlugins for %d bytes, got %v", n, err)
+			}
+		}
+	})
+}
+
+// seedAccounts adds every fixture & a few truncations of each to the corpus
+func seedAccounts(f *testing.F) {
+	for _, name := range []string{"asset.json", "asset_no_plugins.json", "collection.json"} {
+		data := loadAccount(f, name)
+		f.Add(data)
+		for _, n := range []int{1, 33, len(data) / 2, len(data) - 1} {
+			f.Add(data[:n])
+		}
+	}
+}
+
+func FuzzAsset(f *testing.F) {
+	seedAccounts(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var asset Asset
+		if err := asset.UnmarshalWithDecoder(bin.NewBinDecoder(data)); err != nil {
+			if !errors.Is(err, ErrInvalidAsset) {
+				t.Fatalf("Expected ErrInvalidAsset, got %v", err)
+			}
+			return
+		}
+		if len(asset.Name)+len(asset.Uri) > len(data) {
+			t.Fatalf("Decoded %d bytes of strings from %d bytes", len(asset.Name)+len(asset.Uri), len(data))
+		}
+	})
+}
+
+func FuzzFindRoyalties(f *testing.F) {
+	seedAccounts(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		royalties, err := FindRoyalties(data)
+		if err != nil {
+			if !errors.Is(err, ErrInvalidPlugins) {
+				t.Fatalf("Expected ErrInvalidPlugins, got %v", err)
+			}
+			return
+		}
+		if royalties != nil && (len(royalties.Creators)*33+len(royalties.RuleSet.Programs)*32) > len(data) {
+			t.Fatalf("Decoded more creators & programs than %d bytes can hold", len(data))
+		}
+	})
+}
@@ -17,6 +17,10 @@ type NFTMetadataSimple struct {
 	Files []NFTFiles `json:"files"`
 
 	UpdateAuthority string `json:"updateAuthority"`
+
+	TokenStandard TokenStandard    `json:"-"`
+	Edition       *Edition         `json:"-"`
+	Extensions    *TokenExtensions `json:"-"` //Read from the mint, not the metadata file
 }
 
 func (m *NFTMetadataSimple) AnimationFile() *NFTFiles {
@@ -0,0 +1,32 @@
+package nft_proxy
+
+import "time"
+
+// SolanaRoyalties are the validated royalties of a mint, cached until its metadata is refreshed
+type SolanaRoyalties struct {
+	ID                   uint             `json:"-" gorm:"primaryKey"`
+	Mint                 string           `json:"mint" gorm:"uniqueIndex"`
+	SellerFeeBasisPoints uint16           `json:"sellerFeeBasisPoints"`
+	Creators             []RoyaltyCreator `json:"creators" gorm:"serializer:json"`
+	RuleSet              *RoyaltyRuleSet  `json:"ruleSet,omitempty" gorm:"serializer:json"` //Metaplex Core program allow or deny list
+	ProgrammableRuleSet  string           `json:"programmableRuleSet,omitempty"`            //Token auth rules of a programmable NFT
+	Collection           string           `json:"collection,omitempty"`                     //Set when inherited from the Metaplex Core collection
+	Slot                 uint64           `json:"-"`
+	UpdatedAt            time.Time        `json:"updatedAt"`
+}
+
+type RoyaltyCreator struct {
+	Address  string `json:"address"`
+	Verified bool   `json:"verified"` //Metaplex Core creators are set by the update authority & never verified
+	Share    uint8  `json:"share"`    //Percentage of the royalties
+}
+
+const (
+	RuleSetAllowList = "allowList"
+	RuleSetDenyList  = "denyList"
+)
+
+type RoyaltyRuleSet struct {
+	Type     string   `json:"type"`
+	Programs []string `json:"programs"`
+}
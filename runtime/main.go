@@ -1,27 +1,76 @@
 package main
 
 import (
+	ctx "context"
+	"errors"
+	"fmt"
+	"github.com/alphabatem/nft-proxy/config"
 	services "github.com/alphabatem/nft-proxy/service"
 	"github.com/babilu-online/common/context"
 	"github.com/joho/godotenv"
+	"io/fs"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
+// app holds the services with work to finish on shutdown
+type app struct {
+	sqlite  *services.SqliteService
+	solana  *services.SolanaService
+	tokens  *services.TokenService
+	watcher *services.WatcherService
+	webhook *services.WebhookService
+	http    *services.HttpService
+}
+
 func main() {
 
+	//.env is optional when configured from a yaml file
 	err := godotenv.Load()
-	if err != nil {
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
 		log.Fatal("Error loading .env file")
 	}
 
-	ctx, err := context.NewCtx(
-		&services.SqliteService{},
-		&services.StatService{},
-		&services.ResizeService{},
-		&services.SolanaService{},
-		&services.SolanaImageService{},
-		&services.ImageService{},
-		&services.HttpService{},
+	cfg, printConfig, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if printConfig {
+		out, err := cfg.Redacted().YAML()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Print(string(out))
+		return
+	}
+
+	a := app{
+		sqlite:  &services.SqliteService{Config: cfg},
+		solana:  &services.SolanaService{Config: cfg},
+		tokens:  &services.TokenService{Config: cfg},
+		watcher: &services.WatcherService{Config: cfg},
+		webhook: &services.WebhookService{Config: cfg},
+		http:    &services.HttpService{Config: cfg},
+	}
+
+	mainContext, err := context.NewCtx(
+		a.sqlite,
+		&services.StatService{Config: cfg},
+		&services.ResizeService{Config: cfg},
+		&services.StoreService{Config: cfg},
+		&services.GatewayService{Config: cfg},
+		a.solana,
+		&services.SolanaImageService{Config: cfg},
+		&services.ImageService{Config: cfg},
+		a.tokens,
+		&services.RoyaltyService{Config: cfg},
+		&services.WalletService{Config: cfg},
+		a.watcher,
+		a.webhook,
+		a.http,
 	)
 
 	if err != nil {
@@ -29,6 +78,59 @@ func main() {
 		return
 	}
 
-	err = ctx.Run()
-	log.Fatal(err)
+	signals, stop := signal.NotifyContext(ctx.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	//Run returns once the http server stops serving
+	done := make(chan error, 1)
+	go func() {
+		done <- mainContext.Run()
+	}()
+
+	select {
+	case err = <-done:
+		log.Fatal(err)
+	case <-signals.Done():
+		stop() //A second signal exits immediately
+		log.Printf("Shutting down, waiting up to %s", cfg.HTTP.ShutdownTimeout)
+	}
+
+	c, cancel := ctx.WithTimeout(ctx.Background(), cfg.HTTP.ShutdownTimeout)
+	defer cancel()
+
+	err = a.shutdown(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Shutdown complete")
+}
+
+// shutdown drains the http server & background refreshes before closing the database, so no
+// fetch or resize is cut off mid-write
+func (a *app) shutdown(c ctx.Context) error {
+	//Abandon DAS calls still in flight once the deadline passes
+	stop := ctx.AfterFunc(c, a.solana.Close)
+	defer stop()
+
+	//In-flight requests finish their fetch & resize jobs before this returns
+	err := a.http.Shutdown(c)
+	if err != nil {
+		return fmt.Errorf("http shutdown: %w", err)
+	}
+
+	background := make(chan struct{})
+	go func() {
+		a.watcher.Close()
+		a.webhook.Close()
+		a.tokens.Close()
+		close(background)
+	}()
+	select {
+	case <-background:
+	case <-c.Done():
+		return fmt.Errorf("background refreshes: %w", c.Err())
+	}
+
+	a.solana.Close()
+	return a.sqlite.Shutdown(c)
 }
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"errors"
+	"strings"
+
+	nft_proxy "github.com/alphabatem/nft-proxy"
+	"github.com/gagliardetto/solana-go"
+)
+
+// maxDASBatch is the largest getAssetBatch request DAS providers accept
+const maxDASBatch = 1000
+
+var ErrAssetNotFound = errors.New("asset not found")
+
+// DASAsset is the subset of a Digital Asset Standard (DAS) getAsset response we use.
+// Compressed NFTs only exist in the merkle tree, so DAS is the only way to read their metadata.
+type DASAsset struct {
+	ID          string          `json:"id"`
+	Interface   string          `json:"interface"`
+	Content     DASContent      `json:"content"`
+	Authorities []DASAuthority  `json:"authorities"`
+	Compression *DASCompression `json:"compression"`
+	TokenInfo   *DASTokenInfo   `json:"token_info"`
+	Mutable     bool            `json:"mutable"`
+	Burnt       bool            `json:"burnt"`
+}
+
+type DASContent struct {
+	JsonUri  string      `json:"json_uri"`
+	Files    []DASFile   `json:"files"`
+	Metadata DASMetadata `json:"metadata"`
+	Links    DASLinks    `json:"links"`
+}
+
+type DASFile struct {
+	Uri    string `json:"uri"`
+	CdnUri string `json:"cdn_uri"`
+	Mime   string `json:"mime"`
+}
+
+type DASMetadata struct {
+	Name          string `json:"name"`
+	Symbol        string `json:"symbol"`
+	TokenStandard string `json:"token_standard"` //Named as in the metaplex program
+}
+
+type DASLinks struct {
+	Image        string `json:"image"`
+	AnimationUrl string `json:"animation_url"`
+	ExternalUrl  string `json:"external_url"`
+}
+
+type DASAuthority struct {
+	Address string   `json:"address"`
+	Scopes  []string `json:"scopes"`
+}
+
+type DASCompression struct {
+	Compressed bool   `json:"compressed"`
+	Tree       string `json:"tree"`
+	LeafId     uint64 `json:"leaf_id"`
+}
+
+type DASTokenInfo struct {
+	Decimals uint8 `json:"decimals"`
+}
+
+// Asset returns the DAS asset with id
+func (svc *SolanaService) Asset(id solana.PublicKey) (*DASAsset, error) {
+	var asset *DASAsset
+	err := svc.das.CallFor(svc.ctx, &asset, "getAsset", map[string]interface{}{"id": id.String()})
+	if err != nil {
+		if strings.Contains(strings.ToLower(err.Error()), "not found") {
+			return nil, ErrAssetNotFound
+		}
+		return nil, err
+	}
+	if asset == nil {
+		return nil, ErrAssetNotFound
+	}
+	return asset, nil
+}
+
+// AssetBatch returns the DAS assets with ids, in the same order with nil entries for assets not found
+func (svc *SolanaService) AssetBatch(ids []solana.PublicKey) ([]*DASAsset, error) {
+	assets := make([]*DASAsset, 0, len(ids))
+	for start := 0; start < len(ids); start += maxDASBatch {
+		end := start + maxDASBatch
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		keys := make([]string, 0, end-start)
+		for _, id := range ids[start:end] {
+			keys = append(keys, id.String())
+		}
+
+		var batch []*DASAsset
+		err := svc.das.CallFor(svc.ctx, &batch, "getAssetBatch", map[string]interface{}{"ids": keys})
+		if err != nil {
+			return nil, err
+		}
+		if len(batch) != len(keys) {
+			return nil, errors.New("getAssetBatch returned an unexpected number of assets")
+		}
+		assets = append(assets, batch...)
+	}
+	return assets, nil
+}
+
+// Metadata maps the asset onto the metadata we cache for on-chain tokens
+func (a *DASAsset) Metadata() *nft_proxy.NFTMetadataSimple {
+	metadata := nft_proxy.NFTMetadataSimple{
+		Name:            strings.Trim(a.Content.Metadata.Name, "\x00"),
+		Symbol:          strings.Trim(a.Content.Metadata.Symbol, "\x00"),
+		Image:           a.Content.Links.Image,
+		AnimationURL:    a.Content.Links.AnimationUrl,
+		ExternalURL:     a.Content.Links.ExternalUrl,
+		UpdateAuthority: a.UpdateAuthority(),
+		TokenStandard:   nft_proxy.TokenStandard(a.Content.Metadata.TokenStandard),
+	}
+	if a.TokenInfo != nil {
+		metadata.Decimals = a.TokenInfo.Decimals
+	}
+
+	for _, f := range a.Content.Files {
+		metadata.Files = append(metadata.Files, nft_proxy.NFTFiles{URL: f.Uri, Type: f.Mime})
+		if metadata.Image == "" && strings.HasPrefix(f.Mime, "image/") {
+			metadata.Image = f.Uri
+		}
+	}
+
+	return &metadata
+}
+
+// UpdateAuthority returns the authority holding the full scope, or the first authority listed
+func (a *DASAsset) UpdateAuthority() string {
+	for _, auth := range a.Authorities {
+		for _, scope := range auth.Scopes {
+			if scope == "full" {
+				return auth.Address
+			}
+		}
+	}
+	if len(a.Authorities) > 0 {
+		return a.Authorities[0].Address
+	}
+	return ""
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	ctx "context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+	"github.com/gagliardetto/solana-go/rpc/jsonrpc"
+)
+
+const (
+	testCompressedAsset = "CxmAy8epDfsjePJWsqQLdJxAGU59ag2UgYThMM5qHQat" //testdata/das/getAsset.json
+	testBatchAsset      = "CvM4d75mCs8oxdh4igJ4H8TBC2HfNym1Uayvf5aU9J75" //Second entry of testdata/das/getAssetBatch.json
+)
+
+// newDASStandIn replays recorded DAS responses, reporting every requested key as having no account
+func newDASStandIn(t *testing.T) *httptest.Server {
+	recorded := func(name string) map[string]interface{} {
+		data, err := os.ReadFile("testdata/das/" + name + ".json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var res map[string]interface{}
+		if err := json.Unmarshal(data, &res); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     interface{}     `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+
+		var res map[string]interface{}
+		switch req.Method {
+		case "getMultipleAccounts":
+			var params []json.RawMessage
+			var keys []string
+			json.Unmarshal(req.Params, &params)
+			json.Unmarshal(params[0], &keys)
+			res = map[string]interface{}{"result": map[string]interface{}{"context": map[string]interface{}{"slot": 1}, "value": make([]interface{}, len(keys))}}
+		case "getAsset":
+			var params struct {
+				ID string `json:"id"`
+			}
+			json.Unmarshal(req.Params, &params)
+			if params.ID == testCompressedAsset {
+				res = recorded("getAsset")
+			} else {
+				res = map[string]interface{}{"error": map[string]interface{}{"code": -32000, "message": `Database Error: RecordNotFound("Asset Not Found")`}}
+			}
+		case "getAssetBatch":
+			res = recorded("getAssetBatch")
+		default:
+			t.Errorf("Unexpected method %s", req.Method)
+		}
+
+		res["jsonrpc"] = "2.0"
+		res["id"] = req.ID
+		json.NewEncoder(w).Encode(res)
+	}))
+}
+
+func newTestDASSolana(url string) *SolanaService {
+	svc := SolanaService{client: rpc.New(url), das: jsonrpc.NewClient(url), commitments: defaultRPCCommitments}
+	svc.ctx, svc.cancel = ctx.WithCancel(ctx.Background())
+	return &svc
+}
+
+func TestDASAsset_Metadata(t *testing.T) {
+	standIn := newDASStandIn(t)
+	defer standIn.Close()
+	sol := newTestDASSolana(standIn.URL)
+
+	asset, err := sol.Asset(solana.MustPublicKeyFromBase58(testCompressedAsset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if asset.Compression == nil || !asset.Compression.Compressed || asset.Compression.LeafId != 1021 {
+		t.Fatalf("Unexpected compression %+v", asset.Compression)
+	}
+
+	m := asset.Metadata()
+	if m.Name != "Compressed #42" || m.Symbol != "CNFT" || m.UpdateAuthority != "EWexRaSmiYwNUsViuL9cFunHZGFbHQSzjkgdvdbo13Ur" {
+		t.Fatalf("Unexpected metadata %+v", m)
+	}
+	if f := m.ImageFile(); f == nil || f.Type != "image/png" {
+		t.Fatalf("Expected image file to be mapped, got %+v", m.Files)
+	}
+
+	t.Run("Not Found", func(t *testing.T) {
+		if _, err := sol.Asset(solana.NewWallet().PublicKey()); !errors.Is(err, ErrAssetNotFound) {
+			t.Fatalf("Expected asset not found, got %v", err)
+		}
+	})
+
+	t.Run("Batch", func(t *testing.T) {
+		assets, err := sol.AssetBatch([]solana.PublicKey{solana.NewWallet().PublicKey(), solana.MustPublicKeyFromBase58(testBatchAsset)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(assets) != 2 || assets[0] != nil || assets[1] == nil {
+			t.Fatalf("Expected missing asset to be nil, got %+v", assets)
+		}
+
+		//No image link, the first image file is used
+		m := assets[1].Metadata()
+		if m.Image != "https://arweave.net/9yS0aTf1c3yQ5G3Z2k8pQbVt2hO4x6r0M1n8kJ7vL5E" || m.AnimationFile() == nil {
+			t.Fatalf("Unexpected metadata %+v", m)
+		}
+		if m.UpdateAuthority != "5dkj8g9TCWTAwVsh5Npqbm2tZbBuKmzYmZR2nnsA2L5K" {
+			t.Fatalf("Expected first authority without a full scope, got %s", m.UpdateAuthority)
+		}
+	})
+
+	t.Run("Closed", func(t *testing.T) {
+		closed := newTestDASSolana(standIn.URL)
+		closed.Close()
+		if _, err := closed.Asset(solana.MustPublicKeyFromBase58(testCompressedAsset)); !errors.Is(err, ctx.Canceled) {
+			t.Fatalf("Expected calls after Close to be cancelled, got %v", err)
+		}
+	})
+}
+
+func TestSolanaImageService_FetchCompressed(t *testing.T) {
+	standIn := newDASStandIn(t)
+	defer standIn.Close()
+
+	svc := SolanaImageService{sql: newTestSqlite(t), sol: newTestDASSolana(standIn.URL)}
+
+	t.Run("Account Miss", func(t *testing.T) {
+		media, err := svc.FetchMetadata(testCompressedAsset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if media.Name != "Compressed #42" || media.ImageType != "png" || media.UpdateAuthority != "EWexRaSmiYwNUsViuL9cFunHZGFbHQSzjkgdvdbo13Ur" {
+			t.Fatalf("Unexpected media %+v", media)
+		}
+
+		if _, err := svc.FetchMetadata(solana.NewWallet().PublicKey().String()); err == nil {
+			t.Fatal("Expected unknown asset to fail")
+		}
+	})
+
+	t.Run("Batch", func(t *testing.T) {
+		missing := solana.NewWallet().PublicKey().String()
+		media, err := svc.FetchMetadataBatch([]string{missing, testBatchAsset})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if media[0] != nil || media[1] == nil || media[1].Mint != testBatchAsset || media[1].ImageType != "gif" {
+			t.Fatalf("Unexpected batch %+v", media)
+		}
+	})
+}
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/url"
+	"strings"
+)
+
+var (
+	ErrInvalidDataURI  = errors.New("invalid data uri")
+	ErrDataURITooLarge = errors.New("data uri too large")
+)
+
+const (
+	maxDataURIMetadata = 1 << 20  //Inline metadata json
+	maxDataURIImage    = 10 << 20 //Inline images, on-chain svgs are typically a few KB
+)
+
+// dataURI is a decoded RFC 2397 data: uri
+type dataURI struct {
+	MediaType string
+	Params    map[string]string
+	Data      []byte
+}
+
+// isDataURI reports whether raw uses the data: scheme
+func isDataURI(raw string) bool {
+	return len(raw) >= 5 && strings.EqualFold(raw[:5], "data:")
+}
+
+// parseDataURI decodes data:[<mediatype>][;base64],<data>, rejecting payloads larger than limit bytes.
+// Non base64 data is percent-decoded, tolerating the common non-standard ;utf8 & unescaped svg forms.
+func parseDataURI(raw string, limit int) (*dataURI, error) {
+	raw = strings.TrimSpace(strings.Trim(raw, "\x00"))
+	if !isDataURI(raw) {
+		return nil, ErrInvalidDataURI
+	}
+
+	header, payload, ok := strings.Cut(raw[5:], ",")
+	if !ok {
+		return nil, ErrInvalidDataURI
+	}
+
+	//Base64 expands by 4/3 & percent-encoding by up to 3x, reject before decoding anything
+	if len(payload) > limit*3 {
+		return nil, ErrDataURITooLarge
+	}
+
+	d := dataURI{MediaType: "text/plain", Params: map[string]string{"charset": "US-ASCII"}}
+
+	base64Encoded := false
+	params := strings.Split(header, ";")
+	if len(params) > 0 && params[len(params)-1] == "base64" {
+		base64Encoded = true
+		params = params[:len(params)-1]
+	}
+
+	if len(params) > 0 && params[0] != "" {
+		mediaType, typeParams, err := mime.ParseMediaType(strings.Join(params, ";"))
+		if err != nil {
+			//Non-standard valueless params (eg. ;utf8) trip the mime parser, keep the type alone
+			mediaType, _, err = mime.ParseMediaType(params[0])
+			if err != nil {
+				return nil, ErrInvalidDataURI
+			}
+		}
+		d.MediaType = mediaType
+		d.Params = map[string]string{}
+		for k, v := range typeParams {
+			d.Params[k] = v
+		}
+	}
+
+	if base64Encoded {
+		data, err := decodeBase64Lenient(payload)
+		if err != nil {
+			return nil, ErrInvalidDataURI
+		}
+		d.Data = data
+	} else {
+		d.Data = percentDecode(payload)
+	}
+
+	if len(d.Data) > limit {
+		return nil, ErrDataURITooLarge
+	}
+
+	return &d, nil
+}
+
+// decodeBase64Lenient accepts standard & url-safe alphabets, with or without padding & line breaks
+func decodeBase64Lenient(s string) ([]byte, error) {
+	s, err := url.PathUnescape(s) //Some encoders percent-encode the padding
+	if err != nil {
+		return nil, err
+	}
+
+	s = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\r', '\n':
+			return -1
+		}
+		return r
+	}, s)
+	s = strings.TrimRight(s, "=")
+
+	if strings.ContainsAny(s, "-_") {
+		return base64.RawURLEncoding.DecodeString(s)
+	}
+	return base64.RawStdEncoding.DecodeString(s)
+}
+
+// percentDecode decodes %XX escapes, leaving stray % & + untouched as svg data often contains them unescaped
+func percentDecode(s string) []byte {
+	out := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]) {
+			out = append(out, unhex(s[i+1])<<4|unhex(s[i+2]))
+			i += 2
+			continue
+		}
+		out = append(out, s[i])
+	}
+	return out
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+// inlineMetadata returns the json held directly in a metadata uri field, either as a data: uri,
+// raw json or bare base64 encoded json. ok is false when uri should be fetched instead.
+func inlineMetadata(uri string) ([]byte, bool, error) {
+	uri = strings.TrimSpace(strings.Trim(uri, "\x00"))
+
+	if isDataURI(uri) {
+		d, err := parseDataURI(uri, maxDataURIMetadata)
+		if err != nil {
+			return nil, true, err
+		}
+		return d.Data, true, nil
+	}
+
+	if strings.HasPrefix(uri, "{") {
+		if len(uri) > maxDataURIMetadata {
+			return nil, true, ErrDataURITooLarge
+		}
+		return []byte(uri), true, nil
+	}
+
+	//Bare base64 json objects start with "e", the encoding of `{`
+	if strings.HasPrefix(uri, "e") && !strings.ContainsAny(uri, ":.") {
+		if len(uri) > maxDataURIMetadata*2 {
+			return nil, true, ErrDataURITooLarge
+		}
+		data, err := decodeBase64Lenient(uri)
+		if err == nil && json.Valid(data) {
+			return data, true, nil
+		}
+	}
+
+	return nil, false, nil
+}
+
+// dataURIImageType maps the media type of an inline image onto our image type
+func dataURIImageType(uri string) string {
+	header, _, _ := strings.Cut(uri[5:], ",")
+	mediaType, _, _ := strings.Cut(header, ";")
+
+	_, subtype, ok := strings.Cut(strings.ToLower(strings.TrimSpace(mediaType)), "/")
+	if !ok {
+		return ""
+	}
+	subtype, _, _ = strings.Cut(subtype, "+") //svg+xml
+	return subtype
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestParseDataURI(t *testing.T) {
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" width="10" height="10"><rect fill="#ff0000" width="100%" height="100%"/></svg>`
+	json := `{"name":"On-chain #1","image":"data:image/svg+xml;utf8,<svg/>"}`
+
+	tests := []struct {
+		name      string
+		uri       string
+		mediaType string
+		data      string
+	}{
+		{"Base64 Json", "data:application/json;base64," + base64.StdEncoding.EncodeToString([]byte(json)), "application/json", json},
+		{"Unpadded Base64", "data:application/json;base64," + base64.RawStdEncoding.EncodeToString([]byte(json)), "application/json", json},
+		{"Url Safe Base64", "data:image/png;base64," + base64.URLEncoding.EncodeToString([]byte{0xfb, 0xff, 0xfe}), "image/png", "\xfb\xff\xfe"},
+		{"Utf8 Svg", "data:image/svg+xml;utf8," + svg, "image/svg+xml", svg},
+		{"Percent Encoded Svg", "data:image/svg+xml;charset=utf-8," + strings.NewReplacer("<", "%3C", ">", "%3E", "#", "%23", `"`, "%22").Replace(svg), "image/svg+xml", svg},
+		{"Default Media Type", "data:,A%20brief%20note", "text/plain", "A brief note"},
+		{"Upper Case Scheme", "DATA:text/plain;base64,SGVsbG8=", "text/plain", "Hello"},
+		{"Trailing Nulls", "data:text/plain,hi\x00\x00", "text/plain", "hi"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := parseDataURI(tt.uri, maxDataURIImage)
+			if err != nil {
+				t.Fatalf("Failed to parse: %v", err)
+			}
+			if d.MediaType != tt.mediaType {
+				t.Fatalf("Expected media type %s, got %s", tt.mediaType, d.MediaType)
+			}
+			if string(d.Data) != tt.data {
+				t.Fatalf("Expected data %q, got %q", tt.data, d.Data)
+			}
+		})
+	}
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, uri := range []string{"https://example.com/a.png", "data:image/png;base64", "data:image/png;base64,!!!!", "data:/;base64,AAAA"} {
+			if _, err := parseDataURI(uri, maxDataURIImage); err != ErrInvalidDataURI {
+				t.Fatalf("%s: expected ErrInvalidDataURI, got %v", uri, err)
+			}
+		}
+	})
+
+	t.Run("Size Limit", func(t *testing.T) {
+		payload := base64.StdEncoding.EncodeToString(make([]byte, 2048))
+
+		if _, err := parseDataURI("data:image/png;base64,"+payload, 1024); err != ErrDataURITooLarge {
+			t.Fatalf("Expected ErrDataURITooLarge for decoded size, got %v", err)
+		}
+		if _, err := parseDataURI("data:text/plain,"+strings.Repeat("a", 4096), 1024); err != ErrDataURITooLarge {
+			t.Fatalf("Expected ErrDataURITooLarge for encoded size, got %v", err)
+		}
+	})
+}
+
+func TestInlineMetadata(t *testing.T) {
+	json := `{"name":"On-chain #1"}`
+
+	tests := []struct {
+		name   string
+		uri    string
+		inline bool
+	}{
+		{"Data Uri", "data:application/json;base64," + base64.StdEncoding.EncodeToString([]byte(json)), true},
+		{"Utf8 Data Uri", "data:application/json;utf8," + json, true},
+		{"Raw Json", json + "\x00\x00", true},
+		{"Bare Base64", base64.StdEncoding.EncodeToString([]byte(json)), true},
+		{"Http", "https://arweave.net/bNbA3TEQVL60xlgCcqdz4ZPHFZ711cZ3hmkpGttDt_U", false},
+		{"Ipfs", "ipfs://bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, inline, err := inlineMetadata(tt.uri)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if inline != tt.inline {
+				t.Fatalf("Expected inline %v, got %v", tt.inline, inline)
+			}
+			if inline && string(data) != json {
+				t.Fatalf("Expected %s, got %s", json, data)
+			}
+		})
+	}
+}
+
+func TestDataURIImageType(t *testing.T) {
+	tests := map[string]string{
+		"data:image/svg+xml;utf8,<svg/>":  "svg",
+		"data:image/png;base64,AAAA":      "png",
+		"data:IMAGE/JPEG;base64,AAAA":     "jpeg",
+		"data:;base64,AAAA":               "",
+		"data:image/gif;charset=x;base64": "gif",
+	}
+
+	for uri, expected := range tests {
+		if imageType := dataURIImageType(uri); imageType != expected {
+			t.Errorf("%s: expected %s, got %s", uri, expected, imageType)
+		}
+	}
+}
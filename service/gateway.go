@@ -0,0 +1,287 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/alphabatem/nft-proxy/config"
+	"github.com/babilu-online/common/context"
+)
+
+// GatewayService rewrites IPFS & Arweave uris onto an ordered list of configured gateways,
+// failing over between them & preferring gateways that have been healthy recently
+type GatewayService struct {
+	context.DefaultService
+	Config *config.Config
+
+	ipfs    []*gateway
+	arweave []*gateway
+}
+
+const GATEWAY_SVC = "gateway_svc"
+
+var ErrAllGatewaysFailed = errors.New("all gateways failed")
+
+// healthDecay is the weight given to the latest result when updating a gateways score
+const healthDecay = 0.2
+
+type gateway struct {
+	BaseURL string
+
+	mu    sync.RWMutex
+	score float64 //Moving average of successful fetches, 1 is fully healthy
+}
+
+func newGateway(baseURL string) *gateway {
+	return &gateway{BaseURL: strings.TrimSuffix(baseURL, "/"), score: 1}
+}
+
+func (g *gateway) Score() float64 {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.score
+}
+
+func (g *gateway) record(ok bool) {
+	result := 0.0
+	if ok {
+		result = 1
+	}
+
+	g.mu.Lock()
+	g.score = g.score*(1-healthDecay) + result*healthDecay
+	g.mu.Unlock()
+}
+
+// contentProtocol is the storage network a uri was resolved to
+type contentProtocol uint8
+
+const (
+	protocolHTTP contentProtocol = iota
+	protocolIPFS
+	protocolArweave
+)
+
+// contentURI is a gateway independent reference to content on IPFS or Arweave
+type contentURI struct {
+	Protocol contentProtocol
+	ID       string //CID or Arweave transaction id
+	Path     string //Remaining path including the leading slash & query
+	Original string
+}
+
+func (svc GatewayService) Id() string {
+	return GATEWAY_SVC
+}
+
+func (svc *GatewayService) Configure(ctx *context.Context) error {
+	if svc.Config == nil {
+		return config.ErrMissingConfig
+	}
+
+	svc.ipfs = newGateways(svc.Config.Gateways.IPFS)
+	svc.arweave = newGateways(svc.Config.Gateways.Arweave)
+
+	return svc.DefaultService.Configure(ctx)
+}
+
+func (svc *GatewayService) Start() error {
+	return nil
+}
+
+func newGateways(urls []string) []*gateway {
+	gateways := make([]*gateway, 0, len(urls))
+	for _, u := range urls {
+		gateways = append(gateways, newGateway(u))
+	}
+	return gateways
+}
+
+// parseContentURI recognises ipfs://, ar://, path style (/ipfs/<cid>) & subdomain style (<cid>.ipfs.host) uris
+func parseContentURI(raw string, arweaveHosts []string) contentURI {
+	raw = strings.TrimSpace(strings.Trim(raw, "\x00"))
+	c := contentURI{Protocol: protocolHTTP, Original: raw}
+
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return c
+	}
+
+	rest := u.EscapedPath()
+	if u.RawQuery != "" {
+		rest += "?" + u.RawQuery
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "ipfs":
+		//ipfs://<cid>/path & the non-standard ipfs://ipfs/<cid>/path
+		if u.Host == "ipfs" {
+			return splitContentPath(c, protocolIPFS, rest)
+		}
+		return contentURI{Protocol: protocolIPFS, ID: u.Host, Path: rest, Original: raw}
+	case "ar":
+		return contentURI{Protocol: protocolArweave, ID: u.Host, Path: rest, Original: raw}
+	case "http", "https":
+	default:
+		return c
+	}
+
+	//Path style first, gateways such as gateway.ipfs.io also have .ipfs. in their host
+	if strings.HasPrefix(rest, "/ipfs/") {
+		return splitContentPath(c, protocolIPFS, strings.TrimPrefix(rest, "/ipfs"))
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if i := strings.Index(host, ".ipfs."); i > 0 && isSubdomainCID(host[:i]) {
+		return contentURI{Protocol: protocolIPFS, ID: host[:i], Path: rest, Original: raw}
+	}
+
+	for _, h := range arweaveHosts {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return splitContentPath(c, protocolArweave, rest)
+		}
+	}
+
+	return c
+}
+
+// isSubdomainCID reports whether label is a CIDv1, the only form subdomain gateways accept as hostnames are case insensitive
+func isSubdomainCID(label string) bool {
+	if len(label) < 50 || (label[0] != 'b' && label[0] != 'k') { //base32 or base36 multibase prefix
+		return false
+	}
+	for _, r := range label {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// splitContentPath splits /<id>/path into its id & remaining path
+func splitContentPath(c contentURI, protocol contentProtocol, path string) contentURI {
+	path = strings.TrimPrefix(path, "/")
+	if path == "" || strings.HasPrefix(path, "?") {
+		return c
+	}
+
+	id, rest := path, ""
+	if i := strings.IndexAny(path, "/?"); i > -1 {
+		id, rest = path[:i], path[i:]
+	}
+
+	return contentURI{Protocol: protocol, ID: id, Path: rest, Original: c.Original}
+}
+
+func (svc *GatewayService) arweaveHosts() []string {
+	hosts := make([]string, 0, len(svc.arweave))
+	for _, g := range svc.arweave {
+		if u, err := url.Parse(g.BaseURL); err == nil {
+			hosts = append(hosts, strings.ToLower(u.Hostname()))
+		}
+	}
+	return hosts
+}
+
+// candidates returns the urls to try for c, healthiest gateway first
+func (svc *GatewayService) candidates(c contentURI) ([]string, []*gateway) {
+	var gateways []*gateway
+	switch c.Protocol {
+	case protocolIPFS:
+		gateways = svc.ipfs
+	case protocolArweave:
+		gateways = svc.arweave
+	}
+
+	if len(gateways) == 0 {
+		return []string{c.Original}, []*gateway{nil}
+	}
+
+	//Fall back to the original url last, it may be a dedicated gateway holding content others don't
+	fallback := strings.HasPrefix(c.Original, "http://") || strings.HasPrefix(c.Original, "https://")
+
+	ordered := make([]*gateway, len(gateways))
+	copy(ordered, gateways)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].Score() > ordered[j].Score()
+	})
+
+	urls := make([]string, len(ordered))
+	for i, g := range ordered {
+		switch c.Protocol {
+		case protocolIPFS:
+			urls[i] = fmt.Sprintf("%s/ipfs/%s%s", g.BaseURL, c.ID, c.Path)
+		case protocolArweave:
+			urls[i] = fmt.Sprintf("%s/%s%s", g.BaseURL, c.ID, c.Path)
+		}
+
+		if urls[i] == c.Original {
+			fallback = false
+		}
+	}
+
+	if fallback {
+		urls = append(urls, c.Original)
+		ordered = append(ordered, nil)
+	}
+	return urls, ordered
+}
+
+// Resolve returns every url uri can be fetched from in the order they will be tried
+func (svc *GatewayService) Resolve(uri string) []string {
+	urls, _ := svc.candidates(parseContentURI(uri, svc.arweaveHosts()))
+	return urls
+}
+
+// Get fetches uri using client, failing over to the next gateway on errors & non 200 responses
+func (svc *GatewayService) Get(client *http.Client, uri string, header http.Header) (*http.Response, error) {
+	urls, gateways := svc.candidates(parseContentURI(uri, svc.arweaveHosts()))
+
+	var lastErr error
+	for i, u := range urls {
+		req, err := http.NewRequest("GET", u, nil)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+
+		resp, err := client.Do(req)
+		if err == nil && resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			err = errors.New(resp.Status)
+		}
+
+		if gateways[i] != nil {
+			gateways[i].record(err == nil)
+		}
+
+		if err == nil {
+			return resp, nil
+		}
+
+		log.Printf("Gateway fetch failed %s: %s", u, err)
+		lastErr = err
+	}
+
+	if len(urls) > 1 {
+		return nil, fmt.Errorf("%w: %s", ErrAllGatewaysFailed, lastErr)
+	}
+	return nil, lastErr
+}
+
+// Health returns the current score of every configured gateway
+func (svc *GatewayService) Health() map[string]float64 {
+	health := make(map[string]float64, len(svc.ipfs)+len(svc.arweave))
+	for _, g := range append(append([]*gateway{}, svc.ipfs...), svc.arweave...) {
+		health[g.BaseURL] = g.Score()
+	}
+	return health
+}
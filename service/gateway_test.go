@@ -0,0 +1,101 @@
+package services
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseContentURI(t *testing.T) {
+	arweaveHosts := []string{"arweave.net"}
+	cid := "bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi"
+
+	tests := []struct {
+		uri      string
+		protocol contentProtocol
+		id       string
+		path     string
+	}{
+		{"ipfs://" + cid, protocolIPFS, cid, ""},
+		{"ipfs://" + cid + "/1.png", protocolIPFS, cid, "/1.png"},
+		{"ipfs://ipfs/" + cid + "/1.png", protocolIPFS, cid, "/1.png"},
+		{"https://ipfs.io/ipfs/" + cid + "/1.png?ext=png", protocolIPFS, cid, "/1.png?ext=png"},
+		{"https://" + cid + ".ipfs.nftstorage.link/1.png", protocolIPFS, cid, "/1.png"},
+		{"https://gateway.ipfs.io/ipfs/" + cid + "/1.png", protocolIPFS, cid, "/1.png"},
+		{"https://gateway.ipfs.io/1.png", protocolHTTP, "", ""},
+		{"https://gateway.pinata.cloud/ipfs/QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG", protocolIPFS, "QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG", ""},
+		{"ar://bNbA3TEQVL60xlgCcqdz4ZPHFZ711cZ3hmkpGttDt_U", protocolArweave, "bNbA3TEQVL60xlgCcqdz4ZPHFZ711cZ3hmkpGttDt_U", ""},
+		{"https://arweave.net/bNbA3TEQVL60xlgCcqdz4ZPHFZ711cZ3hmkpGttDt_U?ext=png", protocolArweave, "bNbA3TEQVL60xlgCcqdz4ZPHFZ711cZ3hmkpGttDt_U", "?ext=png"},
+		{"https://www.arweave.net/bNbA3TEQVL60xlgCcqdz4ZPHFZ711cZ3hmkpGttDt_U", protocolArweave, "bNbA3TEQVL60xlgCcqdz4ZPHFZ711cZ3hmkpGttDt_U", ""},
+		{"https://example.com/image.png\x00\x00", protocolHTTP, "", ""},
+		{"https://example.com/ipfs/", protocolHTTP, "", ""},
+	}
+
+	for _, tt := range tests {
+		c := parseContentURI(tt.uri, arweaveHosts)
+		if c.Protocol != tt.protocol || c.ID != tt.id || c.Path != tt.path {
+			t.Errorf("%s: expected (%v, %s, %s), got (%v, %s, %s)", tt.uri, tt.protocol, tt.id, tt.path, c.Protocol, c.ID, c.Path)
+		}
+	}
+}
+
+func TestGatewayService_Failover(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGatewayTimeout)
+	}))
+	defer down.Close()
+
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer up.Close()
+
+	svc := GatewayService{ipfs: []*gateway{newGateway(down.URL), newGateway(up.URL)}}
+	client := &http.Client{Timeout: time.Second}
+
+	t.Run("Failover", func(t *testing.T) {
+		resp, err := svc.Get(client, "ipfs://QmTest/1.json", nil)
+		if err != nil {
+			t.Fatalf("Expected failover to healthy gateway: %v", err)
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		if string(body) != "/ipfs/QmTest/1.json" {
+			t.Fatalf("Expected path style gateway request, got %s", body)
+		}
+	})
+
+	t.Run("Health Ordering", func(t *testing.T) {
+		urls := svc.Resolve("ipfs://QmTest")
+		if urls[0] != up.URL+"/ipfs/QmTest" {
+			t.Fatalf("Expected healthy gateway to be tried first, got %v", urls)
+		}
+
+		health := svc.Health()
+		if health[down.URL] >= health[up.URL] {
+			t.Fatalf("Expected failing gateway to score lower, got %v", health)
+		}
+	})
+
+	t.Run("All Failed", func(t *testing.T) {
+		svc := GatewayService{ipfs: []*gateway{newGateway(down.URL)}}
+
+		_, err := svc.Get(client, "ipfs://QmTest", nil)
+		if err == nil {
+			t.Fatal("Expected error when every gateway fails")
+		}
+	})
+
+	t.Run("Original Fallback", func(t *testing.T) {
+		svc := GatewayService{ipfs: []*gateway{newGateway(down.URL)}}
+
+		resp, err := svc.Get(client, up.URL+"/ipfs/QmTest", nil)
+		if err != nil {
+			t.Fatalf("Expected original gateway url to be tried last: %v", err)
+		}
+		resp.Body.Close()
+	})
+}
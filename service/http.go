@@ -1,16 +1,21 @@
 package services
 
 import (
+	ctx "context"
 	"errors"
 	"fmt"
+	"github.com/alphabatem/nft-proxy/config"
 	"github.com/babilu-online/common/context"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"io"
 	"log"
 	"math/rand"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -27,30 +32,42 @@ type HttpService struct {
 	context.DefaultService
 	BaseURL string
 	Port    int
+	Config  *config.Config
 
-	imgSvc  *ImageService
-	statSvc *StatService
+	imgSvc     *ImageService
+	statSvc    *StatService
+	tokenSvc   *TokenService
+	royaltySvc *RoyaltyService
+	walletSvc  *WalletService
+	webhookSvc *WebhookService
 
 	defaultImage []byte
+	corsOrigins  []string
+
+	listener net.Listener //Serves on Port when nil
+
+	mu     sync.Mutex
+	server *http.Server
+	closed bool
 }
 
 var ErrUnauthorized = errors.New("unauthorized")
 var DeleteResponseOK = `{"status": 200, "error": ""}`
 
-func (svc HttpService) Id() string {
+func (svc *HttpService) Id() string {
 	return "http"
 }
 
 func (svc *HttpService) Configure(ctx *context.Context) error {
-	port := os.Getenv("HTTP_PORT")
-	portFlag, err := strconv.Atoi(port)
-	if err != nil {
-		return err
+	if svc.Config == nil {
+		return config.ErrMissingConfig
 	}
 
-	svc.Port = portFlag
+	svc.Port = svc.Config.HTTP.Port
+	svc.corsOrigins = svc.Config.HTTP.CORSOrigins
 
-	svc.defaultImage, err = os.ReadFile("./docs/failed_image.jpg")
+	var err error
+	svc.defaultImage, err = os.ReadFile(svc.Config.HTTP.DefaultImage)
 	if err != nil {
 		return err
 	}
@@ -61,17 +78,24 @@ func (svc *HttpService) Configure(ctx *context.Context) error {
 func (svc *HttpService) Start() error {
 	svc.imgSvc = svc.DefaultService(IMG_SVC).(*ImageService)
 	svc.statSvc = svc.DefaultService(STAT_SVC).(*StatService)
+	svc.tokenSvc = svc.DefaultService(TOKEN_SVC).(*TokenService)
+	svc.royaltySvc = svc.DefaultService(ROYALTY_SVC).(*RoyaltyService)
+	svc.walletSvc = svc.DefaultService(WALLET_SVC).(*WalletService)
+	svc.webhookSvc = svc.DefaultService(WEBHOOK_SVC).(*WebhookService)
 
 	r := gin.Default()
 
 	r.Use(gin.Recovery())
 
-	config := cors.DefaultConfig()
-	// config.AllowAllOrigins = true. This may not be suitable for production environments as it could lead to security vulnerabilities
-	config.AllowAllOrigins = true
-	config.AllowCredentials = true
-	config.AddAllowHeaders("Authorization")
-	r.Use(cors.New(config))
+	corsConfig := cors.DefaultConfig()
+	if len(svc.corsOrigins) == 1 && svc.corsOrigins[0] == "*" {
+		corsConfig.AllowAllOrigins = true //Not suitable for production, list the allowed origins instead
+	} else {
+		corsConfig.AllowOrigins = svc.corsOrigins
+	}
+	corsConfig.AllowCredentials = true
+	corsConfig.AddAllowHeaders("Authorization")
+	r.Use(cors.New(corsConfig))
 
 	//r.Static("static", "static")
 	//r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerfiles.Handler))
@@ -80,10 +104,12 @@ func (svc *HttpService) Start() error {
 	r.GET("/ping", svc.ping)
 	r.GET("/stats", svc.stats)
 
+	r.POST("/webhooks/metadata", svc.metadataWebhook)
+
 	v1 := r.Group("/v1")
 	//docs.SwaggerInfo.BasePath = "/v1"
 
-	v1.GET("tokens/:id", svc.showNFT)
+	v1.GET("tokens/:id", svc.showToken)
 	v1.GET("tokens/:id/image", svc.showNFTImage)
 	v1.GET("tokens/:id/image.gif", svc.showNFTImage)
 	v1.GET("tokens/:id/image.png", svc.showNFTImage)
@@ -98,12 +124,54 @@ func (svc *HttpService) Start() error {
 	v1.GET("nfts/:id/image.jpg", svc.showNFTImage)
 	v1.GET("nfts/:id/image.jpeg", svc.showNFTImage)
 	v1.GET("nfts/:id/media", svc.showNFTMedia)
+	v1.GET("nfts/:id/royalties", svc.showRoyalties)
+
+	v1.GET("wallets/:owner/nfts", svc.walletNFTs)
+	v1.GET("wallets/:owner/tokens", svc.walletTokens)
 
 	r.NoRoute(func(c *gin.Context) {
 		c.JSON(404, gin.H{"code": "PAGE_NOT_FOUND", "message": "Page not found"})
 	})
 
-	return r.Run(fmt.Sprintf(":%v", svc.Port))
+	svc.mu.Lock()
+	if svc.closed {
+		svc.mu.Unlock()
+		return nil //Shutdown before we started serving
+	}
+	svc.server = &http.Server{Addr: fmt.Sprintf(":%v", svc.Port), Handler: r}
+	svc.mu.Unlock()
+
+	var err error
+	if svc.listener != nil {
+		log.Printf("Listening and serving HTTP on %s", svc.listener.Addr())
+		err = svc.server.Serve(svc.listener)
+	} else {
+		log.Printf("Listening and serving HTTP on %s", svc.server.Addr)
+		err = svc.server.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops accepting requests, waiting for in-flight requests to complete or c to be done.
+// Requests still running once c is done are cancelled.
+func (svc *HttpService) Shutdown(c ctx.Context) error {
+	svc.mu.Lock()
+	svc.closed = true
+	server := svc.server
+	svc.mu.Unlock()
+
+	if server == nil {
+		return nil
+	}
+
+	err := server.Shutdown(c)
+	if err != nil {
+		server.Close() //Cancels the contexts of requests still running past the deadline
+	}
+	return err
 }
 
 type Pong struct {
@@ -162,6 +230,95 @@ func (svc *HttpService) showNFT(c *gin.Context) {
 	c.JSON(200, media)
 }
 
+// @Summary Token metadata with supply, authorities & token standard
+// @Accept  json
+// @Produce json
+// @Router /tokens/{id} [get]
+func (svc *HttpService) showToken(c *gin.Context) {
+	svc.statSvc.IncrementMediaRequests()
+
+	skipCache, _ := strconv.ParseBool(c.DefaultQuery("nocache", ""))
+	token, err := svc.tokenSvc.Token(c.Param("id"), skipCache)
+	if err != nil {
+		svc.paramErr(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=60") //Supply changes
+	c.JSON(200, token)
+}
+
+// @Summary Seller fee, creators & transfer rules of an NFT
+// @Accept  json
+// @Produce json
+// @Router /nfts/{id}/royalties [get]
+func (svc *HttpService) showRoyalties(c *gin.Context) {
+	skipCache, _ := strconv.ParseBool(c.DefaultQuery("nocache", ""))
+	royalties, err := svc.royaltySvc.Royalties(c.Param("id"), skipCache)
+	if err != nil {
+		svc.paramErr(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.JSON(200, royalties)
+}
+
+// @Summary NFTs & core assets held by a wallet
+// @Accept  json
+// @Produce json
+// @Router /wallets/{owner}/nfts [get]
+func (svc *HttpService) walletNFTs(c *gin.Context) {
+	page, limit, err := pageParams(c)
+	if err != nil {
+		svc.paramErr(c, err)
+		return
+	}
+
+	holdings, err := svc.walletSvc.NFTs(c.Request.Context(), c.Param("owner"), page, limit)
+	if err != nil {
+		svc.paramErr(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=30") //Holdings change with every transfer
+	c.JSON(200, holdings)
+}
+
+// @Summary Fungible tokens held by a wallet
+// @Accept  json
+// @Produce json
+// @Router /wallets/{owner}/tokens [get]
+func (svc *HttpService) walletTokens(c *gin.Context) {
+	page, limit, err := pageParams(c)
+	if err != nil {
+		svc.paramErr(c, err)
+		return
+	}
+
+	holdings, err := svc.walletSvc.Tokens(c.Request.Context(), c.Param("owner"), page, limit)
+	if err != nil {
+		svc.paramErr(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=30")
+	c.JSON(200, holdings)
+}
+
+// pageParams reads the 1-based page & page size from the query
+func pageParams(c *gin.Context) (int, int, error) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil {
+		return 0, 0, ErrInvalidPage
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(DefaultHoldingsLimit)))
+	if err != nil {
+		return 0, 0, ErrInvalidPage
+	}
+	return page, limit, nil
+}
+
 // @Summary Ping liquify service
 // @Accept  json
 // @Produce json
@@ -183,6 +340,10 @@ func (svc *HttpService) showNFTImage(c *gin.Context) {
 func (svc *HttpService) showNFTMedia(c *gin.Context) {
 	svc.statSvc.IncrementMediaFileRequests()
 	err := svc.imgSvc.MediaFile(c, c.Param("id"))
+	if err != nil && c.Writer.Written() {
+		log.Printf("Media Err: %s", err) //Failed mid stream, the connection has been dropped
+		return
+	}
 	// When an error occurs, a 200 status code is returned along with the default image which is misleading since 200 status code indicates success
 	if err != nil {
 		svc.mediaError(c, err)
@@ -190,6 +351,37 @@ func (svc *HttpService) showNFTMedia(c *gin.Context) {
 	}
 }
 
+// @Summary Queue metadata refreshes for the mints in a signed webhook payload
+// @Accept  json
+// @Produce json
+// @Router /webhooks/metadata [post]
+func (svc *HttpService) metadataWebhook(c *gin.Context) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxWebhookBody+1))
+	if err != nil {
+		svc.paramErr(c, err)
+		return
+	}
+	if len(body) > maxWebhookBody {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": ErrInvalidWebhook.Error()})
+		return
+	}
+
+	res, err := svc.webhookSvc.Handle(c.Request.Header, body)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusAccepted, res)
+	case errors.Is(err, ErrWebhookDisabled):
+		c.JSON(http.StatusNotFound, gin.H{"code": "PAGE_NOT_FOUND", "message": "Page not found"})
+	case errors.Is(err, ErrUnauthorized):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrInvalidWebhook):
+		svc.paramErr(c, err)
+	default:
+		log.Printf("Webhook err: %s", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
 func (svc *HttpService) paramErr(c *gin.Context, err error) {
 	c.JSON(400, gin.H{
 		"error": err.Error(),
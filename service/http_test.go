@@ -0,0 +1,424 @@
+package services
+
+import (
+	"bytes"
+	ctx "context"
+	"encoding/binary"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	nft_proxy "github.com/alphabatem/nft-proxy"
+	"github.com/alphabatem/nft-proxy/config"
+	"github.com/babilu-online/common/context"
+	"github.com/gagliardetto/solana-go"
+)
+
+// originFile is a file served by the stand-in origin
+type originFile struct {
+	contentType string
+	body        []byte
+}
+
+// testOrigin is a local origin serving metadata files & images, every other path is a 404
+type testOrigin struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	files    map[string]originFile
+	requests map[string]int //Path -> times requested
+
+	held    chan struct{} //Responses wait for this to close when set
+	arrived chan string   //Paths of held requests
+}
+
+func newTestOrigin() *testOrigin {
+	o := &testOrigin{files: map[string]originFile{}, requests: map[string]int{}}
+	o.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		o.mu.Lock()
+		o.requests[r.URL.Path]++
+		f, ok := o.files[r.URL.Path]
+		held, arrived := o.held, o.arrived
+		o.mu.Unlock()
+
+		if held != nil {
+			arrived <- r.URL.Path
+			<-held
+		}
+
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", f.contentType)
+		w.Write(f.body)
+	}))
+	return o
+}
+
+// setFile serves body at path, returning its url
+func (o *testOrigin) setFile(path, contentType string, body []byte) string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.files[path] = originFile{contentType: contentType, body: body}
+	return o.URL + path
+}
+
+// hold delays responses until release is called, sending the path of each held request on arrived
+func (o *testOrigin) hold() (arrived <-chan string, release func()) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.held, o.arrived = make(chan struct{}), make(chan string, 16)
+
+	var once sync.Once
+	held := o.held
+	return o.arrived, func() {
+		once.Do(func() { close(held) })
+	}
+}
+
+func (o *testOrigin) Requests(path string) int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.requests[path]
+}
+
+// testHarness boots the service graph against a stand-in RPC node & origin, with a temporary database & cache,
+// serving the api on a local port
+type testHarness struct {
+	URL string
+
+	rpc    *accountStandIn
+	origin *testOrigin
+	http   *HttpService
+	served chan error //Result of HttpService.Start
+
+	defaultImage []byte
+}
+
+func newTestHarness(t *testing.T) *testHarness {
+	t.Helper()
+	h := &testHarness{rpc: newAccountStandIn(), origin: newTestOrigin()}
+	t.Cleanup(h.rpc.Close)
+	t.Cleanup(h.origin.Close)
+
+	var err error
+	h.defaultImage, err = os.ReadFile("../docs/failed_image.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.HTTP.DefaultImage = "../docs/failed_image.jpg"
+	cfg.Database.Path = filepath.Join(dir, "nft-proxy.db")
+	cfg.Media.CacheDir = filepath.Join(dir, "cache")
+	cfg.RPC.Endpoints = []string{h.rpc.URL}
+	cfg.Retry.Origin.Attempts = 1
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	sql := &SqliteService{Config: cfg}
+	sol := &SolanaService{Config: cfg}
+	solImg := &SolanaImageService{Config: cfg}
+	img := &ImageService{Config: cfg}
+	tokens := &TokenService{Config: cfg}
+	httpSvc := &HttpService{Config: cfg}
+	svcs := []context.Service{
+		sql,
+		&StatService{Config: cfg},
+		&ResizeService{Config: cfg},
+		&StoreService{Config: cfg},
+		&GatewayService{Config: cfg},
+		sol,
+		solImg,
+		img,
+		tokens,
+		&RoyaltyService{Config: cfg},
+		&WalletService{Config: cfg},
+		&WatcherService{Config: cfg},
+		&WebhookService{Config: cfg},
+	}
+	_, err = context.NewCtx(append(svcs, httpSvc)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, svc := range svcs {
+		if err := svc.Start(); err != nil {
+			t.Fatalf("%s: %s", svc.Id(), err)
+		}
+	}
+	t.Cleanup(tokens.Close)
+	t.Cleanup(sol.pool.Close)
+
+	//The origin is on a loopback address, which the outbound clients refuse
+	loopback := func(addr netip.AddrPort) bool { return addr.Addr().IsLoopback() }
+	policy := retryPolicyFrom(originRetryPolicy, cfg.Retry.Origin)
+	solImg.http = withRetries(newOutboundClientWith(cfg.Media.MetadataTimeout, loopback), policy, solImg.stats)
+	img.httpMedia = withRetries(newOutboundClientWith(cfg.Media.DownloadTimeout, loopback), policy, img.stats)
+
+	httpSvc.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { httpSvc.listener.Close() })
+	h.http, h.served = httpSvc, make(chan error, 1)
+	go func() {
+		h.served <- httpSvc.Start()
+	}()
+
+	h.URL = "http://" + httpSvc.listener.Addr().String()
+	return h
+}
+
+// get requests path from the api, returning the response & its body
+func (h *testHarness) get(t *testing.T, path string) (*http.Response, []byte) {
+	t.Helper()
+	res, err := http.Get(h.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, body
+}
+
+// setNFT stores a legacy NFT whose metadata file is served by the origin at metadataPath
+func (h *testHarness) setNFT(mint solana.PublicKey, metadataPath string, metadata interface{}) {
+	file, _ := json.Marshal(metadata)
+	uri := h.origin.setFile(metadataPath, "application/json", file)
+
+	h.rpc.setMint(mint, solana.TokenProgramID, 1, 0, nil)
+	address, _, _ := (&SolanaService{}).FindTokenMetadataAddress(mint, solana.TokenMetadataProgramID)
+	h.rpc.setAccount(address, solana.TokenMetadataProgramID, metadataAccount(mint, "Harness", uri))
+}
+
+// metadataAccount encodes a legacy metaplex metadata account pointing at uri
+func metadataAccount(mint solana.PublicKey, name, uri string) []byte {
+	var b bytes.Buffer
+	b.WriteByte(4) //MetadataV1
+	b.Write(solana.NewWallet().PublicKey().Bytes())
+	b.Write(mint[:])
+	borshString(&b, name)
+	borshString(&b, "HRN")
+	borshString(&b, uri)
+	binary.Write(&b, binary.LittleEndian, uint16(500))
+	b.WriteByte(0)           //No creators
+	b.Write([]byte{0, 1})    //Primary sale pending, mutable
+	b.Write(make([]byte, 6)) //No edition nonce, token standard, collection, uses, collection details or config
+	b.Write(make([]byte, 679-b.Len()))
+	return b.Bytes()
+}
+
+func testPNG(width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 200, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+func TestHttpService(t *testing.T) {
+	h := newTestHarness(t)
+
+	mint := solana.NewWallet().PublicKey()
+	video := []byte("not really an mp4")
+	imageUri := h.origin.setFile("/1.png", "image/png", testPNG(1024, 512))
+	videoUri := h.origin.setFile("/1.mp4", "video/mp4", video)
+	h.setNFT(mint, "/1.json", map[string]interface{}{
+		"name":   "Harness #1",
+		"symbol": "HRN",
+		"image":  imageUri,
+		"files": []map[string]string{
+			{"URL": imageUri, "type": "image/png"},
+			{"URL": videoUri, "type": "video/mp4"},
+		},
+	})
+
+	t.Run("NFT", func(t *testing.T) {
+		res, body := h.get(t, "/v1/nfts/"+mint.String())
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", res.StatusCode, body)
+		}
+
+		var media nft_proxy.Media
+		if err := json.Unmarshal(body, &media); err != nil {
+			t.Fatal(err)
+		}
+		if media.Mint != mint.String() || media.Name != "Harness #1" || media.ImageUri != imageUri || media.ImageType != "png" || media.MediaUri != videoUri {
+			t.Fatalf("Unexpected media %+v", media)
+		}
+
+		h.rpc.reset()
+		res, _ = h.get(t, "/v1/nfts/"+mint.String())
+		if res.StatusCode != http.StatusOK || h.rpc.Requests(mint) != 0 {
+			t.Fatal("Expected cached media to be served without an rpc call")
+		}
+	})
+
+	t.Run("Image", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			res, body := h.get(t, "/v1/nfts/"+mint.String()+"/image")
+			if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "image/png" {
+				t.Fatalf("Expected a png, got %d %s", res.StatusCode, res.Header.Get("Content-Type"))
+			}
+			cfg, err := png.DecodeConfig(bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Width != 1440 || cfg.Height != 720 {
+				t.Fatalf("Expected the image resized to a height of 720px, got %dx%d", cfg.Width, cfg.Height)
+			}
+		}
+		if n := h.origin.Requests("/1.png"); n != 1 {
+			t.Fatalf("Expected the original to be downloaded once, got %d", n)
+		}
+	})
+
+	t.Run("Media", func(t *testing.T) {
+		res, body := h.get(t, "/v1/nfts/"+mint.String()+"/media")
+		if res.StatusCode != http.StatusOK || !bytes.Equal(body, video) {
+			t.Fatalf("Expected the animation file, got %d: %q", res.StatusCode, body)
+		}
+	})
+
+	t.Run("Invalid Key", func(t *testing.T) {
+		res, body := h.get(t, "/v1/nfts/not-a-key")
+		if res.StatusCode != http.StatusBadRequest || !bytes.Contains(body, []byte("invalid key")) {
+			t.Fatalf("Expected 400, got %d: %s", res.StatusCode, body)
+		}
+
+		for _, path := range []string{"/v1/nfts/not-a-key/image", "/v1/nfts/not-a-key/media"} {
+			res, body := h.get(t, path)
+			if res.StatusCode != http.StatusNotFound || !bytes.Equal(body, h.defaultImage) {
+				t.Fatalf("%s: expected the default image with a 404, got %d", path, res.StatusCode)
+			}
+		}
+	})
+
+	t.Run("Unknown Mint", func(t *testing.T) {
+		res, body := h.get(t, "/v1/nfts/"+solana.NewWallet().PublicKey().String())
+		if res.StatusCode != http.StatusBadRequest || !bytes.Contains(body, []byte(ErrTokenDataNotFound.Error())) {
+			t.Fatalf("Expected 400, got %d: %s", res.StatusCode, body)
+		}
+	})
+
+	t.Run("Missing Image", func(t *testing.T) {
+		broken := solana.NewWallet().PublicKey()
+		h.setNFT(broken, "/2.json", map[string]interface{}{"name": "Harness #2", "image": h.origin.URL + "/missing.png"})
+
+		res, body := h.get(t, "/v1/nfts/"+broken.String()+"/image")
+		if res.StatusCode != http.StatusNotFound || !bytes.Equal(body, h.defaultImage) {
+			t.Fatalf("Expected the default image with a 404, got %d", res.StatusCode)
+		}
+
+		res, _ = h.get(t, "/v1/nfts/"+broken.String()+"/media")
+		if res.StatusCode != http.StatusNotFound {
+			t.Fatalf("Expected a 404 for an nft without media, got %d", res.StatusCode)
+		}
+	})
+
+	t.Run("Missing Metadata File", func(t *testing.T) {
+		bare := solana.NewWallet().PublicKey()
+		h.rpc.setMint(bare, solana.TokenProgramID, 1, 0, nil)
+		address, _, _ := (&SolanaService{}).FindTokenMetadataAddress(bare, solana.TokenMetadataProgramID)
+		h.rpc.setAccount(address, solana.TokenMetadataProgramID, metadataAccount(bare, "On-chain Name", h.origin.URL+"/gone.json"))
+
+		res, body := h.get(t, "/v1/nfts/"+bare.String())
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", res.StatusCode, body)
+		}
+		var media nft_proxy.Media
+		json.Unmarshal(body, &media)
+		if media.Name != "On-chain Name" || media.ImageUri != "" {
+			t.Fatalf("Expected the on-chain name without an image, got %+v", media)
+		}
+	})
+
+	t.Run("No Route", func(t *testing.T) {
+		res, body := h.get(t, "/v2/nfts")
+		if res.StatusCode != http.StatusNotFound || !bytes.Contains(body, []byte("PAGE_NOT_FOUND")) {
+			t.Fatalf("Expected 404, got %d: %s", res.StatusCode, body)
+		}
+	})
+}
+
+func TestHttpService_Shutdown(t *testing.T) {
+	h := newTestHarness(t)
+
+	mint := solana.NewWallet().PublicKey()
+	h.setNFT(mint, "/1.json", map[string]interface{}{
+		"name":  "Harness #1",
+		"image": h.origin.setFile("/1.png", "image/png", testPNG(64, 64)),
+	})
+	if res, body := h.get(t, "/v1/nfts/"+mint.String()); res.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", res.StatusCode, body)
+	}
+
+	arrived, release := h.origin.hold()
+	defer release()
+
+	//An image request in flight when shutdown starts is completed
+	images := make(chan error, 1)
+	go func() {
+		res, err := http.Get(h.URL + "/v1/nfts/" + mint.String() + "/image")
+		if err == nil {
+			defer res.Body.Close()
+			_, err = png.DecodeConfig(res.Body)
+		}
+		images <- err
+	}()
+	select {
+	case <-arrived:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the image to be fetched")
+	}
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- h.http.Shutdown(ctx.Background())
+	}()
+
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned with a request in flight: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	release()
+	if err := <-images; err != nil {
+		t.Fatalf("Expected the in-flight request to complete with the image, got %v", err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-h.served; err != nil {
+		t.Fatalf("Expected Start to return cleanly, got %v", err)
+	}
+
+	if _, err := http.Get(h.URL + "/ping"); err == nil {
+		t.Fatal("Expected new connections to be refused")
+	}
+	if files, _ := filepath.Glob(filepath.Join(h.http.Config.Media.CacheDir, "*", "*", ".tmp-*")); len(files) > 0 {
+		t.Fatalf("Expected no partial files, got %v", files)
+	}
+}
@@ -1,10 +1,10 @@
 package services
 
 import (
-	"encoding/base64"
 	"errors"
 	"fmt"
 	nft_proxy "github.com/alphabatem/nft-proxy"
+	"github.com/alphabatem/nft-proxy/config"
 	"github.com/babilu-online/common/context"
 	"github.com/gagliardetto/solana-go"
 	"github.com/gin-gonic/gin"
@@ -12,20 +12,25 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"strings"
+	"path/filepath"
 	"time"
 )
 
 type ImageService struct {
 	context.DefaultService
+	Config *config.Config
 
-	defaultSize int
+	defaultSize int //Gifs will be half the size
+	legacyDir   string
 
 	httpMedia *http.Client
 
-	solSvc *SolanaImageService
-	resize *ResizeService
-	sql    *SqliteService
+	solSvc  *SolanaImageService
+	resize  *ResizeService
+	sql     *SqliteService
+	store   *StoreService
+	gateway *GatewayService
+	stats   *StatService
 
 	exemptImages map[string]struct{} //Some older & core tokens dont have active metadata so we shouldn't update them
 }
@@ -37,23 +42,25 @@ func (svc ImageService) Id() string {
 }
 
 func (svc *ImageService) Start() error {
+	if svc.Config == nil {
+		return config.ErrMissingConfig
+	}
+
 	svc.solSvc = svc.DefaultService(SOLANA_IMG_SVC).(*SolanaImageService)
 	svc.sql = svc.DefaultService(SQLITE_SVC).(*SqliteService)
 	svc.resize = svc.DefaultService(RESIZE_SVC).(*ResizeService)
+	svc.store = svc.DefaultService(STORE_SVC).(*StoreService)
+	svc.gateway = svc.DefaultService(GATEWAY_SVC).(*GatewayService)
+	svc.stats = svc.DefaultService(STAT_SVC).(*StatService)
 
-	svc.httpMedia = &http.Client{Timeout: 10 * time.Second}
+	svc.httpMedia = withRetries(newOutboundClient(svc.Config.Media.DownloadTimeout), retryPolicyFrom(originRetryPolicy, svc.Config.Retry.Origin), svc.stats)
 
-	svc.defaultSize = 720 //Gifs will be half the size
+	svc.defaultSize = svc.Config.Media.DefaultSize
+	svc.legacyDir = filepath.Join(svc.Config.Media.CacheDir, "solana")
 
-	svc.exemptImages = map[string]struct{}{
-		"2kMpEJCZL8vEDZe7YPLMCS9Y3WKSAMedXBn7xHPvsWvi": {},
-		"7xKXtg2CW87d97TXJSDpbD5jBkheTqA83TZRuJosgAsU": {},
-		"AFbX8oGjGpmVFywbVouvhQSRmiW2aR1mohfahi4Y2AdB": {},
-		"CKfatsPMUf8SkiURsDXs7eK6GWb4Jsd6UDbs7twMCWxo": {},
-		"EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v": {},
-		"Es9vMFrzaCERmJfrF4H2FYD4KCoNkY11McCe8BenwNYB": {},
-		"mSoLzYCxHdYgdzU16g5QSh3i5K3z3KZK7ytfqcJm7So":  {},
-		"So11111111111111111111111111111111111111112":  {},
+	svc.exemptImages = make(map[string]struct{}, len(svc.Config.Media.ExemptImages))
+	for _, mint := range svc.Config.Media.ExemptImages {
+		svc.exemptImages[mint] = struct{}{}
 	}
 
 	return nil
@@ -81,19 +88,13 @@ func (svc *ImageService) ImageFile(c *gin.Context, key string) error {
 		return errors.New("unsupported chain")
 	}
 
-	cacheName := fmt.Sprintf("./cache/solana/%s.%s", media.Mint, media.ImageType)
-
-	//Check for file or fetch
-	ifo, err := os.Stat(cacheName)
-	if err != nil || ifo.Size() == 0 { //Missing cached image
-		err := svc.fetchMissingImage(media, cacheName)
-		if err != nil {
-			return err
-		}
+	path, err := svc.variant(media, svc.defaultSize)
+	if err != nil {
+		return err
 	}
-	//log.Printf("Using cached file: %s", cacheName)
+	//log.Printf("Using cached file: %s", path)
 
-	return svc.writeFile(c, cacheName, media)
+	return svc.writeFile(c, path, media)
 }
 
 func (svc *ImageService) ClearCache(key string) error {
@@ -108,13 +109,121 @@ func (svc *ImageService) ClearCache(key string) error {
 		return nil
 	}
 
-	cacheName := fmt.Sprintf("./cache/solana/%s.%s", m.Mint, m.ImageType)
-	err = svc.fetchMissingImage(m, cacheName)
+	//Variants are dropped when the downloaded image differs from the stored original
+	_, _, err = svc.fetchOriginal(m, true)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	_, err = svc.variant(m, svc.defaultSize)
+	return err
+}
+
+// Refresh reloads the metadata of key from chain, dropping its cached royalties & clearing its cached image when the image changed
+func (svc *ImageService) Refresh(key string) error {
+	var previous nft_proxy.SolanaMedia
+	svc.sql.Db().First(&previous, "mint = ?", key)
+
+	media, err := svc.solSvc.FetchMetadata(key)
+	if err != nil {
+		return err
+	}
+
+	//Royalties are read from the same accounts, drop them to be read again on the next request
+	err = svc.sql.Db().Delete(&nft_proxy.SolanaRoyalties{}, "mint = ?", key).Error
+	if err != nil {
+		return err
+	}
+
+	if media.ImageUri == previous.ImageUri {
+		return nil
+	}
+
+	log.Printf("%s image changed, clearing cache", key)
+	return svc.ClearCache(key)
+}
+
+// RegenerateVariants rebuilds the resized images for key from its stored original without downloading it again
+func (svc *ImageService) RegenerateVariants(key string) error {
+	m, err := svc.solSvc.Media(key, false)
+	if err != nil {
+		return err
+	}
+
+	_, err = svc.store.OriginalInfo(m.Mint)
+	if err != nil {
+		return err
+	}
+
+	err = svc.store.RemoveVariants(m.Mint)
+	if err != nil {
+		return err
+	}
+
+	_, err = svc.variant(m, svc.defaultSize)
+	return err
+}
+
+// variant returns the path of the resized image, generating it from the stored original when missing
+func (svc *ImageService) variant(media *nft_proxy.Media, size int) (string, error) {
+	format := variantType(media.ImageType)
+	path := svc.store.VariantPath(media.Mint, size, format)
+	ifo, err := os.Stat(path)
+	if err == nil && ifo.Size() > 0 {
+		return path, nil
+	}
+
+	_, data, err := svc.store.Original(media.Mint)
+	if err != nil { //Missing original image
+		_, data, err = svc.fetchOriginal(media, false)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	//log.Printf("Resizing file: %s", path)
+	return svc.store.WriteVariant(media.Mint, size, format, func(w io.Writer) error {
+		return svc.resize.Resize(data, w, size)
+	})
+}
+
+// variantType is the format resized images of imageType are stored & served as.
+// There is no WebP encoder, so WebP is resized to PNG, or APNG when animated.
+func variantType(imageType string) string {
+	if imageType == "webp" {
+		return "png"
+	}
+	return imageType
+}
+
+// fetchOriginal returns the mints original image, reusing an original from the same uri unless refreshing.
+// Downloaded bytes are kept untouched & shared between mints with identical content.
+func (svc *ImageService) fetchOriginal(media *nft_proxy.Media, refresh bool) (string, []byte, error) {
+	if !refresh {
+		original, err := svc.store.LinkByUri(media.Mint, media.ImageUri)
+		if err == nil {
+			_, data, err := svc.store.Original(media.Mint)
+			if err == nil {
+				return original.Hash, data, nil
+			}
+		}
+	}
+
+	data, err := svc.downloadImage(media)
+	if err != nil {
+		//Fall back to images seeded in the legacy cache (older tokens without active metadata)
+		legacy, lErr := os.ReadFile(filepath.Join(svc.legacyDir, fmt.Sprintf("%s.%s", media.Mint, media.ImageType)))
+		if lErr != nil || len(legacy) == 0 {
+			return "", nil, err
+		}
+		data = legacy
+	}
+
+	hash, err := svc.store.SaveOriginal(media.Mint, media.ImageUri, data)
+	if err != nil {
+		return "", nil, err
+	}
+	return hash, data, nil
 }
 
 func (svc *ImageService) writeFile(c *gin.Context, path string, media *nft_proxy.Media) error {
@@ -133,7 +242,7 @@ func (svc *ImageService) writeFile(c *gin.Context, path string, media *nft_proxy
 	c.Header("Cache-Control", "public, max=age=172800")
 	c.Header("Vary", "Accept-Encoding")
 	c.Header("Last-Modified", modTime.Format("Mon, 02 Jan 2006 15:04:05 GMT")) //Mon, 03 Jun 2020 11:35:28 GMT
-	c.Header("Content-Type", fmt.Sprintf("image/%s", media.ImageType))
+	c.Header("Content-Type", fmt.Sprintf("image/%s", variantType(media.ImageType)))
 
 	_, err = io.Copy(c.Writer, file)
 	if err != nil {
@@ -142,71 +251,44 @@ func (svc *ImageService) writeFile(c *gin.Context, path string, media *nft_proxy
 	return nil
 }
 
-func (svc *ImageService) fetchMissingImage(media *nft_proxy.Media, cacheName string) error {
+func (svc *ImageService) downloadImage(media *nft_proxy.Media) ([]byte, error) {
 	if media.ImageUri == "" {
-		return errors.New("invalid image")
+		return nil, errors.New("invalid image")
 	}
 
-	var err error
 	var data []byte
-	if strings.Contains(media.ImageUri, nft_proxy.BASE64_PREFIX) {
-		base64String := media.ImageUri
-		// Remove the data:image/jpeg;base64, prefix if present
-		if v := strings.Index(base64String, nft_proxy.BASE64_PREFIX); v > -1 {
-			base64String = base64String[v+len(nft_proxy.BASE64_PREFIX):]
-		}
-
-		data, err = base64.StdEncoding.DecodeString(base64String)
+	if isDataURI(media.ImageUri) {
+		d, err := parseDataURI(media.ImageUri, maxDataURIImage)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		data = d.Data
 	} else {
-		media.ImageUri = strings.Replace(strings.TrimSpace(media.ImageUri), ".ipfs.nftstorage.link", ".ipfs.w3s.link", 1)
-
 		log.Println("Fetching", media.ImageUri)
 
-		req, err := http.NewRequest("GET", media.ImageUri, nil)
-		if err != nil {
-			return err
-		}
-
 		// Uses a more generic value (Mozilla/5.0), avoiding the hard-coded Postman value that could cause issues with APIs.
-		req.Header.Set("User-Agent", "PostmanRuntime/7.29.2")
-		req.Header.Set("Accept", "*/*")
-		req.Header.Set("Accept-Encoding", "gzip,deflate,br")
+		header := http.Header{}
+		header.Set("User-Agent", "PostmanRuntime/7.29.2")
+		header.Set("Accept", "*/*")
+		header.Set("Accept-Encoding", "gzip,deflate,br")
 
-		resp, err := svc.httpMedia.Do(req)
+		resp, err := svc.gateway.Get(svc.httpMedia, media.ImageUri, header)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		defer resp.Body.Close()
 
-		if resp.StatusCode != 200 {
-			return errors.New(resp.Status)
-		}
-
-		data, err = io.ReadAll(resp.Body)
+		data, err = readResponse(resp, maxImageBytes, imageContentTypes)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
 	if len(data) == 0 {
-		return errors.New("failed to download image")
-	}
-
-	output, err := os.Create(cacheName)
-	if err != nil {
-		return err
+		return nil, errors.New("failed to download image")
 	}
-	defer output.Close()
 
-	//log.Printf("Resizing file: %s", cacheName)
-	err = svc.resize.Resize(data, output, svc.defaultSize)
-	if err != nil {
-		return err
-	}
-	return nil
+	return data, nil
 }
 
 func (svc *ImageService) MediaFile(c *gin.Context, key string) error {
@@ -217,13 +299,21 @@ func (svc *ImageService) MediaFile(c *gin.Context, key string) error {
 		if err != nil {
 			return err
 		}
+	} else {
+		return errors.New("unsupported chain")
 	}
 
 	if media.MediaUri == "" {
 		return errors.New("no media for mint")
 	}
 
-	resp, err := svc.httpMedia.Get(media.MediaUri)
+	resp, err := svc.gateway.Get(svc.httpMedia, media.MediaUri, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	err = checkResponse(resp, maxMediaBytes, mediaContentTypes)
 	if err != nil {
 		return err
 	}
@@ -232,12 +322,21 @@ func (svc *ImageService) MediaFile(c *gin.Context, key string) error {
 	c.Header("Cache-Control", "public, max-age=31536000")
 	c.Header("Expires", time.Now().AddDate(0, 1, 0).Format(http.TimeFormat))
 	c.Header("Content-Type", media.MediaType)
-	err = resp.Write(c.Writer)
-	if err != nil {
-		return err
+	_, err = io.Copy(c.Writer, &limitedReader{r: resp.Body, n: maxMediaBytes})
+	if err != nil && c.Writer.Written() {
+		//The status has been sent, drop the connection so clients don't keep a truncated file
+		abortResponse(c)
 	}
+	return err
+}
 
-	return nil
+// abortResponse closes the connection of a started response, which clients see as a failed download
+func abortResponse(c *gin.Context) {
+	conn, _, err := c.Writer.Hijack()
+	if err != nil {
+		return
+	}
+	conn.Close()
 }
 
 func (svc *ImageService) IsSolKey(key string) bool {
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	nft_proxy "github.com/alphabatem/nft-proxy"
+	"github.com/gin-gonic/gin"
+)
+
+func TestImageService_WriteFile(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	path := filepath.Join(t.TempDir(), "variant")
+	if err := os.WriteFile(path, []byte("resized"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	svc := ImageService{}
+	tests := []struct {
+		name        string
+		imageType   string
+		contentType string
+	}{
+		{"PNG", "png", "image/png"},
+		{"GIF", "gif", "image/gif"},
+		{"WebP", "webp", "image/png"}, //Resized to png, there is no WebP encoder
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+
+			err := svc.writeFile(c, path, &nft_proxy.Media{Mint: "mint", ImageType: test.imageType})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := w.Header().Get("Content-Type"); got != test.contentType {
+				t.Fatalf("Expected %s, got %s", test.contentType, got)
+			}
+			if w.Body.String() != "resized" {
+				t.Fatalf("Unexpected body %q", w.Body.String())
+			}
+		})
+	}
+
+	t.Run("Valid Type", func(t *testing.T) {
+		if !(&SolanaImageService{}).ValidType("webp") {
+			t.Fatal("Expected webp images to be accepted")
+		}
+	})
+}
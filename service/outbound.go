@@ -0,0 +1,178 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrBlockedDestination    = errors.New("destination not allowed")
+	ErrTooManyRedirects      = errors.New("too many redirects")
+	ErrResponseTooLarge      = errors.New("response too large")
+	ErrUnexpectedContentType = errors.New("unexpected content type")
+)
+
+const maxRedirects = 5
+
+// Limits on bodies fetched from uris found in on-chain metadata
+const (
+	maxMetadataBytes = 2 << 20
+	maxImageBytes    = 50 << 20 //Matches the resize decode limit
+	maxMediaBytes    = 200 << 20
+)
+
+// Expected content types, gateways & object stores frequently serve octet-stream or nothing at all
+var (
+	metadataContentTypes = []string{"application/json", "text/json", "text/plain", "application/octet-stream", "binary/octet-stream"}
+	imageContentTypes    = []string{"image/", "application/octet-stream", "binary/octet-stream"}
+	mediaContentTypes    = []string{"video/", "audio/", "model/", "image/", "application/octet-stream", "binary/octet-stream"}
+)
+
+// blockedPrefixes are special purpose ranges not covered by the net/netip helpers
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       //"This" network
+	netip.MustParsePrefix("100.64.0.0/10"),   //Carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),    //IETF protocol assignments
+	netip.MustParsePrefix("192.0.2.0/24"),    //Documentation
+	netip.MustParsePrefix("198.18.0.0/15"),   //Benchmarking
+	netip.MustParsePrefix("198.51.100.0/24"), //Documentation
+	netip.MustParsePrefix("203.0.113.0/24"),  //Documentation
+	netip.MustParsePrefix("240.0.0.0/4"),     //Reserved & broadcast
+	netip.MustParsePrefix("64:ff9b::/96"),    //NAT64, embeds an IPv4 address
+	netip.MustParsePrefix("64:ff9b:1::/48"),  //Local-use NAT64
+	netip.MustParsePrefix("100::/64"),        //Discard-only
+	netip.MustParsePrefix("2001::/32"),       //Teredo, embeds an IPv4 address
+	netip.MustParsePrefix("2001:db8::/32"),   //Documentation
+	netip.MustParsePrefix("2002::/16"),       //6to4, embeds an IPv4 address
+	netip.MustParsePrefix("fd00:ec2::/32"),   //AWS IPv6 metadata endpoint
+	netip.MustParsePrefix("fec0::/10"),       //Deprecated site-local
+}
+
+// publicAddr reports whether addr is routable on the public internet
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return false
+	}
+
+	for _, p := range blockedPrefixes {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// newOutboundClient returns a client safe for fetching user controlled urls, it refuses to connect to
+// non-public addresses & follows a limited number of http(s) redirects
+func newOutboundClient(timeout time.Duration) *http.Client {
+	return newOutboundClientWith(timeout, func(addr netip.AddrPort) bool {
+		return publicAddr(addr.Addr())
+	})
+}
+
+// newOutboundClientWith checks every connection against allow once DNS has been resolved, so names
+// resolving to internal addresses & redirects to them are caught at the point of connecting
+func newOutboundClientWith(timeout time.Duration, allow func(netip.AddrPort) bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addr, err := netip.ParseAddrPort(address)
+			if err != nil || !allow(addr) {
+				return fmt.Errorf("%w: %s", ErrBlockedDestination, address)
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:                 nil, //A proxy would connect on our behalf, bypassing the address check
+			DialContext:           dialer.DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return ErrTooManyRedirects
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("%w: %s", ErrBlockedDestination, req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
+
+// readResponse reads at most limit bytes of resp, rejecting bodies of unexpected content types
+func readResponse(resp *http.Response, limit int64, contentTypes []string) ([]byte, error) {
+	err := checkResponse(resp, limit, contentTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(&limitedReader{r: resp.Body, n: limit})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// limitedReader reads at most n bytes of r, failing with ErrResponseTooLarge rather than ending early
+// when r is longer, so bodies without a declared length are never silently truncated
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1] //One byte past the limit shows whether r is longer
+	}
+
+	n, err := l.r.Read(p)
+	if int64(n) > l.n {
+		n, l.n = int(l.n), 0
+		return n, ErrResponseTooLarge
+	}
+	l.n -= int64(n)
+	return n, err
+}
+
+// checkResponse validates the declared length & content type of resp before its body is read
+func checkResponse(resp *http.Response, limit int64, contentTypes []string) error {
+	if resp.ContentLength > limit {
+		return fmt.Errorf("%w: %v bytes", ErrResponseTooLarge, resp.ContentLength)
+	}
+
+	header := resp.Header.Get("Content-Type")
+	if header == "" {
+		return nil
+	}
+
+	contentType, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrUnexpectedContentType, header)
+	}
+
+	for _, t := range contentTypes {
+		if contentType == t || strings.HasSuffix(t, "/") && strings.HasPrefix(contentType, t) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrUnexpectedContentType, contentType)
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPublicAddr(t *testing.T) {
+	tests := map[string]bool{
+		"8.8.8.8":                true,
+		"2606:4700:4700::1111":   true,
+		"127.0.0.1":              false,
+		"10.1.2.3":               false,
+		"172.16.0.1":             false,
+		"192.168.1.1":            false,
+		"169.254.169.254":        false,
+		"100.100.100.200":        false,
+		"0.0.0.0":                false,
+		"255.255.255.255":        false,
+		"::1":                    false,
+		"::":                     false,
+		"fe80::1":                false,
+		"fc00::1":                false,
+		"fd00:ec2::254":          false,
+		"::ffff:127.0.0.1":       false,
+		"::ffff:169.254.169.254": false,
+		"64:ff9b::a9fe:a9fe":     false,
+	}
+
+	for ip, expected := range tests {
+		if publicAddr(netip.MustParseAddr(ip)) != expected {
+			t.Errorf("%s: expected public %v", ip, expected)
+		}
+	}
+}
+
+func TestOutboundClient(t *testing.T) {
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secret"))
+	}))
+	defer internal.Close()
+
+	external := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/redirect-internal":
+			http.Redirect(w, r, internal.URL, http.StatusFound)
+		case "/redirect-loop":
+			http.Redirect(w, r, "/redirect-loop", http.StatusFound)
+		case "/large":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte(strings.Repeat("a", 2048)))
+		case "/html":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte("<html></html>"))
+		default:
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("image"))
+		}
+	}))
+	defer external.Close()
+
+	//Loopback stands in for the internet, only the external listener is treated as public
+	externalPort := netip.MustParseAddrPort(strings.TrimPrefix(external.URL, "http://")).Port()
+	client := newOutboundClientWith(time.Second, func(addr netip.AddrPort) bool {
+		return addr.Port() == externalPort
+	})
+
+	t.Run("Allowed", func(t *testing.T) {
+		resp, err := client.Get(external.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		data, err := readResponse(resp, 1024, imageContentTypes)
+		if err != nil || string(data) != "image" {
+			t.Fatalf("Expected image, got %s (%v)", data, err)
+		}
+	})
+
+	t.Run("Blocked", func(t *testing.T) {
+		_, err := client.Get(internal.URL)
+		if !errors.Is(err, ErrBlockedDestination) {
+			t.Fatalf("Expected ErrBlockedDestination, got %v", err)
+		}
+
+		_, err = newOutboundClient(time.Second).Get(external.URL)
+		if !errors.Is(err, ErrBlockedDestination) {
+			t.Fatalf("Expected loopback to be blocked by default, got %v", err)
+		}
+	})
+
+	t.Run("Blocked After DNS", func(t *testing.T) {
+		u, _ := url.Parse(internal.URL)
+		_, err := newOutboundClient(time.Second).Get("http://localhost:" + u.Port())
+		if !errors.Is(err, ErrBlockedDestination) {
+			t.Fatalf("Expected resolved loopback to be blocked, got %v", err)
+		}
+	})
+
+	t.Run("Blocked Redirect", func(t *testing.T) {
+		_, err := client.Get(external.URL + "/redirect-internal")
+		if !errors.Is(err, ErrBlockedDestination) {
+			t.Fatalf("Expected redirect to internal address to be blocked, got %v", err)
+		}
+	})
+
+	t.Run("Redirect Limit", func(t *testing.T) {
+		_, err := client.Get(external.URL + "/redirect-loop")
+		if !errors.Is(err, ErrTooManyRedirects) {
+			t.Fatalf("Expected ErrTooManyRedirects, got %v", err)
+		}
+	})
+
+	t.Run("Size Limit", func(t *testing.T) {
+		resp, err := client.Get(external.URL + "/large")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		_, err = readResponse(resp, 1024, imageContentTypes)
+		if !errors.Is(err, ErrResponseTooLarge) {
+			t.Fatalf("Expected ErrResponseTooLarge, got %v", err)
+		}
+
+		//Without a declared length the body is still cut off at the limit
+		resp.ContentLength = -1
+		_, err = readResponse(resp, 1024, imageContentTypes)
+		if !errors.Is(err, ErrResponseTooLarge) {
+			t.Fatalf("Expected ErrResponseTooLarge when streaming, got %v", err)
+		}
+
+		//Streamed bodies fail once past the limit rather than being cut short
+		var out bytes.Buffer
+		_, err = io.Copy(&out, &limitedReader{r: strings.NewReader("12345678"), n: 4})
+		if !errors.Is(err, ErrResponseTooLarge) || out.String() != "1234" {
+			t.Fatalf("Expected ErrResponseTooLarge after 1234, got %s (%v)", out.String(), err)
+		}
+		out.Reset()
+		_, err = io.Copy(&out, &limitedReader{r: strings.NewReader("1234"), n: 4})
+		if err != nil || out.String() != "1234" {
+			t.Fatalf("Expected a body at the limit to be read, got %s (%v)", out.String(), err)
+		}
+	})
+
+	t.Run("Content Type", func(t *testing.T) {
+		resp, err := client.Get(external.URL + "/html")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		_, err = readResponse(resp, 1024, imageContentTypes)
+		if !errors.Is(err, ErrUnexpectedContentType) {
+			t.Fatalf("Expected ErrUnexpectedContentType, got %v", err)
+		}
+	})
+}
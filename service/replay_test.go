@@ -0,0 +1,140 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// rpcInteraction is a recorded JSON-RPC call & the result the node returned
+type rpcInteraction struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+}
+
+// rpcReplay is a local JSON-RPC node answering from a fixture under testdata/rpc.
+// With RPC_RECORD_URL set, calls are forwarded to that node instead & the fixture is rewritten from its answers.
+type rpcReplay struct {
+	*httptest.Server
+
+	t        *testing.T
+	path     string
+	upstream string
+
+	mu           sync.Mutex
+	interactions []*rpcInteraction
+}
+
+func newRPCReplay(t *testing.T, fixture string) *rpcReplay {
+	t.Helper()
+	return replayRPC(t, fixture, os.Getenv("RPC_RECORD_URL"))
+}
+
+func replayRPC(t *testing.T, fixture, upstream string) *rpcReplay {
+	t.Helper()
+	r := &rpcReplay{t: t, path: filepath.Join("testdata", "rpc", fixture+".json"), upstream: upstream}
+
+	if upstream == "" {
+		raw, err := os.ReadFile(r.path)
+		if err != nil {
+			t.Fatalf("Missing rpc fixture, record it with RPC_RECORD_URL: %s", err)
+		}
+		if err := json.Unmarshal(raw, &r.interactions); err != nil {
+			t.Fatalf("Invalid rpc fixture %s: %s", r.path, err)
+		}
+		for _, i := range r.interactions {
+			var params bytes.Buffer
+			json.Compact(&params, i.Params) //Fixtures are stored indented
+			i.Params = params.Bytes()
+		}
+	}
+
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serve))
+	t.Cleanup(r.Close)
+	if upstream != "" {
+		t.Cleanup(r.save)
+	}
+	return r
+}
+
+func (r *rpcReplay) serve(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	var call struct {
+		ID     interface{}     `json:"id"`
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+	}
+	json.Unmarshal(body, &call)
+
+	var params bytes.Buffer
+	json.Compact(&params, call.Params)
+
+	var result json.RawMessage
+	if r.upstream != "" {
+		result = r.record(call.Method, params.Bytes(), body)
+	} else {
+		result = r.replay(call.Method, params.Bytes())
+	}
+
+	if result == nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": call.ID, "error": map[string]interface{}{"code": -32601, "message": "not recorded"}})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": call.ID, "result": result})
+}
+
+func (r *rpcReplay) replay(method string, params []byte) json.RawMessage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, i := range r.interactions {
+		if i.Method == method && bytes.Equal(i.Params, params) {
+			return i.Result
+		}
+	}
+	r.t.Errorf("No recorded %s call with params %s in %s", method, params, r.path)
+	return nil
+}
+
+// record forwards body to the upstream node, keeping its result for the fixture
+func (r *rpcReplay) record(method string, params, body []byte) json.RawMessage {
+	res, err := http.Post(r.upstream, "application/json", bytes.NewReader(body))
+	if err != nil {
+		r.t.Errorf("Recording %s: %s", method, err)
+		return nil
+	}
+	defer res.Body.Close()
+
+	var answer struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&answer); err != nil || answer.Result == nil {
+		r.t.Errorf("Recording %s: unexpected answer (%v)", method, err)
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.interactions = append(r.interactions, &rpcInteraction{Method: method, Params: params, Result: answer.Result})
+	return answer.Result
+}
+
+func (r *rpcReplay) save() {
+	if r.t.Failed() {
+		return //Keep the previous recording
+	}
+	raw, err := json.MarshalIndent(r.interactions, "", "  ")
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Dir(r.path), 0755)
+	if err := os.WriteFile(r.path, append(raw, '\n'), 0644); err != nil {
+		r.t.Fatal(err)
+	}
+}
@@ -2,11 +2,14 @@ package services
 
 import (
 	"bytes"
+	ctx "context"
+	"errors"
+	"fmt"
+	"github.com/alphabatem/nft-proxy/config"
 	"github.com/babilu-online/common/context"
 	"github.com/nfnt/resize"
 	"golang.org/x/image/draw"
 	"image"
-	"image/color/palette"
 	"image/gif"
 	"log"
 
@@ -15,12 +18,50 @@ import (
 	"image/jpeg"
 	"image/png"
 	"io"
+	"runtime"
+	"time"
 )
 
 type ResizeService struct {
 	context.DefaultService
+	Config *config.Config
+
+	stats *StatService
+
+	workers      int
+	queueDepth   int
+	jobTimeout   time.Duration
+	jobs         chan *resizeJob
+	decodeLimits decodeLimits
+	limits       animationLimits
+}
+
+// decodeLimits rejects images before decoding, zero disables a limit
+type decodeLimits struct {
+	MaxBytes  int
+	MaxPixels int
+	MaxFrames int
 }
 
+type resizeJob struct {
+	ctx      ctx.Context
+	data     []byte
+	size     int
+	queuedAt time.Time
+	result   chan resizeResult
+}
+
+type resizeResult struct {
+	data []byte
+	err  error
+}
+
+var (
+	ErrImageTooLarge   = errors.New("image exceeds processing limits")
+	ErrResizeQueueFull = errors.New("resize queue full")
+	ErrResizeTimeout   = errors.New("resize timed out")
+)
+
 const RESIZE_SVC = "resize_svc"
 
 func (svc ResizeService) Id() string {
@@ -28,29 +69,208 @@ func (svc ResizeService) Id() string {
 }
 
 func (svc *ResizeService) Start() error {
+	if svc.Config == nil {
+		return config.ErrMissingConfig
+	}
+
+	svc.stats = svc.DefaultService(STAT_SVC).(*StatService)
+
+	cfg := svc.Config.Resize
+	svc.workers = cfg.Workers
+	if svc.workers == 0 {
+		svc.workers = runtime.NumCPU()
+	}
+	svc.queueDepth = cfg.QueueDepth
+	svc.jobTimeout = cfg.JobTimeout
+	svc.decodeLimits = decodeLimits{
+		MaxBytes:  cfg.MaxBytes,
+		MaxPixels: cfg.MaxPixels,
+		MaxFrames: cfg.MaxFrames,
+	}
+	svc.limits = animationLimits{
+		MaxFrames:   cfg.MaxAnimationFrames,
+		MaxDuration: cfg.MaxAnimationDuration,
+	}
+
+	svc.startWorkers()
 	return nil
 }
 
+func (svc *ResizeService) startWorkers() {
+	svc.jobs = make(chan *resizeJob, svc.queueDepth)
+	for i := 0; i < svc.workers; i++ {
+		go svc.worker()
+	}
+}
+
+// Resize queues data to be resized by the worker pool & writes the result to out once complete
 func (svc *ResizeService) Resize(data []byte, out io.Writer, size int) error {
-	src, typ, err := image.Decode(bytes.NewReader(data))
+	err := svc.checkLimits(data)
+	if err != nil {
+		return err
+	}
+
+	var c ctx.Context
+	var cancel ctx.CancelFunc
+	if svc.jobTimeout > 0 {
+		c, cancel = ctx.WithTimeout(ctx.Background(), svc.jobTimeout)
+	} else {
+		c, cancel = ctx.WithCancel(ctx.Background())
+	}
+	defer cancel()
+
+	job := &resizeJob{
+		ctx:      c,
+		data:     data,
+		size:     size,
+		queuedAt: time.Now(),
+		result:   make(chan resizeResult, 1),
+	}
+
+	select {
+	case svc.jobs <- job:
+	default:
+		if svc.stats != nil {
+			svc.stats.IncrementResizeRejected()
+		}
+		return ErrResizeQueueFull
+	}
+
+	select {
+	case res := <-job.result:
+		if res.err != nil {
+			return res.err
+		}
+
+		_, err = out.Write(res.data)
+		return err
+	case <-c.Done():
+		if svc.stats != nil {
+			svc.stats.IncrementResizeTimeouts()
+		}
+		return ErrResizeTimeout
+	}
+}
+
+func (svc *ResizeService) worker() {
+	for job := range svc.jobs {
+		if svc.stats != nil {
+			svc.stats.RecordResizeQueueWait(time.Since(job.queuedAt))
+		}
+
+		if job.ctx.Err() != nil {
+			continue //Timed out waiting in the queue
+		}
+
+		//Buffer output so a timed out job never writes a partial file
+		var buf bytes.Buffer
+		err := svc.resize(job.ctx, job.data, &buf, job.size)
+		job.result <- resizeResult{data: buf.Bytes(), err: err}
+	}
+}
+
+// checkLimits reads only the image headers to reject images too large to process
+func (svc *ResizeService) checkLimits(data []byte) error {
+	l := svc.decodeLimits
+	if l.MaxBytes > 0 && len(data) > l.MaxBytes {
+		return fmt.Errorf("%w: %v bytes", ErrImageTooLarge, len(data))
+	}
+
+	var width, height, frames int
+	switch {
+	case isAnimatedWebP(data):
+		canvas, count, err := webpCanvas(data)
+		if err != nil {
+			return err
+		}
+		width, height, frames = canvas.Dx(), canvas.Dy(), count
+	default:
+		cfg, typ, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		width, height, frames = cfg.Width, cfg.Height, 1
+
+		switch {
+		case typ == "gif":
+			frames, err = gifFrameCount(data)
+		case isAPNG(data):
+			frames, err = apngFrameCount(data)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if l.MaxPixels > 0 && width*height > l.MaxPixels {
+		return fmt.Errorf("%w: %vx%v", ErrImageTooLarge, width, height)
+	}
+	if l.MaxFrames > 0 && frames > l.MaxFrames {
+		return fmt.Errorf("%w: %v frames", ErrImageTooLarge, frames)
+	}
+	return nil
+}
+
+func (svc *ResizeService) resize(c ctx.Context, data []byte, out io.Writer, size int) error {
+	//Animated formats image.Decode would only read the first frame of
+	switch {
+	case isAPNG(data):
+		anim, err := decodeAPNG(data, svc.limits)
+		if err != nil {
+			return err
+		}
+
+		resized, err := svc.resizeAnimation(c, anim, size/2)
+		if err != nil {
+			return err
+		}
+
+		return encodeAPNG(out, resized)
+	case isAnimatedWebP(data):
+		anim, err := decodeAnimatedWebP(data, svc.limits)
+		if err != nil {
+			return err
+		}
+
+		resized, err := svc.resizeAnimation(c, anim, size/2)
+		if err != nil {
+			return err
+		}
+
+		//No WebP encoder available, APNG keeps the alpha channel a gif would lose
+		return encodeAPNG(out, resized)
+	}
+
+	//Sniff the format from the header, gifs are only decoded frame by frame within the animation limits
+	_, typ, err := image.DecodeConfig(bytes.NewReader(data))
 	if err != nil {
 		return err
 	}
 
 	if typ == "gif" {
-		g2, err := svc.resizeGif(data, 0, size/2)
+		anim, err := decodeGif(data, svc.limits)
 		if err != nil {
 			return err
 		}
 
-		return gif.EncodeAll(out, g2)
+		resized, err := svc.resizeAnimation(c, anim, size/2)
+		if err != nil {
+			return err
+		}
+
+		return gif.EncodeAll(out, svc.animationToGif(resized))
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return err
 	}
 
 	// Resize:
 	dst := resize.Resize(0, uint(size), src, resize.MitchellNetravali)
 
 	switch typ {
-	case "png":
+	case "png", "webp": //See variantType
 		return png.Encode(out, dst)
 	case "jpeg":
 		return jpeg.Encode(out, dst, &jpeg.Options{Quality: 100})
@@ -62,38 +282,65 @@ func (svc *ResizeService) Resize(data []byte, out io.Writer, size int) error {
 	}
 }
 
-func (svc *ResizeService) resizeGif(data []byte, width, height int) (*gif.GIF, error) {
-	im, err := gif.DecodeAll(bytes.NewReader(data))
-	if err != nil {
-		return nil, err
+// resizeAnimation resizes every frame to the given height, preserving aspect ratio
+func (svc *ResizeService) resizeAnimation(c ctx.Context, anim *animation, height int) (*animation, error) {
+	resized := animation{
+		Frames:    make([]*image.RGBA, len(anim.Frames)),
+		Delays:    anim.Delays,
+		LoopCount: anim.LoopCount,
 	}
 
-	if width == 0 {
-		width = int(im.Config.Width * height / im.Config.Width)
-	} else if height == 0 {
-		height = int(width * im.Config.Height / im.Config.Width)
+	for i, frame := range anim.Frames {
+		if err := c.Err(); err != nil {
+			return nil, err
+		}
+
+		dst := resize.Resize(0, uint(height), frame, resize.MitchellNetravali)
+		rgba, ok := dst.(*image.RGBA)
+		if !ok {
+			rgba = image.NewRGBA(dst.Bounds())
+			draw.Draw(rgba, rgba.Bounds(), dst, dst.Bounds().Min, draw.Src)
+		}
+		resized.Frames[i] = rgba
 	}
 
-	// reset the gif width and height
-	im.Config.Width = width
-	im.Config.Height = height
+	return &resized, nil
+}
 
-	firstFrame := im.Image[0].Bounds()
-	img := image.NewRGBA(image.Rect(0, 0, firstFrame.Dx(), firstFrame.Dy()))
+func (svc *ResizeService) animationToGif(anim *animation) *gif.GIF {
+	g := gif.GIF{
+		Image: make([]*image.Paletted, len(anim.Frames)),
+		Delay: make([]int, len(anim.Frames)),
+	}
+
+	//gif LoopCount is the number of repeats, -1 plays once
+	switch anim.LoopCount {
+	case 0:
+		g.LoopCount = 0
+	case 1:
+		g.LoopCount = -1
+	default:
+		g.LoopCount = anim.LoopCount - 1
+	}
+
+	for i, frame := range anim.Frames {
+		g.Image[i] = svc.imageToPaletted(frame)
+		g.Delay[i] = anim.Delays[i] / 10
+	}
 
-	// resize frame by frame
-	for index, frame := range im.Image {
-		b := frame.Bounds()
-		draw.Draw(img, b, frame, b.Min, draw.Over)
-		im.Image[index] = svc.imageToPaletted(resize.Resize(uint(width), uint(height), img, resize.MitchellNetravali))
+	if len(anim.Frames) > 0 {
+		b := anim.Frames[0].Bounds()
+		g.Config.Width = b.Dx()
+		g.Config.Height = b.Dy()
 	}
 
-	return im, nil
+	return &g
 }
 
-func (svc *ResizeService) imageToPaletted(img image.Image) *image.Paletted {
+// imageToPaletted quantizes img to its own adaptive palette
+func (svc *ResizeService) imageToPaletted(img *image.RGBA) *image.Paletted {
 	b := img.Bounds()
-	pm := image.NewPaletted(b, palette.Plan9)
-	draw.FloydSteinberg.Draw(pm, b, img, image.ZP)
+	pm := image.NewPaletted(b, quantize(img, 256))
+	draw.FloydSteinberg.Draw(pm, b, img, b.Min)
 	return pm
 }
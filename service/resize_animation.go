@@ -0,0 +1,188 @@
+package services
+
+import (
+	"image"
+	"image/color"
+	"sort"
+	"time"
+)
+
+// animationLimits caps the work done for huge animations, zero disables a limit
+type animationLimits struct {
+	MaxFrames   int
+	MaxDuration time.Duration
+}
+
+// animation is a decoded animated image, every frame is composited onto the full canvas
+type animation struct {
+	Frames    []*image.RGBA
+	Delays    []int //Milliseconds
+	LoopCount int   //Number of plays, 0 is infinite
+
+	stride      int
+	seen        int
+	duration    int
+	maxDuration int
+}
+
+// newAnimation prepares an animation for frameCount source frames, only keeping every nth frame when over the frame limit
+func newAnimation(frameCount int, limits animationLimits) *animation {
+	anim := animation{
+		stride:      1,
+		maxDuration: int(limits.MaxDuration.Milliseconds()),
+	}
+
+	if limits.MaxFrames > 0 && frameCount > limits.MaxFrames {
+		anim.stride = (frameCount + limits.MaxFrames - 1) / limits.MaxFrames
+	}
+
+	return &anim
+}
+
+// addFrame snapshots the canvas as the next frame, returning false once the duration limit has been reached
+func (a *animation) addFrame(canvas *image.RGBA, delay int) bool {
+	if a.maxDuration > 0 && a.duration >= a.maxDuration && len(a.Frames) > 0 {
+		return false
+	}
+
+	a.duration += delay
+	if a.seen%a.stride == 0 {
+		a.Frames = append(a.Frames, cloneRGBA(canvas))
+		a.Delays = append(a.Delays, delay)
+	} else {
+		a.Delays[len(a.Delays)-1] += delay //Skipped frames extend the previous frame
+	}
+	a.seen++
+
+	return true
+}
+
+func cloneRGBA(src *image.RGBA) *image.RGBA {
+	dst := image.NewRGBA(src.Bounds())
+	copy(dst.Pix, src.Pix)
+	return dst
+}
+
+// maxQuantizeSamples caps the number of pixels considered when building a palette
+const maxQuantizeSamples = 1 << 16
+
+type colorBox []color.RGBA
+
+// quantize builds an adaptive palette of at most n colours for img using median cut.
+// Mostly transparent pixels are mapped to a single reserved transparent entry.
+func quantize(img *image.RGBA, n int) color.Palette {
+	b := img.Bounds()
+	step := 1
+	if total := b.Dx() * b.Dy(); total > maxQuantizeSamples {
+		step = total / maxQuantizeSamples
+	}
+
+	var pal color.Palette
+	samples := make(colorBox, 0, b.Dx()*b.Dy()/step+1)
+	transparent := false
+	for i := 0; i+3 < len(img.Pix); i += 4 * step {
+		if img.Pix[i+3] < 0x80 {
+			transparent = true
+			continue
+		}
+		samples = append(samples, color.RGBA{R: img.Pix[i], G: img.Pix[i+1], B: img.Pix[i+2], A: 0xff})
+	}
+
+	if transparent {
+		pal = append(pal, color.RGBA{})
+		n--
+	}
+
+	if len(samples) == 0 {
+		if len(pal) == 0 {
+			pal = append(pal, color.RGBA{A: 0xff})
+		}
+		return pal
+	}
+
+	boxes := []colorBox{samples}
+	for len(boxes) < n {
+		//Split the box with the widest channel range at its median
+		idx, channel, widest := -1, 0, 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			if c, r := box.widestChannel(); r > widest {
+				idx, channel, widest = i, c, r
+			}
+		}
+
+		if idx < 0 {
+			break //Every box is a single colour
+		}
+
+		box := boxes[idx]
+		sort.Slice(box, func(i, j int) bool {
+			return box.channel(i, channel) < box.channel(j, channel)
+		})
+
+		mid := len(box) / 2
+		boxes[idx] = box[:mid]
+		boxes = append(boxes, box[mid:])
+	}
+
+	//Median splits can leave several boxes of the same colour
+	seen := make(map[color.RGBA]struct{}, len(boxes))
+	for _, box := range boxes {
+		c := box.average()
+		if _, ok := seen[c]; ok {
+			continue
+		}
+		seen[c] = struct{}{}
+		pal = append(pal, c)
+	}
+	return pal
+}
+
+func (box colorBox) channel(i, c int) uint8 {
+	switch c {
+	case 0:
+		return box[i].R
+	case 1:
+		return box[i].G
+	default:
+		return box[i].B
+	}
+}
+
+// widestChannel returns the channel with the largest value range & the size of that range
+func (box colorBox) widestChannel() (int, int) {
+	lo := [3]uint8{0xff, 0xff, 0xff}
+	hi := [3]uint8{}
+	for _, c := range box {
+		for ch, v := range [3]uint8{c.R, c.G, c.B} {
+			if v < lo[ch] {
+				lo[ch] = v
+			}
+			if v > hi[ch] {
+				hi[ch] = v
+			}
+		}
+	}
+
+	channel, width := 0, 0
+	for ch := range lo {
+		if w := int(hi[ch]) - int(lo[ch]); w > width {
+			channel, width = ch, w
+		}
+	}
+	return channel, width
+}
+
+func (box colorBox) average() color.RGBA {
+	var r, g, b int
+	for _, c := range box {
+		r += int(c.R)
+		g += int(c.G)
+		b += int(c.B)
+	}
+
+	n := len(box)
+	return color.RGBA{R: uint8(r / n), G: uint8(g / n), B: uint8(b / n), A: 0xff}
+}
@@ -0,0 +1,357 @@
+package services
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+)
+
+const pngHeader = "\x89PNG\r\n\x1a\n"
+
+// APNG dispose & blend operations (fcTL)
+const (
+	apngDisposeNone       = 0
+	apngDisposeBackground = 1
+	apngDisposePrevious   = 2
+
+	apngBlendSource = 0
+	apngBlendOver   = 1
+)
+
+var ErrInvalidAPNG = errors.New("invalid apng")
+
+type pngChunk struct {
+	Type string
+	Data []byte
+}
+
+// apngFrame is the fcTL control data & compressed image data for a single frame
+type apngFrame struct {
+	width, height    uint32
+	xOffset, yOffset uint32
+	delayNum         uint16
+	delayDen         uint16
+	disposeOp        uint8
+	blendOp          uint8
+	data             [][]byte
+}
+
+// isAPNG reports whether data is a PNG carrying an animation control (acTL) chunk
+func isAPNG(data []byte) bool {
+	if !bytes.HasPrefix(data, []byte(pngHeader)) {
+		return false
+	}
+
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		return false
+	}
+
+	for _, c := range chunks {
+		switch c.Type {
+		case "acTL":
+			return true
+		case "IDAT":
+			return false //acTL must appear before the first IDAT
+		}
+	}
+	return false
+}
+
+// apngFrameCount returns the number of frames declared by the acTL chunk
+func apngFrameCount(data []byte) (int, error) {
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, c := range chunks {
+		if c.Type == "acTL" && len(c.Data) == 8 {
+			return int(binary.BigEndian.Uint32(c.Data[0:4])), nil
+		}
+	}
+	return 0, ErrInvalidAPNG
+}
+
+func readPNGChunks(data []byte) ([]pngChunk, error) {
+	if !bytes.HasPrefix(data, []byte(pngHeader)) {
+		return nil, ErrInvalidAPNG
+	}
+
+	var chunks []pngChunk
+	data = data[len(pngHeader):]
+	for len(data) >= 12 {
+		size := binary.BigEndian.Uint32(data[:4])
+		if uint64(size) > uint64(len(data)-12) {
+			return nil, ErrInvalidAPNG
+		}
+
+		c := pngChunk{Type: string(data[4:8]), Data: data[8 : 8+size]}
+		chunks = append(chunks, c)
+		data = data[12+size:]
+
+		if c.Type == "IEND" {
+			break
+		}
+	}
+
+	return chunks, nil
+}
+
+func writePNGChunk(w io.Writer, typ string, data []byte) error {
+	var hdr [8]byte
+	binary.BigEndian.PutUint32(hdr[:4], uint32(len(data)))
+	copy(hdr[4:], typ)
+
+	crc := crc32.NewIEEE()
+	crc.Write(hdr[4:])
+	crc.Write(data)
+
+	var footer [4]byte
+	binary.BigEndian.PutUint32(footer[:], crc.Sum32())
+
+	for _, b := range [][]byte{hdr[:], data, footer[:]} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeAPNG decodes & composites every APNG frame onto a full size canvas
+func decodeAPNG(data []byte, limits animationLimits) (*animation, error) {
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var ihdr []byte
+	var shared []pngChunk //Chunks required to decode each frame (PLTE, tRNS, etc)
+	var frames []*apngFrame
+	var current *apngFrame
+	var loopCount int
+
+	for _, c := range chunks {
+		switch c.Type {
+		case "IHDR":
+			if len(c.Data) != 13 {
+				return nil, ErrInvalidAPNG
+			}
+			ihdr = c.Data
+		case "acTL":
+			if len(c.Data) != 8 {
+				return nil, ErrInvalidAPNG
+			}
+			loopCount = int(binary.BigEndian.Uint32(c.Data[4:8]))
+		case "fcTL":
+			if len(c.Data) != 26 {
+				return nil, ErrInvalidAPNG
+			}
+			current = &apngFrame{
+				width:     binary.BigEndian.Uint32(c.Data[4:8]),
+				height:    binary.BigEndian.Uint32(c.Data[8:12]),
+				xOffset:   binary.BigEndian.Uint32(c.Data[12:16]),
+				yOffset:   binary.BigEndian.Uint32(c.Data[16:20]),
+				delayNum:  binary.BigEndian.Uint16(c.Data[20:22]),
+				delayDen:  binary.BigEndian.Uint16(c.Data[22:24]),
+				disposeOp: c.Data[24],
+				blendOp:   c.Data[25],
+			}
+			frames = append(frames, current)
+		case "IDAT":
+			//The default image is only part of the animation when preceded by a fcTL
+			if current != nil {
+				current.data = append(current.data, c.Data)
+			}
+		case "fdAT":
+			if current == nil || len(c.Data) < 4 {
+				return nil, ErrInvalidAPNG
+			}
+			current.data = append(current.data, c.Data[4:])
+		case "IEND":
+		default:
+			shared = append(shared, c)
+		}
+	}
+
+	if ihdr == nil || len(frames) == 0 {
+		return nil, ErrInvalidAPNG
+	}
+
+	canvasRect := image.Rect(0, 0, int(binary.BigEndian.Uint32(ihdr[0:4])), int(binary.BigEndian.Uint32(ihdr[4:8])))
+	canvas := image.NewRGBA(canvasRect)
+
+	anim := newAnimation(len(frames), limits)
+	anim.LoopCount = loopCount
+
+	for i, f := range frames {
+		rect := image.Rect(int(f.xOffset), int(f.yOffset), int(f.xOffset+f.width), int(f.yOffset+f.height))
+		if rect.Empty() || !rect.In(canvasRect) || len(f.data) == 0 {
+			return nil, ErrInvalidAPNG
+		}
+
+		img, err := f.decode(ihdr, shared)
+		if err != nil {
+			return nil, err
+		}
+
+		disposeOp := f.disposeOp
+		if i == 0 && disposeOp == apngDisposePrevious {
+			disposeOp = apngDisposeBackground
+		}
+
+		var previous *image.RGBA
+		if disposeOp == apngDisposePrevious {
+			previous = cloneRGBA(canvas)
+		}
+
+		op := draw.Over
+		if f.blendOp == apngBlendSource {
+			op = draw.Src
+		}
+		draw.Draw(canvas, rect, img, img.Bounds().Min, op)
+
+		if !anim.addFrame(canvas, f.delayMs()) {
+			break
+		}
+
+		switch disposeOp {
+		case apngDisposeBackground:
+			draw.Draw(canvas, rect, image.Transparent, image.Point{}, draw.Src)
+		case apngDisposePrevious:
+			canvas = previous
+		}
+	}
+
+	return anim, nil
+}
+
+// decode rebuilds the frame as a standalone PNG so it can be read by image/png
+func (f *apngFrame) decode(ihdr []byte, shared []pngChunk) (image.Image, error) {
+	hdr := make([]byte, len(ihdr))
+	copy(hdr, ihdr)
+	binary.BigEndian.PutUint32(hdr[0:4], f.width)
+	binary.BigEndian.PutUint32(hdr[4:8], f.height)
+
+	var buf bytes.Buffer
+	buf.WriteString(pngHeader)
+	_ = writePNGChunk(&buf, "IHDR", hdr)
+	for _, c := range shared {
+		_ = writePNGChunk(&buf, c.Type, c.Data)
+	}
+	_ = writePNGChunk(&buf, "IDAT", bytes.Join(f.data, nil))
+	_ = writePNGChunk(&buf, "IEND", nil)
+
+	return png.Decode(&buf)
+}
+
+func (f *apngFrame) delayMs() int {
+	den := int(f.delayDen)
+	if den == 0 {
+		den = 100
+	}
+	return int(f.delayNum) * 1000 / den
+}
+
+// encodeAPNG writes every frame as a full canvas RGBA frame
+func encodeAPNG(w io.Writer, anim *animation) error {
+	if len(anim.Frames) == 0 {
+		return ErrInvalidAPNG
+	}
+
+	b := anim.Frames[0].Bounds()
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:4], uint32(b.Dx()))
+	binary.BigEndian.PutUint32(ihdr[4:8], uint32(b.Dy()))
+	ihdr[8] = 8 //Bit depth
+	ihdr[9] = 6 //Truecolour with alpha
+
+	actl := make([]byte, 8)
+	binary.BigEndian.PutUint32(actl[0:4], uint32(len(anim.Frames)))
+	binary.BigEndian.PutUint32(actl[4:8], uint32(anim.LoopCount))
+
+	if _, err := io.WriteString(w, pngHeader); err != nil {
+		return err
+	}
+	if err := writePNGChunk(w, "IHDR", ihdr); err != nil {
+		return err
+	}
+	if err := writePNGChunk(w, "acTL", actl); err != nil {
+		return err
+	}
+
+	var seq uint32
+	for i, frame := range anim.Frames {
+		delay := anim.Delays[i]
+		if delay > 0xffff {
+			delay = 0xffff
+		}
+
+		fctl := make([]byte, 26)
+		binary.BigEndian.PutUint32(fctl[0:4], seq)
+		binary.BigEndian.PutUint32(fctl[4:8], uint32(b.Dx()))
+		binary.BigEndian.PutUint32(fctl[8:12], uint32(b.Dy()))
+		binary.BigEndian.PutUint16(fctl[20:22], uint16(delay))
+		binary.BigEndian.PutUint16(fctl[22:24], 1000)
+		fctl[24] = apngDisposeNone
+		fctl[25] = apngBlendSource
+		seq++
+
+		if err := writePNGChunk(w, "fcTL", fctl); err != nil {
+			return err
+		}
+
+		data, err := compressRGBA(frame)
+		if err != nil {
+			return err
+		}
+
+		if i == 0 {
+			err = writePNGChunk(w, "IDAT", data)
+		} else {
+			fdat := make([]byte, 4, 4+len(data))
+			binary.BigEndian.PutUint32(fdat, seq)
+			err = writePNGChunk(w, "fdAT", append(fdat, data...))
+			seq++
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return writePNGChunk(w, "IEND", nil)
+}
+
+// compressRGBA returns the zlib compressed, unfiltered, non-premultiplied scanlines of img
+func compressRGBA(img *image.RGBA) ([]byte, error) {
+	b := img.Bounds()
+	nrgba := image.NewNRGBA(b)
+	draw.Draw(nrgba, b, img, b.Min, draw.Src)
+
+	var buf bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&buf, zlib.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+
+	for y := 0; y < b.Dy(); y++ {
+		row := nrgba.Pix[y*nrgba.Stride : y*nrgba.Stride+b.Dx()*4]
+		if _, err := zw.Write([]byte{0}); err != nil { //Filter: None
+			return nil, err
+		}
+		if _, err := zw.Write(row); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
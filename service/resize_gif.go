@@ -0,0 +1,117 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+)
+
+var ErrInvalidGif = errors.New("invalid gif")
+
+// decodeGif decodes & composites every gif frame onto a full size canvas, honouring frame offsets & disposal
+func decodeGif(data []byte, limits animationLimits) (*animation, error) {
+	im, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(im.Image) == 0 {
+		return nil, ErrInvalidGif
+	}
+
+	canvasRect := image.Rect(0, 0, im.Config.Width, im.Config.Height)
+	if canvasRect.Empty() {
+		canvasRect = im.Image[0].Bounds()
+	}
+	canvas := image.NewRGBA(canvasRect)
+
+	anim := newAnimation(len(im.Image), limits)
+
+	//gif LoopCount is the number of repeats, -1 plays once
+	switch {
+	case im.LoopCount == 0:
+		anim.LoopCount = 0
+	case im.LoopCount < 0:
+		anim.LoopCount = 1
+	default:
+		anim.LoopCount = im.LoopCount + 1
+	}
+
+	for i, frame := range im.Image {
+		rect := frame.Bounds().Intersect(canvasRect)
+
+		var disposal byte
+		if i < len(im.Disposal) {
+			disposal = im.Disposal[i]
+		}
+
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = cloneRGBA(canvas)
+		}
+
+		draw.Draw(canvas, rect, frame, rect.Min, draw.Over)
+
+		if !anim.addFrame(canvas, im.Delay[i]*10) {
+			break
+		}
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, rect, image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+
+	return anim, nil
+}
+
+// gifFrameCount counts the image descriptors in a gif without decoding any frame data
+func gifFrameCount(data []byte) (int, error) {
+	if len(data) < 13 || (string(data[:6]) != "GIF87a" && string(data[:6]) != "GIF89a") {
+		return 0, ErrInvalidGif
+	}
+
+	pos := 13
+	if data[10]&0x80 != 0 {
+		pos += 3 << ((data[10] & 0x07) + 1) //Global colour table
+	}
+
+	frames := 0
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21: //Extension
+			pos += 2
+		case 0x2c: //Image descriptor
+			if pos+10 > len(data) {
+				return 0, ErrInvalidGif
+			}
+			if data[pos+9]&0x80 != 0 {
+				pos += 3 << ((data[pos+9] & 0x07) + 1) //Local colour table
+			}
+			pos += 11 //Descriptor & LZW minimum code size
+			frames++
+		case 0x3b: //Trailer
+			return frames, nil
+		default:
+			return 0, ErrInvalidGif
+		}
+
+		//Skip data sub-blocks
+		for {
+			if pos >= len(data) {
+				return 0, ErrInvalidGif
+			}
+			size := int(data[pos])
+			pos += size + 1
+			if size == 0 {
+				break
+			}
+		}
+	}
+
+	return frames, nil
+}
@@ -0,0 +1,390 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"os"
+	"testing"
+	"time"
+)
+
+var (
+	fixtureRed  = color.RGBA{R: 255, A: 255}
+	fixtureBlue = color.RGBA{B: 255, A: 255}
+)
+
+func newTestResizeService(workers, queueDepth int, timeout time.Duration) *ResizeService {
+	svc := ResizeService{
+		workers:    workers,
+		queueDepth: queueDepth,
+		jobTimeout: timeout,
+	}
+	svc.startWorkers()
+	return &svc
+}
+
+// Both animated fixtures are an 8x6 canvas with a full red frame (100ms) followed by a 4x2 blue frame at (2,2) (200ms)
+func assertFixtureFrames(t *testing.T, anim *animation) {
+	t.Helper()
+
+	if len(anim.Frames) != 2 {
+		t.Fatalf("Expected 2 frames, got %v", len(anim.Frames))
+	}
+
+	for i, delay := range []int{100, 200} {
+		if anim.Delays[i] != delay {
+			t.Fatalf("Frame %v: expected delay %vms, got %vms", i, delay, anim.Delays[i])
+		}
+		if b := anim.Frames[i].Bounds(); b != image.Rect(0, 0, 8, 6) {
+			t.Fatalf("Frame %v: expected full canvas bounds, got %v", i, b)
+		}
+	}
+
+	checks := []struct {
+		frame int
+		x, y  int
+		want  color.RGBA
+	}{
+		{0, 0, 0, fixtureRed},
+		{0, 3, 3, fixtureRed},
+		{1, 0, 0, fixtureRed},
+		{1, 3, 3, fixtureBlue},
+		{1, 7, 5, fixtureRed},
+	}
+	for _, c := range checks {
+		if got := anim.Frames[c.frame].RGBAAt(c.x, c.y); got != c.want {
+			t.Fatalf("Frame %v (%v,%v): expected %v, got %v", c.frame, c.x, c.y, c.want, got)
+		}
+	}
+}
+
+func TestResizeService_AnimatedWebP(t *testing.T) {
+	data, err := os.ReadFile("./testdata/animated.webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc := newTestResizeService(1, 1, 0)
+
+	t.Run("Decode", func(t *testing.T) {
+		if !isAnimatedWebP(data) {
+			t.Fatal("Expected fixture to be detected as animated webp")
+		}
+
+		anim, err := decodeAnimatedWebP(data, animationLimits{})
+		if err != nil {
+			t.Fatalf("Failed to decode animated webp: %v", err)
+		}
+		assertFixtureFrames(t, anim)
+	})
+
+	t.Run("Resize", func(t *testing.T) {
+		var out bytes.Buffer
+		err := svc.Resize(data, &out, 6)
+		if err != nil {
+			t.Fatalf("Failed to resize animated webp: %v", err)
+		}
+
+		//Without a WebP encoder animations are kept as APNG
+		anim, err := decodeAPNG(out.Bytes(), animationLimits{})
+		if err != nil {
+			t.Fatalf("Expected apng output: %v", err)
+		}
+
+		if len(anim.Frames) != 2 {
+			t.Fatalf("Expected 2 frames, got %v", len(anim.Frames))
+		}
+		if anim.Delays[0] != 100 || anim.Delays[1] != 200 {
+			t.Fatalf("Expected delays [100 200], got %v", anim.Delays)
+		}
+		if b := anim.Frames[0].Bounds(); b.Dx() != 4 || b.Dy() != 3 {
+			t.Fatalf("Expected 4x3 output, got %v", b)
+		}
+	})
+
+	t.Run("Not Animated", func(t *testing.T) {
+		if isAnimatedWebP([]byte("RIFF\x04\x00\x00\x00WEBP")) {
+			t.Fatal("Expected empty webp to not be detected as animated")
+		}
+	})
+}
+
+func TestResizeService_APNG(t *testing.T) {
+	data, err := os.ReadFile("./testdata/animated.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc := newTestResizeService(1, 1, 0)
+
+	t.Run("Decode", func(t *testing.T) {
+		if !isAPNG(data) {
+			t.Fatal("Expected fixture to be detected as apng")
+		}
+
+		anim, err := decodeAPNG(data, animationLimits{})
+		if err != nil {
+			t.Fatalf("Failed to decode apng: %v", err)
+		}
+		assertFixtureFrames(t, anim)
+	})
+
+	t.Run("Resize", func(t *testing.T) {
+		var out bytes.Buffer
+		err := svc.Resize(data, &out, 6)
+		if err != nil {
+			t.Fatalf("Failed to resize apng: %v", err)
+		}
+
+		if !isAPNG(out.Bytes()) {
+			t.Fatal("Expected apng output")
+		}
+
+		anim, err := decodeAPNG(out.Bytes(), animationLimits{})
+		if err != nil {
+			t.Fatalf("Failed to decode resized apng: %v", err)
+		}
+
+		if len(anim.Frames) != 2 {
+			t.Fatalf("Expected 2 frames, got %v", len(anim.Frames))
+		}
+		if anim.Delays[0] != 100 || anim.Delays[1] != 200 {
+			t.Fatalf("Expected delays [100 200], got %v", anim.Delays)
+		}
+		if b := anim.Frames[0].Bounds(); b.Dx() != 4 || b.Dy() != 3 {
+			t.Fatalf("Expected 4x3 output, got %v", b)
+		}
+	})
+
+	t.Run("Static PNG", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 2, 2)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if isAPNG(buf.Bytes()) {
+			t.Fatal("Expected static png to not be detected as apng")
+		}
+	})
+}
+
+// testGif builds a wide 8x4 gif: a red background, a blue 2x2 frame at (2,1) disposed to background,
+// then a green 2x2 frame at (6,2) which should not contain the blue square
+func testGif(t *testing.T, frames int) []byte {
+	t.Helper()
+
+	pal := color.Palette{color.RGBA{}, fixtureRed, fixtureBlue, color.RGBA{G: 255, A: 255}}
+	fill := func(r image.Rectangle, idx uint8) *image.Paletted {
+		p := image.NewPaletted(r, pal)
+		for i := range p.Pix {
+			p.Pix[i] = idx
+		}
+		return p
+	}
+
+	g := gif.GIF{Config: image.Config{Width: 8, Height: 4, ColorModel: pal}}
+	g.Image = append(g.Image, fill(image.Rect(0, 0, 8, 4), 1), fill(image.Rect(2, 1, 4, 3), 2), fill(image.Rect(6, 2, 8, 4), 3))
+	g.Delay = append(g.Delay, 10, 10, 10)
+	g.Disposal = append(g.Disposal, gif.DisposalNone, gif.DisposalBackground, gif.DisposalNone)
+
+	for len(g.Image) < frames {
+		g.Image = append(g.Image, fill(image.Rect(0, 0, 8, 4), 1))
+		g.Delay = append(g.Delay, 10)
+		g.Disposal = append(g.Disposal, gif.DisposalNone)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, &g); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestResizeService_Gif(t *testing.T) {
+	svc := newTestResizeService(1, 1, 0)
+
+	t.Run("Disposal", func(t *testing.T) {
+		anim, err := decodeGif(testGif(t, 3), animationLimits{})
+		if err != nil {
+			t.Fatalf("Failed to decode gif: %v", err)
+		}
+
+		if len(anim.Frames) != 3 {
+			t.Fatalf("Expected 3 frames, got %v", len(anim.Frames))
+		}
+		if got := anim.Frames[1].RGBAAt(2, 1); got != fixtureBlue {
+			t.Fatalf("Expected blue frame to be composited, got %v", got)
+		}
+		if got := anim.Frames[2].RGBAAt(2, 1); got.A != 0 {
+			t.Fatalf("Expected blue frame to be disposed to background, got %v", got)
+		}
+		if got := anim.Frames[2].RGBAAt(7, 3); got != (color.RGBA{G: 255, A: 255}) {
+			t.Fatalf("Expected green frame at its offset, got %v", got)
+		}
+	})
+
+	t.Run("Aspect Ratio", func(t *testing.T) {
+		var out bytes.Buffer
+		err := svc.Resize(testGif(t, 3), &out, 4)
+		if err != nil {
+			t.Fatalf("Failed to resize gif: %v", err)
+		}
+
+		g, err := gif.DecodeAll(&out)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if g.Config.Width != 4 || g.Config.Height != 2 {
+			t.Fatalf("Expected 4x2 output, got %vx%v", g.Config.Width, g.Config.Height)
+		}
+	})
+
+	t.Run("Frame Limit", func(t *testing.T) {
+		anim, err := decodeGif(testGif(t, 10), animationLimits{MaxFrames: 5})
+		if err != nil {
+			t.Fatalf("Failed to decode gif: %v", err)
+		}
+
+		if len(anim.Frames) != 5 {
+			t.Fatalf("Expected 5 frames, got %v", len(anim.Frames))
+		}
+		for i, d := range anim.Delays {
+			if d != 200 {
+				t.Fatalf("Frame %v: expected skipped frame delays to be merged (200ms), got %vms", i, d)
+			}
+		}
+	})
+
+	t.Run("Duration Limit", func(t *testing.T) {
+		anim, err := decodeGif(testGif(t, 10), animationLimits{MaxDuration: 300 * time.Millisecond})
+		if err != nil {
+			t.Fatalf("Failed to decode gif: %v", err)
+		}
+
+		if len(anim.Frames) != 3 {
+			t.Fatalf("Expected 3 frames, got %v", len(anim.Frames))
+		}
+	})
+}
+
+func TestQuantize(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for i, c := range []color.RGBA{fixtureRed, fixtureBlue, {G: 255, A: 255}, {}} {
+		for x := 0; x < 4; x++ {
+			img.SetRGBA(x, i, c)
+		}
+	}
+
+	pal := quantize(img, 256)
+	if len(pal) != 4 {
+		t.Fatalf("Expected 4 colours (3 + transparent), got %v", len(pal))
+	}
+
+	for _, want := range []color.Color{fixtureRed, fixtureBlue, color.RGBA{G: 255, A: 255}, color.RGBA{}} {
+		if pal[pal.Index(want)] != want {
+			t.Fatalf("Expected palette to contain %v, got %v", want, pal)
+		}
+	}
+
+	if len(quantize(img, 2)) != 2 {
+		t.Fatal("Expected palette to be capped at 2 colours")
+	}
+}
+
+func TestResizeService_Limits(t *testing.T) {
+	webpData, err := os.ReadFile("./testdata/animated.webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//Only the IHDR is needed to reject on dimensions
+	var huge bytes.Buffer
+	huge.WriteString(pngHeader)
+	ihdr := []byte{0, 0, 0x27, 0x10, 0, 0, 0x27, 0x10, 8, 6, 0, 0, 0}
+	if err := writePNGChunk(&huge, "IHDR", ihdr); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		data   []byte
+		limits decodeLimits
+	}{
+		{"Bytes", webpData, decodeLimits{MaxBytes: 64}},
+		{"Pixels", huge.Bytes(), decodeLimits{MaxPixels: 40_000_000}},
+		{"WebP Pixels", webpData, decodeLimits{MaxPixels: 40}},
+		{"Gif Frames", testGif(t, 10), decodeLimits{MaxFrames: 5}},
+		{"WebP Frames", webpData, decodeLimits{MaxFrames: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := newTestResizeService(1, 1, 0)
+			svc.decodeLimits = tt.limits
+
+			err := svc.Resize(tt.data, &bytes.Buffer{}, 6)
+			if !errors.Is(err, ErrImageTooLarge) {
+				t.Fatalf("Expected ErrImageTooLarge, got %v", err)
+			}
+		})
+	}
+
+	t.Run("Gif Frame Count", func(t *testing.T) {
+		frames, err := gifFrameCount(testGif(t, 12))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if frames != 12 {
+			t.Fatalf("Expected 12 frames, got %v", frames)
+		}
+	})
+}
+
+func TestResizeService_Queue(t *testing.T) {
+	data := testGif(t, 3)
+
+	t.Run("Queue Full", func(t *testing.T) {
+		svc := newTestResizeService(0, 0, 0)
+
+		err := svc.Resize(data, &bytes.Buffer{}, 4)
+		if !errors.Is(err, ErrResizeQueueFull) {
+			t.Fatalf("Expected ErrResizeQueueFull, got %v", err)
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		svc := newTestResizeService(0, 1, 10*time.Millisecond)
+
+		var out bytes.Buffer
+		err := svc.Resize(data, &out, 4)
+		if !errors.Is(err, ErrResizeTimeout) {
+			t.Fatalf("Expected ErrResizeTimeout, got %v", err)
+		}
+		if out.Len() != 0 {
+			t.Fatal("Expected no output to be written on timeout")
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		svc := newTestResizeService(2, 8, time.Second)
+
+		errs := make(chan error, 8)
+		for i := 0; i < 8; i++ {
+			go func() {
+				errs <- svc.Resize(data, &bytes.Buffer{}, 4)
+			}()
+		}
+
+		for i := 0; i < 8; i++ {
+			if err := <-errs; err != nil {
+				t.Fatalf("Expected queued resize to succeed, got %v", err)
+			}
+		}
+	})
+}
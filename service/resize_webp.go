@@ -0,0 +1,218 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+
+	"golang.org/x/image/webp"
+)
+
+// VP8X feature flags
+const (
+	webpFlagAnimation = 0x02
+	webpFlagAlpha     = 0x10
+)
+
+// ANMF frame flags
+const (
+	webpDisposeBackground = 0x01
+	webpNoBlend           = 0x02
+)
+
+var ErrInvalidWebP = errors.New("invalid webp")
+
+type riffChunk struct {
+	FourCC string
+	Data   []byte
+}
+
+// isAnimatedWebP reports whether data is an extended WebP with the animation flag set
+func isAnimatedWebP(data []byte) bool {
+	chunks, err := readWebPChunks(data)
+	if err != nil || len(chunks) == 0 {
+		return false
+	}
+
+	return chunks[0].FourCC == "VP8X" && len(chunks[0].Data) >= 10 && chunks[0].Data[0]&webpFlagAnimation != 0
+}
+
+func readWebPChunks(data []byte) ([]riffChunk, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, ErrInvalidWebP
+	}
+
+	return readRIFFChunks(data[12:])
+}
+
+func readRIFFChunks(data []byte) ([]riffChunk, error) {
+	var chunks []riffChunk
+	for len(data) >= 8 {
+		size := binary.LittleEndian.Uint32(data[4:8])
+		if uint64(size) > uint64(len(data)-8) {
+			return nil, ErrInvalidWebP
+		}
+
+		chunks = append(chunks, riffChunk{FourCC: string(data[0:4]), Data: data[8 : 8+size]})
+
+		next := 8 + int(size) + int(size&1) //Chunks are padded to an even size
+		if next > len(data) {
+			break
+		}
+		data = data[next:]
+	}
+	return chunks, nil
+}
+
+func writeRIFFChunk(buf *bytes.Buffer, fourCC string, data []byte) {
+	var hdr [8]byte
+	copy(hdr[:4], fourCC)
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(len(data)))
+	buf.Write(hdr[:])
+	buf.Write(data)
+	if len(data)&1 == 1 {
+		buf.WriteByte(0)
+	}
+}
+
+func readUint24(b []byte) int {
+	return int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+}
+
+func putUint24(b []byte, v int) {
+	b[0] = byte(v)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v >> 16)
+}
+
+// webpCanvas returns the canvas size & number of frames of an animated WebP without decoding any frames
+func webpCanvas(data []byte) (image.Rectangle, int, error) {
+	chunks, err := readWebPChunks(data)
+	if err != nil {
+		return image.Rectangle{}, 0, err
+	}
+
+	return webpHeader(chunks)
+}
+
+func webpHeader(chunks []riffChunk) (image.Rectangle, int, error) {
+	if len(chunks) == 0 || chunks[0].FourCC != "VP8X" || len(chunks[0].Data) < 10 {
+		return image.Rectangle{}, 0, ErrInvalidWebP
+	}
+
+	frameCount := 0
+	for _, c := range chunks {
+		if c.FourCC == "ANMF" {
+			frameCount++
+		}
+	}
+
+	vp8x := chunks[0].Data
+	return image.Rect(0, 0, readUint24(vp8x[4:7])+1, readUint24(vp8x[7:10])+1), frameCount, nil
+}
+
+// decodeAnimatedWebP decodes & composites every ANMF frame onto a full size canvas
+func decodeAnimatedWebP(data []byte, limits animationLimits) (*animation, error) {
+	chunks, err := readWebPChunks(data)
+	if err != nil {
+		return nil, err
+	}
+
+	canvasRect, frameCount, err := webpHeader(chunks)
+	if err != nil {
+		return nil, err
+	}
+
+	canvas := image.NewRGBA(canvasRect)
+	anim := newAnimation(frameCount, limits)
+
+	for _, c := range chunks[1:] {
+		switch c.FourCC {
+		case "ANIM":
+			if len(c.Data) < 6 {
+				return nil, ErrInvalidWebP
+			}
+			anim.LoopCount = int(binary.LittleEndian.Uint16(c.Data[4:6]))
+		case "ANMF":
+			if len(c.Data) < 16 {
+				return nil, ErrInvalidWebP
+			}
+
+			x, y := readUint24(c.Data[0:3])*2, readUint24(c.Data[3:6])*2
+			w, h := readUint24(c.Data[6:9])+1, readUint24(c.Data[9:12])+1
+			duration := readUint24(c.Data[12:15])
+			flags := c.Data[15]
+
+			rect := image.Rect(x, y, x+w, y+h)
+			if !rect.In(canvasRect) {
+				return nil, ErrInvalidWebP
+			}
+
+			img, err := decodeWebPFrame(c.Data[16:], w, h)
+			if err != nil {
+				return nil, err
+			}
+
+			op := draw.Over
+			if flags&webpNoBlend != 0 {
+				op = draw.Src
+			}
+			draw.Draw(canvas, rect, img, img.Bounds().Min, op)
+
+			if !anim.addFrame(canvas, duration) {
+				return anim, nil
+			}
+
+			if flags&webpDisposeBackground != 0 {
+				draw.Draw(canvas, rect, image.Transparent, image.Point{}, draw.Src)
+			}
+		}
+	}
+
+	if len(anim.Frames) == 0 {
+		return nil, ErrInvalidWebP
+	}
+
+	return anim, nil
+}
+
+// decodeWebPFrame wraps the ANMF frame bitstream in a standalone WebP container so it can be read by x/image/webp
+func decodeWebPFrame(data []byte, width, height int) (image.Image, error) {
+	chunks, err := readRIFFChunks(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var alph, bitstream *riffChunk
+	for i := range chunks {
+		switch chunks[i].FourCC {
+		case "ALPH":
+			alph = &chunks[i]
+		case "VP8 ", "VP8L":
+			bitstream = &chunks[i]
+		}
+	}
+
+	if bitstream == nil {
+		return nil, ErrInvalidWebP
+	}
+
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	if alph != nil && bitstream.FourCC == "VP8 " {
+		vp8x := make([]byte, 10)
+		vp8x[0] = webpFlagAlpha
+		putUint24(vp8x[4:7], width-1)
+		putUint24(vp8x[7:10], height-1)
+		writeRIFFChunk(&body, "VP8X", vp8x)
+		writeRIFFChunk(&body, alph.FourCC, alph.Data)
+	}
+	writeRIFFChunk(&body, bitstream.FourCC, bitstream.Data)
+
+	var file bytes.Buffer
+	writeRIFFChunk(&file, "RIFF", body.Bytes())
+
+	return webp.Decode(&file)
+}
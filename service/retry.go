@@ -0,0 +1,207 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/alphabatem/nft-proxy/config"
+)
+
+// retryPolicy describes how requests to a dependency are retried, using exponential backoff with full jitter
+type retryPolicy struct {
+	Name        string //Dependency name, used for stats
+	MaxAttempts int    //Total attempts including the first, 1 disables retries
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration //Also the longest Retry-After we are willing to wait
+	RetryPost   bool          //POSTs are only retried for dependencies where they are known to be idempotent (JSON-RPC reads)
+}
+
+var (
+	rpcRetryPolicy    = retryPolicy{Name: "rpc", RetryPost: true}
+	originRetryPolicy = retryPolicy{Name: "origin"}
+)
+
+// retryPolicyFrom applies the configured attempts & delays to base
+func retryPolicyFrom(base retryPolicy, r config.Retry) retryPolicy {
+	p := base
+	p.MaxAttempts = r.Attempts
+	p.BaseDelay = r.BaseDelay
+	p.MaxDelay = r.MaxDelay
+	return p
+}
+
+// backoff returns a random delay up to BaseDelay * 2^attempt, capped at MaxDelay
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	ceiling := p.MaxDelay
+	if attempt < 30 {
+		if d := p.BaseDelay << attempt; d > 0 && d < ceiling {
+			ceiling = d
+		}
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// retryTransport retries transient failures of idempotent requests
+type retryTransport struct {
+	base   http.RoundTripper
+	policy retryPolicy
+	stats  *StatService
+}
+
+// withRetries wraps the transport of client with policy
+func withRetries(client *http.Client, policy retryPolicy, stats *StatService) *http.Client {
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	client.Transport = &retryTransport{base: base, policy: policy, stats: stats}
+	return client
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.idempotent(req) {
+		return t.base.RoundTrip(req)
+	}
+
+	attemptReq := req
+	for attempt := 0; ; attempt++ {
+		resp, err := t.base.RoundTrip(attemptReq)
+
+		wait, retry := t.shouldRetry(resp, err, attempt)
+		if !retry {
+			if attempt > 0 && (err != nil || retryableStatus(resp.StatusCode)) {
+				t.stats.IncrementRetriesExhausted(t.policy.Name)
+			}
+			return resp, err
+		}
+
+		//RoundTrippers must not modify the caller's request, each retry sends a copy with a fresh body
+		attemptReq = req.Clone(req.Context())
+		if req.GetBody != nil {
+			body, bErr := req.GetBody()
+			if bErr != nil {
+				return resp, err
+			}
+			attemptReq.Body = body
+		}
+
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096)) //Allow the connection to be reused
+			resp.Body.Close()
+		}
+
+		t.stats.IncrementRetries(t.policy.Name)
+		log.Printf("Retrying %s %s in %s (attempt %v): %s", t.policy.Name, req.URL.Host, wait, attempt+1, retryReason(resp, err))
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// idempotent reports whether req can safely be sent again
+func (t *retryTransport) idempotent(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false //Body can't be replayed
+	}
+
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	case http.MethodPost:
+		return t.policy.RetryPost
+	}
+	return false
+}
+
+// shouldRetry returns how long to wait before retrying, honouring Retry-After on 429 & 503 responses
+func (t *retryTransport) shouldRetry(resp *http.Response, err error, attempt int) (time.Duration, bool) {
+	if attempt+1 >= t.policy.MaxAttempts {
+		return 0, false
+	}
+
+	if err != nil {
+		return t.policy.backoff(attempt), transientError(err)
+	}
+
+	if !retryableStatus(resp.StatusCode) {
+		return 0, false
+	}
+
+	if wait, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+		if wait > t.policy.MaxDelay {
+			return 0, false //Not worth holding the request open, surface the error
+		}
+		return wait, true
+	}
+	return t.policy.backoff(attempt), true
+}
+
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// transientError reports whether err is a network failure likely to succeed on retry
+func transientError(err error) bool {
+	if errors.Is(err, ErrBlockedDestination) {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF)
+}
+
+// retryAfter parses a Retry-After header given in seconds or as an HTTP date
+func retryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if at, err := http.ParseTime(v); err == nil {
+		wait := time.Until(at)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return 0, false
+}
+
+func retryReason(resp *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return resp.Status
+}
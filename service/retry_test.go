@@ -0,0 +1,179 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryTransport(t *testing.T) {
+	policy := retryPolicy{Name: "test", MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		switch r.URL.Path {
+		case "/flaky":
+			if n < 3 {
+				w.WriteHeader(http.StatusGatewayTimeout)
+				return
+			}
+		case "/down":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case "/rate-limited":
+			if n < 2 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+		case "/rate-limited-long":
+			w.Header().Set("Retry-After", "120")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	stats := &StatService{}
+	client := withRetries(&http.Client{}, policy, stats)
+
+	get := func(t *testing.T, path string) (int, int32) {
+		t.Helper()
+		atomic.StoreInt32(&calls, 0)
+
+		resp, err := client.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode, atomic.LoadInt32(&calls)
+	}
+
+	t.Run("Transient", func(t *testing.T) {
+		status, calls := get(t, "/flaky")
+		if status != http.StatusOK || calls != 3 {
+			t.Fatalf("Expected success after 3 attempts, got %v after %v", status, calls)
+		}
+	})
+
+	t.Run("Exhausted", func(t *testing.T) {
+		status, calls := get(t, "/down")
+		if status != http.StatusServiceUnavailable || calls != 3 {
+			t.Fatalf("Expected 503 after 3 attempts, got %v after %v", status, calls)
+		}
+		if stats.retries["test"].Exhausted != 1 {
+			t.Fatalf("Expected exhausted retry to be counted, got %+v", stats.retries["test"])
+		}
+	})
+
+	t.Run("Permanent", func(t *testing.T) {
+		status, calls := get(t, "/missing")
+		if status != http.StatusNotFound || calls != 1 {
+			t.Fatalf("Expected 404 without retrying, got %v after %v", status, calls)
+		}
+	})
+
+	t.Run("Retry-After", func(t *testing.T) {
+		status, calls := get(t, "/rate-limited")
+		if status != http.StatusOK || calls != 2 {
+			t.Fatalf("Expected success after honouring Retry-After, got %v after %v", status, calls)
+		}
+
+		status, calls = get(t, "/rate-limited-long")
+		if status != http.StatusTooManyRequests || calls != 1 {
+			t.Fatalf("Expected Retry-After beyond MaxDelay to give up, got %v after %v", status, calls)
+		}
+	})
+
+	t.Run("Non Idempotent", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+		resp, err := client.Post(srv.URL+"/down", "application/json", strings.NewReader("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if calls != 1 {
+			t.Fatalf("Expected POST not to be retried, got %v attempts", calls)
+		}
+
+		//JSON-RPC reads are idempotent so their dependency opts in
+		rpcPolicy := policy
+		rpcPolicy.RetryPost = true
+		rpcClient := withRetries(&http.Client{}, rpcPolicy, nil)
+
+		atomic.StoreInt32(&calls, 0)
+		resp, err = rpcClient.Post(srv.URL+"/flaky", "application/json", strings.NewReader("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK || calls != 3 {
+			t.Fatalf("Expected POST body to be replayed, got %v after %v", resp.StatusCode, calls)
+		}
+
+		//Retries send copies, the caller's request is left as it was passed in
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/flaky", strings.NewReader("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := req.Body
+
+		atomic.StoreInt32(&calls, 0)
+		resp, err = rpcClient.Transport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if calls != 3 || req.Body != body {
+			t.Fatalf("Expected the request body to be unchanged after %v attempts", calls)
+		}
+	})
+
+	t.Run("Stats", func(t *testing.T) {
+		if stats.retries["test"].Retries == 0 {
+			t.Fatal("Expected retries to be counted")
+		}
+	})
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := retryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	for attempt := 0; attempt < 64; attempt++ {
+		ceiling := p.BaseDelay << attempt
+		if attempt >= 30 || ceiling > p.MaxDelay {
+			ceiling = p.MaxDelay
+		}
+
+		for i := 0; i < 20; i++ {
+			if d := p.backoff(attempt); d < 0 || d > ceiling {
+				t.Fatalf("Attempt %v: backoff %s outside [0, %s]", attempt, d, ceiling)
+			}
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	if d, ok := retryAfter("3"); !ok || d != 3*time.Second {
+		t.Fatalf("Expected 3s, got %s", d)
+	}
+
+	if d, ok := retryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)); !ok || d < 59*time.Minute {
+		t.Fatalf("Expected ~1h from http date, got %s", d)
+	}
+
+	if _, ok := retryAfter("soon"); ok {
+		t.Fatal("Expected invalid Retry-After to be ignored")
+	}
+}
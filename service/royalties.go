@@ -0,0 +1,164 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	nft_proxy "github.com/alphabatem/nft-proxy"
+	"github.com/alphabatem/nft-proxy/config"
+	"github.com/alphabatem/nft-proxy/metaplex_core"
+	token_metadata "github.com/alphabatem/nft-proxy/token-metadata"
+	"github.com/babilu-online/common/context"
+	"github.com/gagliardetto/solana-go"
+	"gorm.io/gorm/clause"
+)
+
+// RoyaltyService reads & caches the royalties of a mint from its on-chain metadata
+type RoyaltyService struct {
+	context.DefaultService
+	Config *config.Config
+
+	sql *SqliteService
+	sol *SolanaService
+}
+
+const ROYALTY_SVC = "royalty_svc"
+
+var ErrInvalidRoyalties = errors.New("invalid royalties")
+
+func (svc *RoyaltyService) Id() string {
+	return ROYALTY_SVC
+}
+
+func (svc *RoyaltyService) Start() error {
+	if svc.Config == nil {
+		return config.ErrMissingConfig
+	}
+
+	svc.sql = svc.DefaultService(SQLITE_SVC).(*SqliteService)
+	svc.sol = svc.DefaultService(SOLANA_SVC).(*SolanaService)
+	return nil
+}
+
+// Royalties returns the seller fee, creators & transfer rules of key
+func (svc *RoyaltyService) Royalties(key string, skipCache bool) (*nft_proxy.SolanaRoyalties, error) {
+	var cached nft_proxy.SolanaRoyalties
+	err := svc.sql.Db().First(&cached, "mint = ?", key).Error
+	if err == nil && !skipCache {
+		return &cached, nil
+	}
+
+	pk, err := solana.PublicKeyFromBase58(key)
+	if err != nil {
+		return nil, err
+	}
+	meta, _, slot, err := svc.sol.TokenDataSlot(pk)
+	if err != nil {
+		return nil, err
+	}
+
+	royalties, err := svc.royalties(key, meta)
+	if err != nil {
+		return nil, err
+	}
+	royalties.Slot = slot
+
+	err = validateRoyalties(royalties)
+	if err != nil {
+		return nil, err
+	}
+	return svc.cache(royalties)
+}
+
+// royalties maps the on-chain metadata of key, reading the collection of core assets without their own royalties
+func (svc *RoyaltyService) royalties(key string, meta *token_metadata.Metadata) (*nft_proxy.SolanaRoyalties, error) {
+	res := nft_proxy.SolanaRoyalties{Mint: key, Creators: []nft_proxy.RoyaltyCreator{}}
+
+	if meta.Protocol != token_metadata.ProtocolMetaplexCore {
+		res.SellerFeeBasisPoints = uint16(meta.Data.SellerFeeBasisPoints)
+		for _, c := range meta.Data.Creators {
+			res.Creators = append(res.Creators, nft_proxy.RoyaltyCreator{Address: c.Address.String(), Verified: c.Verified, Share: c.Share})
+		}
+		if meta.ProgrammableConfig != nil {
+			res.ProgrammableRuleSet = pkString(meta.ProgrammableConfig.RuleSet)
+		}
+		return &res, nil
+	}
+
+	royalties := meta.CoreRoyalties
+	if royalties == nil && meta.Collection != nil {
+		var err error
+		royalties, err = svc.sol.CoreRoyalties(meta.Collection.Key)
+		if err != nil {
+			return nil, err
+		}
+		if royalties != nil {
+			res.Collection = meta.Collection.Key.String()
+		}
+	}
+	if royalties == nil {
+		return &res, nil
+	}
+
+	res.SellerFeeBasisPoints = royalties.BasisPoints
+	for _, c := range royalties.Creators {
+		res.Creators = append(res.Creators, nft_proxy.RoyaltyCreator{Address: c.Address.String(), Share: c.Percentage})
+	}
+
+	switch royalties.RuleSet.Type {
+	case metaplex_core.RuleSetProgramAllowList:
+		res.RuleSet = &nft_proxy.RoyaltyRuleSet{Type: nft_proxy.RuleSetAllowList}
+	case metaplex_core.RuleSetProgramDenyList:
+		res.RuleSet = &nft_proxy.RoyaltyRuleSet{Type: nft_proxy.RuleSetDenyList}
+	}
+	if res.RuleSet != nil {
+		res.RuleSet.Programs = make([]string, len(royalties.RuleSet.Programs))
+		for i, p := range royalties.RuleSet.Programs {
+			res.RuleSet.Programs[i] = p.String()
+		}
+	}
+	return &res, nil
+}
+
+// validateRoyalties rejects fees above 100% & creator shares that do not add up to 100%
+func validateRoyalties(r *nft_proxy.SolanaRoyalties) error {
+	if !token_metadata.SellerFeeBasisPoints(r.SellerFeeBasisPoints).Valid() {
+		return fmt.Errorf("%w: seller fee of %d basis points", ErrInvalidRoyalties, r.SellerFeeBasisPoints)
+	}
+	if len(r.Creators) == 0 {
+		return nil
+	}
+
+	var total int
+	for _, c := range r.Creators {
+		total += int(c.Share)
+	}
+	if total != 100 {
+		return fmt.Errorf("%w: creator shares add up to %d", ErrInvalidRoyalties, total)
+	}
+	return nil
+}
+
+// cache upserts royalties, keeping the stored row if it was read at a later slot
+func (svc *RoyaltyService) cache(royalties *nft_proxy.SolanaRoyalties) (*nft_proxy.SolanaRoyalties, error) {
+	res := svc.sql.Db().Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "mint"}},
+		DoUpdates: clause.AssignmentColumns([]string{"seller_fee_basis_points", "creators", "rule_set", "programmable_rule_set", "collection", "slot", "updated_at"}),
+		Where: clause.Where{Exprs: []clause.Expression{
+			clause.Expr{SQL: "excluded.slot >= solana_royalties.slot"},
+		}},
+	}).Create(royalties)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		var current nft_proxy.SolanaRoyalties
+		err := svc.sql.Db().First(&current, "mint = ?", royalties.Mint).Error
+		if err != nil {
+			return nil, err
+		}
+		return &current, nil
+	}
+	return royalties, nil
+}
@@ -0,0 +1,243 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	nft_proxy "github.com/alphabatem/nft-proxy"
+	token_metadata "github.com/alphabatem/nft-proxy/token-metadata"
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+)
+
+type testCreator struct {
+	address  solana.PublicKey
+	verified bool
+	share    uint8
+}
+
+func borshString(b *bytes.Buffer, s string) {
+	binary.Write(b, binary.LittleEndian, uint32(len(s)))
+	b.WriteString(s)
+}
+
+// programmableMetadataAccount encodes a metaplex metadata account of a pNFT with ruleSet
+func programmableMetadataAccount(mint solana.PublicKey, bps uint16, creators []testCreator, ruleSet solana.PublicKey) []byte {
+	var b bytes.Buffer
+	b.WriteByte(4) //MetadataV1
+	b.Write(solana.NewWallet().PublicKey().Bytes())
+	b.Write(mint[:])
+	borshString(&b, "Programmable")
+	borshString(&b, "PNFT")
+	borshString(&b, "https://example.com/pnft.json")
+	binary.Write(&b, binary.LittleEndian, bps)
+	b.WriteByte(1)
+	binary.Write(&b, binary.LittleEndian, uint32(len(creators)))
+	for _, c := range creators {
+		b.Write(c.address[:])
+		if c.verified {
+			b.WriteByte(1)
+		} else {
+			b.WriteByte(0)
+		}
+		b.WriteByte(c.share)
+	}
+	b.Write([]byte{1, 1})   //Primary sale happened, mutable
+	b.Write([]byte{1, 254}) //Edition nonce
+	b.Write([]byte{1, byte(token_metadata.TokenStandardProgrammableNonFungible)})
+	b.Write([]byte{0, 0, 0}) //No collection, uses or collection details
+	b.Write([]byte{1, 0, 1}) //ProgrammableConfig::V1 with a rule set
+	b.Write(ruleSet[:])
+	b.Write(make([]byte, 679-b.Len()))
+	return b.Bytes()
+}
+
+// coreRoyaltiesPlugin encodes Plugin::Royalties with a program deny list
+func coreRoyaltiesPlugin(bps uint16, creators []testCreator, denied ...solana.PublicKey) []byte {
+	var b bytes.Buffer
+	b.WriteByte(0)
+	binary.Write(&b, binary.LittleEndian, bps)
+	binary.Write(&b, binary.LittleEndian, uint32(len(creators)))
+	for _, c := range creators {
+		b.Write(c.address[:])
+		b.WriteByte(c.share)
+	}
+	if len(denied) == 0 {
+		b.WriteByte(0)
+		return b.Bytes()
+	}
+	b.WriteByte(2)
+	binary.Write(&b, binary.LittleEndian, uint32(len(denied)))
+	for _, p := range denied {
+		b.Write(p[:])
+	}
+	return b.Bytes()
+}
+
+// withPlugins appends a plugin header, the plugin & a registry pointing to it to a core base account
+func withPlugins(base []byte, plugin []byte) []byte {
+	pluginOffset := len(base) + 9
+	registryOffset := pluginOffset + len(plugin)
+
+	b := bytes.NewBuffer(append([]byte{}, base...))
+	b.WriteByte(3) //PluginHeaderV1
+	binary.Write(b, binary.LittleEndian, uint64(registryOffset))
+	b.Write(plugin)
+	b.WriteByte(4) //PluginRegistryV1
+	binary.Write(b, binary.LittleEndian, uint32(2))
+	b.Write([]byte{2, 1}) //FreezeDelegate held by the owner
+	binary.Write(b, binary.LittleEndian, uint64(pluginOffset))
+	b.Write([]byte{0, 2}) //Royalties held by the update authority
+	binary.Write(b, binary.LittleEndian, uint64(pluginOffset))
+	binary.Write(b, binary.LittleEndian, uint32(0)) //No external plugins
+	return b.Bytes()
+}
+
+func coreAssetAccount(collection *solana.PublicKey) []byte {
+	var b bytes.Buffer
+	b.WriteByte(1) //AssetV1
+	b.Write(solana.NewWallet().PublicKey().Bytes())
+	if collection != nil {
+		b.WriteByte(2)
+		b.Write(collection[:])
+	} else {
+		b.WriteByte(1)
+		b.Write(solana.NewWallet().PublicKey().Bytes())
+	}
+	borshString(&b, "Core Asset")
+	borshString(&b, "https://example.com/core.json")
+	b.WriteByte(0) //No seq
+	return b.Bytes()
+}
+
+func coreCollectionAccount() []byte {
+	var b bytes.Buffer
+	b.WriteByte(5) //CollectionV1
+	b.Write(solana.NewWallet().PublicKey().Bytes())
+	borshString(&b, "Core Collection")
+	borshString(&b, "https://example.com/collection.json")
+	binary.Write(&b, binary.LittleEndian, uint32(10))
+	binary.Write(&b, binary.LittleEndian, uint32(9))
+	return b.Bytes()
+}
+
+func TestRoyaltyService(t *testing.T) {
+	standIn := newAccountStandIn()
+	defer standIn.Close()
+
+	sol := &SolanaService{client: rpc.New(standIn.URL), commitments: defaultRPCCommitments}
+	svc := RoyaltyService{sql: newTestSqlite(t), sol: sol}
+
+	creators := []testCreator{
+		{address: solana.NewWallet().PublicKey(), verified: true, share: 70},
+		{address: solana.NewWallet().PublicKey(), share: 30},
+	}
+	decode := func(t *testing.T, key solana.PublicKey, accounts ...*rpc.Account) *token_metadata.Metadata {
+		t.Helper()
+		meta, _, err := sol.decodeTokenData(key, append(accounts, make([]*rpc.Account, 4-len(accounts))...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return meta
+	}
+
+	t.Run("Programmable", func(t *testing.T) {
+		mint := solana.NewWallet().PublicKey()
+		ruleSet := solana.NewWallet().PublicKey()
+		meta := decode(t, mint, nil, &rpc.Account{Owner: solana.TokenMetadataProgramID, Data: rpc.DataBytesOrJSONFromBytes(programmableMetadataAccount(mint, 500, creators, ruleSet))})
+		if meta.TokenStandard == nil || *meta.TokenStandard != token_metadata.TokenStandardProgrammableNonFungible || meta.EditionNonce == nil || *meta.EditionNonce != 254 {
+			t.Fatalf("Unexpected metadata layout %+v", meta)
+		}
+
+		royalties, err := svc.royalties(mint.String(), meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if royalties.SellerFeeBasisPoints != 500 || royalties.ProgrammableRuleSet != ruleSet.String() || royalties.RuleSet != nil {
+			t.Fatalf("Unexpected royalties %+v", royalties)
+		}
+		if len(royalties.Creators) != 2 || royalties.Creators[0] != (nft_proxy.RoyaltyCreator{Address: creators[0].address.String(), Verified: true, Share: 70}) || royalties.Creators[1].Verified {
+			t.Fatalf("Unexpected creators %+v", royalties.Creators)
+		}
+		if err := validateRoyalties(royalties); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Core", func(t *testing.T) {
+		asset := solana.NewWallet().PublicKey()
+		denied := solana.NewWallet().PublicKey()
+		data := withPlugins(coreAssetAccount(nil), coreRoyaltiesPlugin(250, creators, denied))
+		meta := decode(t, asset, &rpc.Account{Owner: nft_proxy.METAPLEX_CORE, Data: rpc.DataBytesOrJSONFromBytes(data)})
+
+		royalties, err := svc.royalties(asset.String(), meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if royalties.SellerFeeBasisPoints != 250 || len(royalties.Creators) != 2 || royalties.Creators[0].Verified || royalties.Collection != "" {
+			t.Fatalf("Unexpected royalties %+v", royalties)
+		}
+		if rs := royalties.RuleSet; rs == nil || rs.Type != nft_proxy.RuleSetDenyList || len(rs.Programs) != 1 || rs.Programs[0] != denied.String() {
+			t.Fatalf("Unexpected rule set %+v", royalties.RuleSet)
+		}
+	})
+
+	t.Run("Core Collection", func(t *testing.T) {
+		asset := solana.NewWallet().PublicKey()
+		collection := solana.NewWallet().PublicKey()
+		standIn.setAccount(collection, nft_proxy.METAPLEX_CORE, withPlugins(coreCollectionAccount(), coreRoyaltiesPlugin(800, creators[:1])))
+		creators[0].share = 100
+		defer func() { creators[0].share = 70 }()
+
+		meta := decode(t, asset, &rpc.Account{Owner: nft_proxy.METAPLEX_CORE, Data: rpc.DataBytesOrJSONFromBytes(coreAssetAccount(&collection))})
+		if meta.Collection == nil || meta.Collection.Key != collection || meta.CoreRoyalties != nil {
+			t.Fatalf("Expected the asset to inherit from its collection, got %+v", meta)
+		}
+
+		royalties, err := svc.royalties(asset.String(), meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if royalties.SellerFeeBasisPoints != 800 || royalties.Collection != collection.String() || royalties.RuleSet != nil {
+			t.Fatalf("Unexpected royalties %+v", royalties)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for name, r := range map[string]*nft_proxy.SolanaRoyalties{
+			"Fee":    {SellerFeeBasisPoints: 10001},
+			"Shares": {SellerFeeBasisPoints: 500, Creators: []nft_proxy.RoyaltyCreator{{Share: 60}, {Share: 30}}},
+		} {
+			if err := validateRoyalties(r); !errors.Is(err, ErrInvalidRoyalties) {
+				t.Fatalf("%s: expected ErrInvalidRoyalties, got %v", name, err)
+			}
+		}
+	})
+
+	t.Run("Cached", func(t *testing.T) {
+		mint := solana.NewWallet().PublicKey()
+		ruleSet := solana.NewWallet().PublicKey()
+		standIn.setAccount(mint, solana.TokenProgramID, nil)
+		metadata, _, _ := sol.FindTokenMetadataAddress(mint, solana.TokenMetadataProgramID)
+		standIn.setAccount(metadata, solana.TokenMetadataProgramID, programmableMetadataAccount(mint, 500, creators, ruleSet))
+
+		fetched, err := svc.Royalties(mint.String(), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		standIn.reset()
+
+		cached, err := svc.Royalties(mint.String(), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if standIn.Requests(metadata) != 0 {
+			t.Fatal("Expected cached royalties to be served without an rpc call")
+		}
+		if cached.SellerFeeBasisPoints != fetched.SellerFeeBasisPoints || len(cached.Creators) != 2 || cached.ProgrammableRuleSet != ruleSet.String() {
+			t.Fatalf("Unexpected cached royalties %+v", cached)
+		}
+	})
+}
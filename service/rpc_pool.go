@@ -0,0 +1,423 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	ctx "context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+var (
+	ErrNoRPCEndpoints     = errors.New("no rpc endpoints configured")
+	ErrInvalidRPCEndpoint = errors.New("invalid rpc endpoint")
+)
+
+// endpointRPCErrors are JSON-RPC errors nodes return with a 200 status when the endpoint, not the request, is at fault
+var endpointRPCErrors = map[int]bool{
+	-32004: true, //Block not available, the node is behind
+	-32005: true, //Node unhealthy, also used by providers when rate limiting
+	-32016: true, //Minimum context slot not reached
+}
+
+// rpcEndpoint is a single node in the rpc pool
+type rpcEndpoint struct {
+	URL     *url.URL
+	Weight  float64
+	limiter *rate.Limiter //nil when unlimited
+
+	mu        sync.Mutex
+	healthy   bool
+	failures  int       //Consecutive failures
+	openUntil time.Time //Circuit breaker rejects requests until this time
+	requests  uint64
+	errors    uint64
+}
+
+// RPCEndpointHealth describes an endpoint for stats, without exposing api keys held in its url
+type RPCEndpointHealth struct {
+	Host        string  `json:"host"`
+	Weight      float64 `json:"weight"`
+	Healthy     bool    `json:"healthy"`
+	CircuitOpen bool    `json:"circuit_open"`
+	Requests    uint64  `json:"requests"`
+	Errors      uint64  `json:"errors"`
+}
+
+// rpcPool is a http.RoundTripper spreading JSON-RPC requests across weighted endpoints,
+// failing over on errors, timeouts & rate limits & opening a circuit on endpoints that keep failing
+type rpcPool struct {
+	endpoints []*rpcEndpoint
+	transport http.RoundTripper
+
+	attemptTimeout   time.Duration //Per endpoint, so a hung node fails over rather than using the whole request
+	breakerThreshold int           //Consecutive failures before the circuit opens
+	breakerCooldown  time.Duration
+
+	randMu sync.Mutex
+	rand   *rand.Rand
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// newRPCPool builds a pool from comma separated `url[|weight[|requests per second]]` entries
+func newRPCPool(spec string, transport http.RoundTripper) (*rpcPool, error) {
+	p := rpcPool{
+		transport:        transport,
+		attemptTimeout:   10 * time.Second,
+		breakerThreshold: 5,
+		breakerCooldown:  30 * time.Second,
+		rand:             rand.New(rand.NewSource(time.Now().UnixNano())),
+		stop:             make(chan struct{}),
+	}
+	if p.transport == nil {
+		p.transport = http.DefaultTransport
+	}
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		e, err := parseRPCEndpoint(entry)
+		if err != nil {
+			return nil, err
+		}
+		p.endpoints = append(p.endpoints, e)
+	}
+
+	if len(p.endpoints) == 0 {
+		return nil, ErrNoRPCEndpoints
+	}
+	return &p, nil
+}
+
+func parseRPCEndpoint(entry string) (*rpcEndpoint, error) {
+	parts := strings.Split(entry, "|")
+
+	u, err := url.Parse(parts[0])
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidRPCEndpoint, redactURL(parts[0]))
+	}
+
+	e := rpcEndpoint{URL: u, Weight: 1, healthy: true}
+
+	if len(parts) > 1 && parts[1] != "" {
+		e.Weight, err = strconv.ParseFloat(parts[1], 64)
+		if err != nil || e.Weight <= 0 {
+			return nil, fmt.Errorf("%w: weight %s", ErrInvalidRPCEndpoint, parts[1])
+		}
+	}
+
+	if len(parts) > 2 && parts[2] != "" {
+		rps, err := strconv.ParseFloat(parts[2], 64)
+		if err != nil || rps <= 0 {
+			return nil, fmt.Errorf("%w: rate limit %s", ErrInvalidRPCEndpoint, parts[2])
+		}
+		e.limiter = rate.NewLimiter(rate.Limit(rps), int(math.Max(1, math.Ceil(rps))))
+	}
+
+	return &e, nil
+}
+
+// redactURL drops the path & query of a url, providers commonly put api keys there
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return "invalid"
+	}
+	return u.Host
+}
+
+// available reports whether the endpoint is healthy & its circuit is closed or ready to be probed again
+func (e *rpcEndpoint) available(now time.Time) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.healthy && !now.Before(e.openUntil)
+}
+
+func (e *rpcEndpoint) record(ok bool, threshold int, cooldown time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.requests++
+	if ok {
+		e.failures = 0
+		e.openUntil = time.Time{}
+		return
+	}
+
+	e.errors++
+	e.failures++
+	if e.failures >= threshold {
+		if e.failures == threshold {
+			log.Printf("RPC circuit open for %s after %v failures", e.URL.Host, e.failures)
+		}
+		e.openUntil = time.Now().Add(cooldown) //Half-open once elapsed, a single failure re-opens it
+	}
+}
+
+func (e *rpcEndpoint) setHealthy(healthy bool) {
+	e.mu.Lock()
+	if e.healthy != healthy {
+		log.Printf("RPC endpoint %s healthy: %v", e.URL.Host, healthy)
+	}
+	e.healthy = healthy
+	e.mu.Unlock()
+}
+
+// order returns the endpoints to try, available endpoints first in weighted random order.
+// Unavailable endpoints are kept as a last resort rather than failing outright.
+func (p *rpcPool) order() []*rpcEndpoint {
+	now := time.Now()
+
+	type keyed struct {
+		e   *rpcEndpoint
+		key float64
+	}
+
+	var available, unavailable []keyed
+	p.randMu.Lock()
+	for _, e := range p.endpoints {
+		//Weighted sampling without replacement, the largest u^(1/w) goes first
+		k := keyed{e: e, key: math.Pow(p.rand.Float64(), 1/e.Weight)}
+		if e.available(now) {
+			available = append(available, k)
+		} else {
+			unavailable = append(unavailable, k)
+		}
+	}
+	p.randMu.Unlock()
+
+	for _, list := range [][]keyed{available, unavailable} {
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].key > list[j].key
+		})
+	}
+
+	ordered := make([]*rpcEndpoint, 0, len(p.endpoints))
+	for _, k := range append(available, unavailable...) {
+		ordered = append(ordered, k.e)
+	}
+	return ordered
+}
+
+func (p *rpcPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return nil, errors.New("rpc pool requires a replayable request body")
+	}
+
+	var lastResp *http.Response
+	var lastErr error
+	var limited []*rpcEndpoint
+
+	try := func(e *rpcEndpoint) (*http.Response, bool) {
+		resp, err := p.send(req, e)
+
+		ok := err == nil && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests
+		if ok && resp.StatusCode == http.StatusOK && endpointRPCError(resp) {
+			ok = false
+		}
+		e.record(ok, p.breakerThreshold, p.breakerCooldown)
+		if ok {
+			return resp, true
+		}
+
+		if lastResp != nil {
+			lastResp.Body.Close()
+		}
+		lastResp, lastErr = resp, err
+		return nil, false
+	}
+
+	for _, e := range p.order() {
+		if e.limiter != nil && !e.limiter.Allow() {
+			limited = append(limited, e)
+			continue
+		}
+
+		if resp, ok := try(e); ok {
+			return resp, nil
+		}
+		if req.Context().Err() != nil {
+			break
+		}
+	}
+
+	//Every endpoint with capacity failed, wait for the first one at its rate limit
+	if len(limited) > 0 && req.Context().Err() == nil {
+		e := limited[0]
+		if err := e.limiter.Wait(req.Context()); err == nil {
+			if resp, ok := try(e); ok {
+				return resp, nil
+			}
+		}
+	}
+
+	if lastResp != nil {
+		return lastResp, nil //Let the caller see the status & any Retry-After
+	}
+	if lastErr == nil {
+		lastErr = req.Context().Err()
+	}
+	return nil, lastErr
+}
+
+// send forwards req to e, bounded by the per attempt timeout
+func (p *rpcPool) send(req *http.Request, e *rpcEndpoint) (*http.Response, error) {
+	c, cancel := ctx.WithTimeout(req.Context(), p.attemptTimeout)
+
+	r := req.Clone(c)
+	r.URL = e.URL
+	r.Host = ""
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		r.Body = body
+	}
+
+	resp, err := p.transport.RoundTrip(r)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// endpointRPCError reports whether resp holds a JSON-RPC error caused by the endpoint. Error bodies are small,
+// so only bodies ending within the first few KB are decoded, larger results are passed through unread.
+func endpointRPCError(resp *http.Response) bool {
+	buf := bufio.NewReaderSize(resp.Body, 4096)
+	resp.Body = &peekedBody{Reader: buf, Closer: resp.Body}
+
+	prefix, err := buf.Peek(4096)
+	if err != io.EOF {
+		return false
+	}
+
+	var msg struct {
+		Error *struct {
+			Code int `json:"code"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(prefix, &msg) != nil || msg.Error == nil {
+		return false
+	}
+	return endpointRPCErrors[msg.Error.Code]
+}
+
+// peekedBody reads a response body through the buffer it was peeked with
+type peekedBody struct {
+	*bufio.Reader
+	io.Closer
+}
+
+// cancelOnClose releases the attempt context once the response body has been consumed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel ctx.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// healthCheck marks endpoints unhealthy when getHealth does not return ok
+func (p *rpcPool) healthCheck() {
+	body := []byte(`{"jsonrpc":"2.0","id":1,"method":"getHealth"}`)
+
+	var wg sync.WaitGroup
+	for _, e := range p.endpoints {
+		wg.Add(1)
+		go func(e *rpcEndpoint) {
+			defer wg.Done()
+
+			c, cancel := ctx.WithTimeout(ctx.Background(), p.attemptTimeout)
+			defer cancel()
+
+			req, err := http.NewRequestWithContext(c, http.MethodPost, e.URL.String(), bytes.NewReader(body))
+			if err != nil {
+				e.setHealthy(false)
+				return
+			}
+			req.Header.Set("Content-Type", "application/json")
+
+			resp, err := p.transport.RoundTrip(req)
+			if err != nil {
+				e.setHealthy(false)
+				return
+			}
+			defer resp.Body.Close()
+
+			var result struct {
+				Result string `json:"result"`
+			}
+			err = json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&result)
+			e.setHealthy(err == nil && resp.StatusCode == http.StatusOK && result.Result == "ok")
+		}(e)
+	}
+	wg.Wait()
+}
+
+// monitor runs health checks every interval until Close is called
+func (p *rpcPool) monitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.healthCheck()
+		}
+	}
+}
+
+func (p *rpcPool) Close() {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
+}
+
+// Health returns the current state of every endpoint
+func (p *rpcPool) Health() []RPCEndpointHealth {
+	now := time.Now()
+	health := make([]RPCEndpointHealth, len(p.endpoints))
+	for i, e := range p.endpoints {
+		e.mu.Lock()
+		health[i] = RPCEndpointHealth{
+			Host:        e.URL.Host,
+			Weight:      e.Weight,
+			Healthy:     e.healthy,
+			CircuitOpen: now.Before(e.openUntil),
+			Requests:    e.requests,
+			Errors:      e.errors,
+		}
+		e.mu.Unlock()
+	}
+	return health
+}
@@ -0,0 +1,235 @@
+package services
+
+import (
+	ctx "context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gagliardetto/solana-go/rpc"
+)
+
+// rpcStandIn is a local JSON-RPC node answering getSlot & getHealth
+type rpcStandIn struct {
+	*httptest.Server
+	slot    uint64
+	status  int32 //HTTP status to fail with, 0 answers normally
+	rpcErr  int32 //JSON-RPC error code to fail with, sent with a 200 status
+	health  atomic.Value
+	handled int32
+}
+
+func newRPCStandIn(slot uint64) *rpcStandIn {
+	s := &rpcStandIn{slot: slot}
+	s.health.Store("ok")
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     interface{} `json:"id"`
+			Method string      `json:"method"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+
+		if req.Method == "getHealth" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": s.health.Load()})
+			return
+		}
+
+		atomic.AddInt32(&s.handled, 1)
+		if status := atomic.LoadInt32(&s.status); status != 0 {
+			w.WriteHeader(int(status))
+			return
+		}
+		if code := atomic.LoadInt32(&s.rpcErr); code != 0 {
+			json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "error": map[string]interface{}{"code": code, "message": "failed"}})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": s.slot})
+	}))
+	return s
+}
+
+func (s *rpcStandIn) Handled() int32 {
+	return atomic.SwapInt32(&s.handled, 0)
+}
+
+func newTestRPCPool(t *testing.T, spec string) *rpcPool {
+	t.Helper()
+
+	pool, err := newRPCPool(spec, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pool
+}
+
+func newTestRPCClient(pool *rpcPool) *rpc.Client {
+	return rpc.NewWithCustomRPCClient(newPooledJSONRPCClient(pool, retryPolicy{Name: "rpc", MaxAttempts: 1}, nil))
+}
+
+func TestRPCPool(t *testing.T) {
+	primary := newRPCStandIn(100)
+	defer primary.Close()
+	secondary := newRPCStandIn(200)
+	defer secondary.Close()
+
+	t.Run("Config", func(t *testing.T) {
+		pool := newTestRPCPool(t, primary.URL+"|3|50, "+secondary.URL)
+		if len(pool.endpoints) != 2 || pool.endpoints[0].Weight != 3 || pool.endpoints[0].limiter == nil || pool.endpoints[1].limiter != nil {
+			t.Fatalf("Unexpected endpoints %+v", pool.endpoints)
+		}
+
+		for _, spec := range []string{"", "ftp://node", primary.URL + "|0", primary.URL + "|1|fast"} {
+			if _, err := newRPCPool(spec, nil); err == nil {
+				t.Fatalf("Expected %q to be rejected", spec)
+			}
+		}
+	})
+
+	t.Run("Failover", func(t *testing.T) {
+		atomic.StoreInt32(&primary.status, http.StatusBadGateway)
+		defer atomic.StoreInt32(&primary.status, 0)
+
+		pool := newTestRPCPool(t, primary.URL+"|1000,"+secondary.URL+"|0.001")
+		client := newTestRPCClient(pool)
+
+		slot, err := client.GetSlot(ctx.Background(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if slot != 200 || primary.Handled() != 1 || secondary.Handled() != 1 {
+			t.Fatalf("Expected failover to secondary, got slot %v", slot)
+		}
+	})
+
+	t.Run("RPC Error", func(t *testing.T) {
+		atomic.StoreInt32(&primary.rpcErr, -32005)
+		defer atomic.StoreInt32(&primary.rpcErr, 0)
+
+		pool := newTestRPCPool(t, primary.URL+"|1000,"+secondary.URL+"|0.001")
+		client := newTestRPCClient(pool)
+
+		slot, err := client.GetSlot(ctx.Background(), "")
+		if err != nil || slot != 200 || primary.Handled() != 1 || secondary.Handled() != 1 {
+			t.Fatalf("Expected a node behind to fail over, got %v (%v)", slot, err)
+		}
+		if health := pool.Health(); health[0].Errors != 1 {
+			t.Fatalf("Expected the rpc error to count against the endpoint, got %+v", health)
+		}
+
+		//Errors caused by the request are returned without failing over
+		atomic.StoreInt32(&primary.rpcErr, -32602)
+		_, err = client.GetSlot(ctx.Background(), "")
+		if err == nil || primary.Handled() != 1 || secondary.Handled() != 0 {
+			t.Fatalf("Expected invalid params to be returned from the first endpoint, got %v", err)
+		}
+		if health := pool.Health(); health[0].Errors != 1 {
+			t.Fatalf("Expected invalid params not to count against the endpoint, got %+v", health)
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.Copy(io.Discard, r.Body) //Disconnects are only noticed once the body is consumed
+			<-r.Context().Done()
+		}))
+		defer hung.Close()
+
+		pool := newTestRPCPool(t, hung.URL+"|1000,"+secondary.URL+"|0.001")
+		pool.attemptTimeout = 50 * time.Millisecond
+		client := newTestRPCClient(pool)
+
+		slot, err := client.GetSlot(ctx.Background(), "")
+		if err != nil || slot != 200 {
+			t.Fatalf("Expected timed out endpoint to fail over, got %v (%v)", slot, err)
+		}
+		secondary.Handled()
+	})
+
+	t.Run("Circuit Breaker", func(t *testing.T) {
+		atomic.StoreInt32(&primary.status, http.StatusServiceUnavailable)
+		defer atomic.StoreInt32(&primary.status, 0)
+
+		pool := newTestRPCPool(t, primary.URL+"|1000,"+secondary.URL+"|0.001")
+		pool.breakerThreshold = 2
+		client := newTestRPCClient(pool)
+
+		for i := 0; i < 5; i++ {
+			if _, err := client.GetSlot(ctx.Background(), ""); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if handled := primary.Handled(); handled != 2 {
+			t.Fatalf("Expected circuit to open after 2 failures, primary handled %v", handled)
+		}
+		if health := pool.Health(); !health[0].CircuitOpen || health[0].Errors != 2 || health[1].Requests != 5 {
+			t.Fatalf("Unexpected health %+v", health)
+		}
+		secondary.Handled()
+
+		//Half-open after the cooldown, a successful probe closes the circuit
+		atomic.StoreInt32(&primary.status, 0)
+		pool.endpoints[0].mu.Lock()
+		pool.endpoints[0].openUntil = time.Now()
+		pool.endpoints[0].mu.Unlock()
+
+		slot, err := client.GetSlot(ctx.Background(), "")
+		if err != nil || slot != 100 || pool.Health()[0].CircuitOpen {
+			t.Fatalf("Expected recovered primary to serve requests, got %v (%v)", slot, err)
+		}
+		primary.Handled()
+	})
+
+	t.Run("Rate Limit", func(t *testing.T) {
+		pool := newTestRPCPool(t, primary.URL+"|1000|1,"+secondary.URL+"|0.001")
+		client := newTestRPCClient(pool)
+
+		for i := 0; i < 3; i++ {
+			if _, err := client.GetSlot(ctx.Background(), ""); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if p, s := primary.Handled(), secondary.Handled(); p != 1 || s != 2 {
+			t.Fatalf("Expected requests beyond the rate limit to spill over, got %v & %v", p, s)
+		}
+	})
+
+	t.Run("Weights", func(t *testing.T) {
+		pool := newTestRPCPool(t, primary.URL+"|4,"+secondary.URL+"|1")
+		client := newTestRPCClient(pool)
+
+		for i := 0; i < 200; i++ {
+			if _, err := client.GetSlot(ctx.Background(), ""); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		p, s := primary.Handled(), secondary.Handled()
+		if s == 0 || p < 2*s {
+			t.Fatalf("Expected ~4:1 split, got %v & %v", p, s)
+		}
+	})
+
+	t.Run("Health Check", func(t *testing.T) {
+		primary.health.Store("behind")
+		defer primary.health.Store("ok")
+
+		pool := newTestRPCPool(t, primary.URL+"|1000,"+secondary.URL+"|0.001")
+		pool.healthCheck()
+
+		if health := pool.Health(); health[0].Healthy || !health[1].Healthy {
+			t.Fatalf("Expected only primary to be unhealthy, got %+v", health)
+		}
+
+		slot, err := newTestRPCClient(pool).GetSlot(ctx.Background(), "")
+		if err != nil || slot != 200 || primary.Handled() != 0 {
+			t.Fatalf("Expected unhealthy endpoint to be skipped, got %v (%v)", slot, err)
+		}
+		secondary.Handled()
+	})
+}
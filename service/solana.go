@@ -5,49 +5,135 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"os"
+	"net/http"
 	"strings"
+	"time"
 
 	nft_proxy "github.com/alphabatem/nft-proxy"
+	"github.com/alphabatem/nft-proxy/config"
+	"github.com/alphabatem/nft-proxy/libreplex"
 	"github.com/alphabatem/nft-proxy/metaplex_core"
+	token_extensions "github.com/alphabatem/nft-proxy/token-extensions"
 	token_metadata "github.com/alphabatem/nft-proxy/token-metadata"
 	"github.com/alphabatem/token_2022_go"
 	"github.com/babilu-online/common/context"
 	bin "github.com/gagliardetto/binary"
+	metaplex_token_metadata "github.com/gagliardetto/metaplex-go/clients/token-metadata"
 	"github.com/gagliardetto/solana-go"
 	"github.com/gagliardetto/solana-go/rpc"
+	"github.com/gagliardetto/solana-go/rpc/jsonrpc"
 )
 
 // Added context (ctx.Context) to all RPC calls, allowing better control of timeouts and cancellations.
 
 type SolanaService struct {
 	context.DefaultService
+	Config *config.Config
+
 	client *rpc.Client
+	pool   *rpcPool
+	das    jsonrpc.RPCClient //DAS api, served by most rpc providers alongside the standard methods
+
+	ctx    ctx.Context //Cancelled by Close, abandoning DAS calls still in flight
+	cancel ctx.CancelFunc
+
+	commitments rpcCommitments
+
+	stats *StatService
 }
 
 const SOLANA_SVC = "solana_svc"
 
+var ErrTokenDataNotFound = errors.New("unable to find token metadata")
+
+// rpcCommitments are the commitment levels used for each type of call
+type rpcCommitments struct {
+	Metadata  rpc.CommitmentType //Metadata is cached, processed data may still be rolled back
+	Blockhash rpc.CommitmentType
+}
+
+var defaultRPCCommitments = rpcCommitmentsFrom(config.Default().RPC.Commitment)
+
 func (svc SolanaService) Id() string {
 	return SOLANA_SVC
 }
 
-// Start initializes the RPC client for Solana with error handling for invalid RPC URLs
+// Start initializes the RPC client for Solana with error handling for invalid RPC URLs.
+// Requests are balanced over the configured rpc endpoints, DAS endpoints are used when the DAS api is served elsewhere.
 func (svc *SolanaService) Start() error {
-	rpcURL := os.Getenv("RPC_URL")
-	if rpcURL == "" {
-		return fmt.Errorf("RPC_URL is not configured")
+	if svc.Config == nil {
+		return config.ErrMissingConfig
+	}
+	if len(svc.Config.RPC.Endpoints) == 0 {
+		return fmt.Errorf("rpc endpoints are not configured")
+	}
+
+	svc.stats = svc.DefaultService(STAT_SVC).(*StatService)
+	svc.commitments = rpcCommitmentsFrom(svc.Config.RPC.Commitment)
+	svc.ctx, svc.cancel = ctx.WithCancel(ctx.Background())
+
+	pool, err := newRPCPool(strings.Join(svc.Config.RPC.Endpoints, ","), http.DefaultTransport.(*http.Transport).Clone())
+	if err != nil {
+		return err
 	}
+	svc.pool = pool
+	go svc.pool.monitor(30 * time.Second)
+
+	policy := retryPolicyFrom(rpcRetryPolicy, svc.Config.Retry.RPC)
+	rpcClient := newPooledJSONRPCClient(svc.pool, policy, svc.stats)
+	svc.client = rpc.NewWithCustomRPCClient(rpcClient)
 
-	svc.client = rpc.New(rpcURL)
+	svc.das = rpcClient
+	if len(svc.Config.RPC.DASEndpoints) > 0 {
+		dasPool, err := newRPCPool(strings.Join(svc.Config.RPC.DASEndpoints, ","), http.DefaultTransport.(*http.Transport).Clone())
+		if err != nil {
+			return fmt.Errorf("das endpoints: %w", err)
+		}
+		svc.das = newPooledJSONRPCClient(dasPool, policy, svc.stats)
+	}
 	return nil
 }
 
+// Close cancels in-flight DAS calls & stops the rpc endpoint health checks
+func (svc *SolanaService) Close() {
+	if svc.cancel != nil {
+		svc.cancel()
+	}
+	if svc.pool != nil {
+		svc.pool.Close()
+	}
+}
+
+// rpcCommitmentsFrom maps configured commitments, which have already been validated
+func rpcCommitmentsFrom(c config.Commitments) rpcCommitments {
+	return rpcCommitments{
+		Metadata:  rpc.CommitmentType(c.Metadata),
+		Blockhash: rpc.CommitmentType(c.Blockhash),
+	}
+}
+
+// newPooledJSONRPCClient sends requests through pool, retrying with backoff once every endpoint has failed
+func newPooledJSONRPCClient(pool *rpcPool, policy retryPolicy, stats *StatService) jsonrpc.RPCClient {
+	httpClient := withRetries(&http.Client{Timeout: 30 * time.Second, Transport: pool}, policy, stats)
+
+	//The endpoint is replaced per request by the pool
+	return jsonrpc.NewClientWithOpts(pool.endpoints[0].URL.String(), &jsonrpc.RPCClientOpts{HTTPClient: httpClient})
+}
+
 func (svc *SolanaService) Client() *rpc.Client {
 	return svc.client
 }
 
+// RPCHealth reports the state of each configured rpc endpoint
+func (svc *SolanaService) RPCHealth() []RPCEndpointHealth {
+	if svc == nil || svc.pool == nil {
+		return nil
+	}
+	return svc.pool.Health()
+}
+
 func (svc *SolanaService) RecentBlockhash() (solana.Hash, error) {
-	bhash, err := svc.Client().GetRecentBlockhash(ctx.Background(), rpc.CommitmentFinalized)
+	bhash, err := svc.Client().GetLatestBlockhash(ctx.Background(), svc.commitments.Blockhash)
 	if err != nil {
 		return solana.Hash{}, err
 	}
@@ -56,29 +142,133 @@ func (svc *SolanaService) RecentBlockhash() (solana.Hash, error) {
 }
 
 func (svc *SolanaService) TokenData(key solana.PublicKey) (*token_metadata.Metadata, uint8, error) {
-	var meta token_metadata.Metadata
-	var mint token_2022.Mint
+	meta, decimals, _, err := svc.TokenDataSlot(key)
+	return meta, decimals, err
+}
 
+// TokenDataSlot returns the token metadata along with the slot it was read at
+func (svc *SolanaService) TokenDataSlot(key solana.PublicKey) (*token_metadata.Metadata, uint8, uint64, error) {
 	ata, _, _ := svc.FindTokenMetadataAddress(key, solana.TokenMetadataProgramID)
 	ataT22, _, _ := svc.FindTokenMetadataAddress(key, solana.MustPublicKeyFromBase58("META4s4fSmpkTbZoUsgC1oBnWB31vQcmnN8giPw51Zu"))
 
-	accs, err := svc.client.GetMultipleAccountsWithOpts(ctx.TODO(), []solana.PublicKey{key, ata, ataT22}, &rpc.GetMultipleAccountsOpts{Commitment: rpc.CommitmentProcessed})
+	libreplexMeta, _, _ := libreplex.FindMetadataAddress(key)
+	edition, _, _ := token_metadata.FindEditionAddress(key)
+
+	accs, err := svc.client.GetMultipleAccountsWithOpts(ctx.TODO(), []solana.PublicKey{key, ata, ataT22, libreplexMeta, edition}, &rpc.GetMultipleAccountsOpts{Commitment: svc.commitments.Metadata})
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	meta, decimals, err := svc.decodeTokenData(key, accs.Value)
+	if err == nil && meta.Edition != nil && !meta.Edition.IsMaster() {
+		meta.MasterEdition, err = svc.edition(meta.Edition.Parent)
+		if err != nil {
+			log.Printf("%s master edition err: %s", key, err) //Still label the print
+			err = nil
+		}
+	}
+	return meta, decimals, accs.Context.Slot, err
+}
+
+// edition reads the master or print edition account key
+func (svc *SolanaService) edition(key solana.PublicKey) (*token_metadata.Edition, error) {
+	acc, err := svc.client.GetAccountInfoWithOpts(ctx.TODO(), key, &rpc.GetAccountInfoOpts{Commitment: svc.commitments.Metadata})
+	if err != nil {
+		return nil, err
+	}
+	if acc.Value.Owner != solana.TokenMetadataProgramID {
+		return nil, token_metadata.ErrInvalidMetadata
+	}
+	return token_metadata.DecodeEdition(acc.Value.Data.GetBinary())
+}
+
+// Accounts returns the accounts of keys, nil where there is no account, & the lowest slot they were read at
+func (svc *SolanaService) Accounts(keys []solana.PublicKey) ([]*rpc.Account, uint64, error) {
+	accounts := make([]*rpc.Account, 0, len(keys))
+	var slot uint64
+	for start := 0; start < len(keys); start += 100 { //getMultipleAccounts limit
+		end := start + 100
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		accs, err := svc.client.GetMultipleAccountsWithOpts(ctx.TODO(), keys[start:end], &rpc.GetMultipleAccountsOpts{Commitment: svc.commitments.Metadata})
+		if err != nil {
+			return nil, 0, err
+		}
+		if slot == 0 || accs.Context.Slot < slot {
+			slot = accs.Context.Slot
+		}
+		accounts = append(accounts, accs.Value...)
+	}
+	return accounts, slot, nil
+}
+
+// AccountsExist reports which of keys have an account
+func (svc *SolanaService) AccountsExist(keys []solana.PublicKey) ([]bool, error) {
+	exists := make([]bool, 0, len(keys))
+	for start := 0; start < len(keys); start += 100 { //getMultipleAccounts limit
+		end := start + 100
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		accs, err := svc.client.GetMultipleAccountsWithOpts(ctx.TODO(), keys[start:end], &rpc.GetMultipleAccountsOpts{
+			Commitment: svc.commitments.Metadata,
+			DataSlice:  &rpc.DataSlice{Offset: new(uint64), Length: new(uint64)}, //Existence only, skip the data
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, acc := range accs.Value {
+			exists = append(exists, acc != nil)
+		}
+	}
+	return exists, nil
+}
+
+// decodeTokenData decodes the mint, metadata, token-2022 metadata, libreplex metadata & edition accounts of key
+func (svc *SolanaService) decodeTokenData(key solana.PublicKey, accounts []*rpc.Account) (*token_metadata.Metadata, uint8, error) {
+	meta, decimals, err := svc.decodeMetadataAccounts(key, accounts)
 	if err != nil {
-		return nil, 0, err
+		return meta, decimals, err
 	}
 
+	if len(accounts) > 4 && accounts[4] != nil && accounts[4].Owner == solana.TokenMetadataProgramID {
+		meta.Edition, err = token_metadata.DecodeEdition(accounts[4].Data.GetBinary())
+		if err != nil {
+			log.Printf("%s edition err: %s", key, err)
+		}
+	}
+
+	if accounts[0] == nil || accounts[0].Owner != nft_proxy.TOKEN_2022 {
+		return meta, decimals, nil
+	}
+
+	//Fees & transfer restrictions apply whichever program holds the metadata
+	meta.Extensions, err = token_extensions.Decode(accounts[0].Data.GetBinary())
+	if err != nil {
+		log.Printf("%s T22 extensions err: %s", key, err)
+	}
+	return meta, decimals, nil
+}
+
+func (svc *SolanaService) decodeMetadataAccounts(key solana.PublicKey, accounts []*rpc.Account) (*token_metadata.Metadata, uint8, error) {
+	var meta token_metadata.Metadata
+	var mint token_2022.Mint
+
 	var decimals uint8
-	if accs.Value[0] != nil {
-		//log.Printf("SolanaService::TokenData:%s - Owner: %s", key, accs.Value[0].Owner)
+	if accounts[0] != nil {
+		//log.Printf("SolanaService::TokenData:%s - Owner: %s", key, accounts[0].Owner)
 
-		err := mint.UnmarshalWithDecoder(bin.NewBinDecoder(accs.Value[0].Data.GetBinary()))
+		err := mint.UnmarshalWithDecoder(bin.NewBinDecoder(accounts[0].Data.GetBinary()))
 		if err == nil {
 			decimals = mint.Decimals
 		}
 
-		switch accs.Value[0].Owner {
+		switch accounts[0].Owner {
 		case nft_proxy.METAPLEX_CORE:
-			_meta, err := svc.decodeMetaplexCoreMetadata(key, accs.Value[0].Data.GetBinary())
+			_meta, err := svc.decodeMetaplexCoreMetadata(key, accounts[0].Data.GetBinary())
 			if err != nil {
 				return nil, decimals, err
 			}
@@ -93,22 +283,26 @@ func (svc *SolanaService) TokenData(key solana.PublicKey) (*token_metadata.Metad
 				break
 			}
 			if exts != nil && exts.TokenMetadata != nil {
-				return &token_metadata.Metadata{
+				tMeta := token_metadata.Metadata{
 					// Put right name convention
-					Protocol:        token_metadata.ProtocolToken22Mint,
-					UpdateAuthority: *exts.TokenMetadata.Authority,
-					Mint:            exts.TokenMetadata.Mint,
+					Protocol: token_metadata.ProtocolToken22Mint,
+					Mint:     exts.TokenMetadata.Mint,
 					Data: token_metadata.Data{
 						Name:   exts.TokenMetadata.Name,
 						Symbol: exts.TokenMetadata.Symbol,
 						Uri:    exts.TokenMetadata.Uri,
 					},
-				}, decimals, nil
+				}
+
+				if exts.TokenMetadata.Authority != nil {
+					tMeta.UpdateAuthority = *exts.TokenMetadata.Authority
+				}
+				return &tMeta, decimals, nil
 			}
 		}
 	}
 
-	for _, acc := range accs.Value[1:] {
+	for _, acc := range accounts[1:3] {
 		if acc == nil {
 			continue
 		}
@@ -121,11 +315,19 @@ func (svc *SolanaService) TokenData(key solana.PublicKey) (*token_metadata.Metad
 		return &meta, decimals, nil
 	}
 
-	return nil, decimals, errors.New("unable to find token metadata")
+	if len(accounts) > 3 && accounts[3] != nil && accounts[3].Owner == libreplex.ProgramID {
+		_meta, err := svc.decodeLibreplexMetadata(accounts[3].Data.GetBinary())
+		if err != nil {
+			return nil, decimals, err
+		}
+		return _meta, decimals, nil
+	}
+
+	return nil, decimals, ErrTokenDataNotFound
 }
 
 func (svc *SolanaService) decodeMintMetadata(data []byte) (*token_metadata.Metadata, error) {
-	var mint token_2022_go.Mint
+	var mint token_2022.Mint
 	err := mint.UnmarshalWithDecoder(bin.NewBinDecoder(data))
 	if err != nil {
 		return nil, err
@@ -142,16 +344,20 @@ func (svc *SolanaService) decodeMintMetadata(data []byte) (*token_metadata.Metad
 		}
 
 		if exts.TokenMetadata != nil {
-			return &token_metadata.Metadata{
-				Protocol:        token_metadata.ProtocolToken22Mint,
-				UpdateAuthority: *exts.TokenMetadata.Authority,
-				Mint:            exts.TokenMetadata.Mint,
+			tMeta := token_metadata.Metadata{
+				Protocol: token_metadata.ProtocolToken22Mint,
+				Mint:     exts.TokenMetadata.Mint,
 				Data: token_metadata.Data{
 					Name:   exts.TokenMetadata.Name,
 					Symbol: exts.TokenMetadata.Symbol,
 					Uri:    exts.TokenMetadata.Uri,
 				},
-			}, nil
+			}
+
+			if exts.TokenMetadata.Authority != nil {
+				tMeta.UpdateAuthority = *exts.TokenMetadata.Authority
+			}
+			return &tMeta, nil
 		}
 	}
 
@@ -159,6 +365,10 @@ func (svc *SolanaService) decodeMintMetadata(data []byte) (*token_metadata.Metad
 }
 
 func (svc *SolanaService) decodeMetaplexCoreMetadata(mint solana.PublicKey, data []byte) (*token_metadata.Metadata, error) {
+	if len(data) > 0 && data[0] == metaplex_core.KeyCollectionV1 {
+		return svc.decodeMetaplexCoreCollection(mint, data)
+	}
+
 	var meta metaplex_core.Asset
 	err := meta.UnmarshalWithDecoder(bin.NewBinDecoder(data))
 	if err != nil {
@@ -180,9 +390,178 @@ func (svc *SolanaService) decodeMetaplexCoreMetadata(mint solana.PublicKey, data
 		tMeta.UpdateAuthority = *meta.UpdateAuthority
 	}
 
+	//Assets can only be added to a collection by its update authority
+	if meta.Collection != nil {
+		tMeta.Collection = &metaplex_token_metadata.Collection{Verified: true, Key: *meta.Collection}
+	}
+
+	tMeta.CoreRoyalties, err = metaplex_core.FindRoyalties(data)
+	if err != nil {
+		log.Printf("%s core plugins err: %s", mint, err)
+	}
+
+	return &tMeta, nil
+}
+
+// decodeMetaplexCoreCollection decodes a core collection, so collections can be looked up like their assets
+func (svc *SolanaService) decodeMetaplexCoreCollection(key solana.PublicKey, data []byte) (*token_metadata.Metadata, error) {
+	var collection metaplex_core.CollectionV1
+	err := collection.UnmarshalWithDecoder(bin.NewBinDecoder(data))
+	if err != nil {
+		return nil, err
+	}
+
+	tMeta := token_metadata.Metadata{
+		Protocol:        token_metadata.ProtocolMetaplexCore,
+		Mint:            key,
+		UpdateAuthority: collection.UpdateAuthority,
+		Data: token_metadata.Data{
+			Name: strings.Trim(collection.Name, "\x00"),
+			Uri:  strings.Trim(collection.Uri, "\x00"),
+		},
+	}
+
+	tMeta.CoreRoyalties, err = metaplex_core.FindRoyalties(data)
+	if err != nil {
+		log.Printf("%s core plugins err: %s", key, err)
+	}
+	return &tMeta, nil
+}
+
+// CoreRoyalties returns the royalties plugin of a Metaplex Core asset or collection, nil when it has none
+func (svc *SolanaService) CoreRoyalties(key solana.PublicKey) (*metaplex_core.Royalties, error) {
+	acc, err := svc.client.GetAccountInfoWithOpts(ctx.TODO(), key, &rpc.GetAccountInfoOpts{Commitment: svc.commitments.Metadata})
+	if err != nil {
+		return nil, err
+	}
+	if acc.Value.Owner != nft_proxy.METAPLEX_CORE {
+		return nil, metaplex_core.ErrInvalidPlugins
+	}
+	return metaplex_core.FindRoyalties(acc.Value.Data.GetBinary())
+}
+
+func (svc *SolanaService) decodeLibreplexMetadata(data []byte) (*token_metadata.Metadata, error) {
+	var meta libreplex.Metadata
+	err := meta.UnmarshalWithDecoder(bin.NewBinDecoder(data))
+	if err != nil {
+		return nil, err
+	}
+
+	tMeta := token_metadata.Metadata{
+		Protocol:        token_metadata.ProtocolLibreplex,
+		UpdateAuthority: meta.UpdateAuthority,
+		Mint:            meta.Mint,
+		IsMutable:       meta.IsMutable,
+		Data: token_metadata.Data{
+			Name:   strings.Trim(meta.Name, "\x00"),
+			Symbol: strings.Trim(meta.Symbol, "\x00"),
+		},
+	}
+
+	switch meta.Asset.Type {
+	case libreplex.AssetJson:
+		tMeta.Data.Uri = strings.Trim(meta.Asset.Url, "\x00")
+	case libreplex.AssetImage:
+		tMeta.Data.Uri = strings.Trim(meta.Asset.Url, "\x00")
+		tMeta.UriIsImage = true
+	}
+
+	//Members can only be added to a group by its update authority
+	if meta.Group != nil {
+		tMeta.Collection = &metaplex_token_metadata.Collection{Verified: true, Key: *meta.Group}
+	}
+
 	return &tMeta, nil
 }
 
+// tokenStandardOf returns the token standard recorded in the metaplex metadata, empty when it has none
+func tokenStandardOf(meta *token_metadata.Metadata) nft_proxy.TokenStandard {
+	if meta.TokenStandard == nil {
+		return ""
+	}
+
+	switch *meta.TokenStandard {
+	case token_metadata.TokenStandardNonFungible:
+		return nft_proxy.TokenStandardNonFungible
+	case token_metadata.TokenStandardFungibleAsset:
+		return nft_proxy.TokenStandardFungibleAsset
+	case token_metadata.TokenStandardFungible:
+		return nft_proxy.TokenStandardFungible
+	case token_metadata.TokenStandardNonFungibleEdition:
+		return nft_proxy.TokenStandardNonFungibleEdition
+	case token_metadata.TokenStandardProgrammableNonFungible:
+		return nft_proxy.TokenStandardProgrammableNonFungible
+	case token_metadata.TokenStandardProgrammableNonFungibleEdition:
+		return nft_proxy.TokenStandardProgrammableNonFungibleEdition
+	}
+	return ""
+}
+
+// editionOf maps the edition accounts of a mint onto the api response
+func editionOf(meta *token_metadata.Metadata) *nft_proxy.Edition {
+	if meta.Edition == nil {
+		return nil
+	}
+
+	if meta.Edition.IsMaster() {
+		supply := meta.Edition.Supply
+		return &nft_proxy.Edition{Type: nft_proxy.EditionMaster, Supply: &supply, MaxSupply: meta.Edition.MaxSupply}
+	}
+
+	edition := nft_proxy.Edition{Type: nft_proxy.EditionPrint, Number: meta.Edition.Number, MasterEdition: meta.Edition.Parent.String()}
+	if meta.MasterEdition != nil {
+		supply := meta.MasterEdition.Supply
+		edition.Supply = &supply
+		edition.MaxSupply = meta.MasterEdition.MaxSupply
+	}
+	return &edition
+}
+
+// tokenExtensions maps decoded mint extensions onto the api response
+func tokenExtensions(exts *token_extensions.Extensions) *nft_proxy.TokenExtensions {
+	if exts == nil {
+		return nil
+	}
+
+	res := nft_proxy.TokenExtensions{
+		NonTransferable:   exts.NonTransferable,
+		PermanentDelegate: pkString(exts.PermanentDelegate),
+	}
+
+	if exts.TokenMetadata != nil {
+		for _, f := range exts.TokenMetadata.AdditionalMetadata {
+			res.AdditionalMetadata = append(res.AdditionalMetadata, nft_proxy.MetadataField{Key: f.Key, Value: f.Value})
+		}
+	}
+	if c := exts.TransferFeeConfig; c != nil {
+		res.TransferFee = &nft_proxy.TransferFeeConfig{
+			ConfigAuthority:   pkString(c.ConfigAuthority),
+			WithdrawAuthority: pkString(c.WithdrawAuthority),
+			Older:             nft_proxy.TransferFee(c.OlderTransferFee),
+			Newer:             nft_proxy.TransferFee(c.NewerTransferFee),
+		}
+	}
+	if exts.TransferHook != nil {
+		res.TransferHookProgram = pkString(exts.TransferHook.ProgramId)
+	}
+	if exts.MetadataPointer != nil {
+		res.MetadataPointer = pkString(exts.MetadataPointer.Address)
+	}
+	if exts.GroupPointer != nil {
+		res.GroupPointer = pkString(exts.GroupPointer.Address)
+	}
+	if exts.GroupMemberPointer != nil {
+		res.GroupMemberPointer = pkString(exts.GroupMemberPointer.Address)
+	}
+	if exts.TokenGroup != nil {
+		res.Group = &nft_proxy.TokenGroup{Size: exts.TokenGroup.Size, MaxSize: exts.TokenGroup.MaxSize}
+	}
+	if exts.TokenGroupMember != nil {
+		res.GroupMember = &nft_proxy.TokenGroupMember{Group: exts.TokenGroupMember.Group.String(), MemberNumber: exts.TokenGroupMember.MemberNumber}
+	}
+	return &res
+}
+
 func (svc *SolanaService) CreatorKeys(tokenMint solana.PublicKey) ([]solana.PublicKey, error) {
 	metadata, _, err := svc.TokenData(tokenMint)
 	if err != nil {
@@ -190,17 +569,50 @@ func (svc *SolanaService) CreatorKeys(tokenMint solana.PublicKey) ([]solana.Publ
 		return nil, err
 	}
 
-	if metadata.Data.Creators == nil {
+	if len(metadata.Data.Creators) == 0 {
 		return nil, errors.New("unable to find creators")
 	}
 
-	creatorKeys := make([]solana.PublicKey, len(*metadata.Data.Creators))
-	for i, c := range *metadata.Data.Creators {
+	creatorKeys := make([]solana.PublicKey, len(metadata.Data.Creators))
+	for i, c := range metadata.Data.Creators {
 		creatorKeys[i] = c.Address
 	}
 	return creatorKeys, nil
 }
 
+// TransactionAccounts returns every account referenced by the transaction sig, including those loaded from lookup tables
+func (svc *SolanaService) TransactionAccounts(sig solana.Signature) ([]solana.PublicKey, error) {
+	commitment := svc.commitments.Metadata
+	if commitment == rpc.CommitmentProcessed {
+		commitment = rpc.CommitmentConfirmed //Not supported by getTransaction
+	}
+
+	maxVersion := uint64(0)
+	res, err := svc.Client().GetTransaction(ctx.TODO(), sig, &rpc.GetTransactionOpts{
+		Encoding:                       solana.EncodingBase64,
+		Commitment:                     commitment,
+		MaxSupportedTransactionVersion: &maxVersion,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if res.Transaction == nil {
+		return nil, rpc.ErrNotFound
+	}
+
+	tx, err := res.Transaction.GetTransaction()
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := append([]solana.PublicKey{}, tx.Message.AccountKeys...)
+	if res.Meta != nil {
+		accounts = append(accounts, res.Meta.LoadedAddresses.Writable...)
+		accounts = append(accounts, res.Meta.LoadedAddresses.ReadOnly...)
+	}
+	return accounts, nil
+}
+
 // FindTokenMetadataAddress returns the token metadata program-derived address given a SPL token mint address.
 func (svc *SolanaService) FindTokenMetadataAddress(mint solana.PublicKey, metadataProgam solana.PublicKey) (solana.PublicKey, uint8, error) {
 	seed := [][]byte{
@@ -2,22 +2,27 @@ package services
 
 import (
 	"encoding/json"
+	"errors"
 	nft_proxy "github.com/alphabatem/nft-proxy"
+	"github.com/alphabatem/nft-proxy/config"
 	token_metadata "github.com/alphabatem/nft-proxy/token-metadata"
 	"github.com/babilu-online/common/context"
 	"github.com/gagliardetto/solana-go"
 	"gorm.io/gorm/clause"
-	"io"
 	"log"
 	"net/http"
 	"strings"
-	"time"
 )
 
 type SolanaImageService struct {
 	context.DefaultService
-	sql *SqliteService
-	sol *SolanaService
+	Config *config.Config
+
+	sql     *SqliteService
+	sol     *SolanaService
+	store   *StoreService
+	gateway *GatewayService
+	stats   *StatService
 
 	http *http.Client
 }
@@ -29,10 +34,17 @@ func (svc SolanaImageService) Id() string {
 }
 
 func (svc *SolanaImageService) Start() error {
-	svc.http = &http.Client{Timeout: 5 * time.Second}
+	if svc.Config == nil {
+		return config.ErrMissingConfig
+	}
 
 	svc.sql = svc.DefaultService(SQLITE_SVC).(*SqliteService)
 	svc.sol = svc.DefaultService(SOLANA_SVC).(*SolanaService)
+	svc.store = svc.DefaultService(STORE_SVC).(*StoreService)
+	svc.gateway = svc.DefaultService(GATEWAY_SVC).(*GatewayService)
+	svc.stats = svc.DefaultService(STAT_SVC).(*StatService)
+
+	svc.http = withRetries(newOutboundClient(svc.Config.Media.MetadataTimeout), retryPolicyFrom(originRetryPolicy, svc.Config.Retry.Origin), svc.stats)
 	return nil
 }
 
@@ -51,16 +63,22 @@ func (svc *SolanaImageService) Media(key string, skipCache bool) (*nft_proxy.Med
 }
 
 func (svc *SolanaImageService) RemoveMedia(key string) error {
-	return svc.sql.Db().Delete(&nft_proxy.SolanaMedia{}, "mint = ?", key).Error
+	err := svc.sql.Db().Delete(&nft_proxy.SolanaMedia{}, "mint = ?", key).Error
+	if err != nil {
+		return err
+	}
+
+	//Drop our reference to the shared original, removed once no other mint uses it
+	return svc.store.Release(key)
 }
 
 func (svc *SolanaImageService) FetchMetadata(key string) (*nft_proxy.SolanaMedia, error) {
-	metadata, err := svc._retrieveMetadata(key)
+	metadata, slot, err := svc._retrieveMetadata(key)
 	if err != nil {
 		return nil, err
 	}
 
-	media, err := svc.cache(key, metadata, "")
+	media, err := svc.cache(key, metadata, "", slot)
 	if err != nil {
 		return nil, err
 	}
@@ -68,35 +86,62 @@ func (svc *SolanaImageService) FetchMetadata(key string) (*nft_proxy.SolanaMedia
 	return media, nil
 }
 
-func (svc *SolanaImageService) _retrieveMetadata(key string) (*nft_proxy.NFTMetadataSimple, error) {
+// _retrieveMetadata returns the metadata of key & the slot its on-chain accounts were read at
+func (svc *SolanaImageService) _retrieveMetadata(key string) (*nft_proxy.NFTMetadataSimple, uint64, error) {
 	pk, err := solana.PublicKeyFromBase58(key)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
+	}
+	tokenData, decimals, slot, err := svc.sol.TokenDataSlot(pk)
+	if errors.Is(err, ErrTokenDataNotFound) {
+		//No account holds the metadata, compressed NFTs only exist in their merkle tree
+		asset, dasErr := svc.sol.Asset(pk)
+		if dasErr == nil {
+			return svc.assetMetadata(asset), 0, nil
+		}
+		log.Printf("No DAS asset for %s - %s", pk, dasErr)
 	}
-	tokenData, decimals, err := svc.sol.TokenData(pk)
 	if err != nil || tokenData == nil {
 		log.Printf("No token data for %s - %s", pk, err)
-		return nil, err
+		return nil, 0, err
 	}
 
-	//log.Printf("TokenData retreive (%v): %+v\n", decimals, tokenData)
+	metadata := svc.onChainMetadata(tokenData, decimals)
+	metadata.Extensions = tokenExtensions(tokenData.Extensions)
+	metadata.TokenStandard = tokenStandardOf(tokenData)
+	metadata.Edition = editionOf(tokenData)
+	return metadata, slot, nil
+}
 
+// onChainMetadata maps the on-chain metadata of a token, reading its metadata file when it has one
+func (svc *SolanaImageService) onChainMetadata(tokenData *token_metadata.Metadata, decimals uint8) *nft_proxy.NFTMetadataSimple {
 	switch tokenData.Protocol {
-	case token_metadata.PROTOCOL_METAPLEX_CORE:
+	case token_metadata.ProtocolMetaplexCore:
 		return &nft_proxy.NFTMetadataSimple{
 			Image:           tokenData.Data.Uri,
 			Decimals:        decimals,
 			Name:            strings.Trim(tokenData.Data.Name, "\x00"),
 			Symbol:          strings.Trim(tokenData.Data.Symbol, "\x00"),
 			UpdateAuthority: tokenData.UpdateAuthority.String(),
-		}, nil
+		}
+	case token_metadata.ProtocolLibreplex:
+		if tokenData.UriIsImage {
+			return &nft_proxy.NFTMetadataSimple{
+				Image:           tokenData.Data.Uri,
+				Decimals:        decimals,
+				Name:            tokenData.Data.Name,
+				Symbol:          tokenData.Data.Symbol,
+				UpdateAuthority: tokenData.UpdateAuthority.String(),
+			}
+		}
+		fallthrough
 	default:
 		//Get file meta if possible
 		f, err := svc.retrieveFile(tokenData.Data.Uri)
 		if f != nil {
 			f.Decimals = decimals
 			f.UpdateAuthority = tokenData.UpdateAuthority.String()
-			return f, nil
+			return f
 		}
 		log.Printf("(%s) retrieveFile err: %s", tokenData.Data.Uri, err)
 	}
@@ -107,25 +152,102 @@ func (svc *SolanaImageService) _retrieveMetadata(key string) (*nft_proxy.NFTMeta
 		Decimals:        decimals,
 		Symbol:          strings.Trim(tokenData.Data.Symbol, "\x00"),
 		UpdateAuthority: tokenData.UpdateAuthority.String(),
-	}, nil
+	}
 }
 
-func (svc *SolanaImageService) retrieveFile(uri string) (*nft_proxy.NFTMetadataSimple, error) {
-	file, err := svc.http.Get(strings.Trim(uri, "\x00")) //Strip crap off urls
+// FetchMetadataBatch fetches & caches the metadata of keys, returning nil entries for keys that failed.
+// Keys without an account are looked up in a single DAS getAssetBatch call rather than one by one.
+func (svc *SolanaImageService) FetchMetadataBatch(keys []string) ([]*nft_proxy.SolanaMedia, error) {
+	pks := make([]solana.PublicKey, len(keys))
+	for i, key := range keys {
+		pk, err := solana.PublicKeyFromBase58(key)
+		if err != nil {
+			return nil, err
+		}
+		pks[i] = pk
+	}
+
+	exists, err := svc.sol.AccountsExist(pks)
 	if err != nil {
 		return nil, err
 	}
 
-	if file.StatusCode != 200 {
+	media := make([]*nft_proxy.SolanaMedia, len(keys))
+	var missing []int
+	for i, key := range keys {
+		if !exists[i] {
+			missing = append(missing, i)
+			continue
+		}
+
+		media[i], err = svc.FetchMetadata(key)
+		if err != nil {
+			log.Printf("FetchMetadataBatch - %s err: %s", key, err)
+		}
+	}
+	if len(missing) == 0 {
+		return media, nil
+	}
+
+	ids := make([]solana.PublicKey, len(missing))
+	for i, idx := range missing {
+		ids[i] = pks[idx]
+	}
+	assets, err := svc.sol.AssetBatch(ids)
+	if err != nil {
 		return nil, err
 	}
 
-	defer file.Body.Close()
-	data, err := io.ReadAll(file.Body)
+	for i, asset := range assets {
+		if asset == nil {
+			continue
+		}
+
+		idx := missing[i]
+		media[idx], err = svc.cache(keys[idx], svc.assetMetadata(asset), "", 0)
+		if err != nil {
+			log.Printf("FetchMetadataBatch - %s err: %s", keys[idx], err)
+		}
+	}
+	return media, nil
+}
+
+// assetMetadata maps a DAS asset, reading the off-chain file when DAS has not indexed its image
+func (svc *SolanaImageService) assetMetadata(asset *DASAsset) *nft_proxy.NFTMetadataSimple {
+	metadata := asset.Metadata()
+	if metadata.Image != "" || asset.Content.JsonUri == "" {
+		return metadata
+	}
+
+	f, err := svc.retrieveFile(asset.Content.JsonUri)
+	if f == nil {
+		log.Printf("(%s) retrieveFile err: %s", asset.Content.JsonUri, err)
+		return metadata
+	}
+	f.Decimals = metadata.Decimals
+	f.UpdateAuthority = metadata.UpdateAuthority
+	return f
+}
+
+func (svc *SolanaImageService) retrieveFile(uri string) (*nft_proxy.NFTMetadataSimple, error) {
+	//On-chain collections keep their metadata in the uri field itself
+	data, inline, err := inlineMetadata(uri)
 	if err != nil {
 		return nil, err
 	}
 
+	if !inline {
+		file, err := svc.gateway.Get(svc.http, strings.Trim(uri, "\x00"), nil) //Strip crap off urls
+		if err != nil {
+			return nil, err
+		}
+		defer file.Body.Close()
+		data, err = readResponse(file, maxMetadataBytes, metadataContentTypes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	var metadata nft_proxy.NFTMetadataSimple
 	err = json.Unmarshal(data, &metadata)
 	if err != nil {
@@ -135,10 +257,12 @@ func (svc *SolanaImageService) retrieveFile(uri string) (*nft_proxy.NFTMetadataS
 	return &metadata, nil
 }
 
-func (svc *SolanaImageService) cache(key string, metadata *nft_proxy.NFTMetadataSimple, localPath string) (*nft_proxy.SolanaMedia, error) {
+// cache upserts the metadata of key, keeping the stored row if it was read at a later slot
+func (svc *SolanaImageService) cache(key string, metadata *nft_proxy.NFTMetadataSimple, localPath string, slot uint64) (*nft_proxy.SolanaMedia, error) {
 	media := nft_proxy.SolanaMedia{
 		Mint:      key,
 		LocalPath: localPath,
+		Slot:      slot,
 	}
 
 	//log.Printf("Metadata: %+v\n", metadata)
@@ -149,6 +273,9 @@ func (svc *SolanaImageService) cache(key string, metadata *nft_proxy.NFTMetadata
 		media.ImageType = svc.guessImageType(metadata)
 		media.UpdateAuthority = metadata.UpdateAuthority
 		media.MintDecimals = metadata.Decimals
+		media.TokenStandard = metadata.TokenStandard
+		media.Edition = metadata.Edition
+		media.Extensions = metadata.Extensions
 
 		mediaFile := metadata.AnimationFile()
 		if mediaFile != nil {
@@ -160,10 +287,29 @@ func (svc *SolanaImageService) cache(key string, metadata *nft_proxy.NFTMetadata
 		}
 	}
 
-	return &media, svc.sql.Db().Clauses(clause.OnConflict{
+	res := svc.sql.Db().Clauses(clause.OnConflict{
 		Columns:   []clause.Column{{Name: "mint"}}, // key colum
 		UpdateAll: true,
-	}).Create(&media).Error
+		//Rows from an unknown slot are always replaced, a lagging node must not roll back newer data
+		Where: clause.Where{Exprs: []clause.Expression{
+			clause.Expr{SQL: "excluded.slot > solana_media.slot OR solana_media.slot = 0"},
+		}},
+	}).Create(&media)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		log.Printf("Keeping %s metadata from a later slot than %v", key, slot)
+		var current nft_proxy.SolanaMedia
+		err := svc.sql.Db().First(&current, "mint = ?", key).Error
+		if err != nil {
+			return nil, err
+		}
+		return &current, nil
+	}
+
+	return &media, nil
 }
 
 func (svc *SolanaImageService) guessImageType(metadata *nft_proxy.NFTMetadataSimple) string {
@@ -172,6 +318,14 @@ func (svc *SolanaImageService) guessImageType(metadata *nft_proxy.NFTMetadataSim
 	}
 
 	imageType := ""
+	if isDataURI(metadata.Image) {
+		imageType = dataURIImageType(metadata.Image)
+		if !svc.ValidType(imageType) {
+			return "jpg"
+		}
+		return imageType
+	}
+
 	imgFile := metadata.ImageFile()
 	if imgFile != nil && strings.Contains(imgFile.Type, "/") {
 		imageType = strings.Split(imgFile.Type, "/")[1]
@@ -209,6 +363,8 @@ func (svc *SolanaImageService) ValidType(imageType string) bool {
 		return true
 	case "gif":
 		return true
+	case "webp":
+		return true
 	case "svg":
 		return true
 	default:
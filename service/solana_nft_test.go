@@ -0,0 +1,87 @@
+package services
+
+import (
+	"testing"
+
+	nft_proxy "github.com/alphabatem/nft-proxy"
+	"github.com/alphabatem/nft-proxy/config"
+	"github.com/gagliardetto/solana-go/rpc"
+)
+
+func TestSolanaImageService_CacheSlot(t *testing.T) {
+	svc := SolanaImageService{sql: newTestSqlite(t)}
+	mint := "CJ9AXYbSUPoR95oMvWzgCV3GbG3ZubQjFUpRHN7xqAVb"
+
+	cache := func(t *testing.T, name string, slot uint64) *nft_proxy.SolanaMedia {
+		t.Helper()
+
+		media, err := svc.cache(mint, &nft_proxy.NFTMetadataSimple{Name: name, Image: "https://example.com/1.png"}, "", slot)
+		if err != nil {
+			t.Fatalf("Failed to cache metadata: %v", err)
+		}
+		return media
+	}
+
+	t.Run("Unknown Slot", func(t *testing.T) {
+		cache(t, "legacy", 0)
+		if media := cache(t, "first", 100); media.Name != "first" || media.Slot != 100 {
+			t.Fatalf("Expected row without a slot to be replaced, got %+v", media)
+		}
+	})
+
+	t.Run("Later Slot", func(t *testing.T) {
+		if media := cache(t, "revealed", 200); media.Name != "revealed" {
+			t.Fatalf("Expected later slot to overwrite, got %+v", media)
+		}
+	})
+
+	t.Run("Earlier Slot", func(t *testing.T) {
+		media := cache(t, "rolled back", 150)
+		if media.Name != "revealed" || media.Slot != 200 {
+			t.Fatalf("Expected earlier slot to keep the stored row, got %+v", media)
+		}
+
+		var stored nft_proxy.SolanaMedia
+		svc.sql.Db().First(&stored, "mint = ?", mint)
+		if stored.Name != "revealed" {
+			t.Fatalf("Expected stored row to be untouched, got %+v", stored)
+		}
+
+		if media := cache(t, "unknown", 0); media.Name != "revealed" {
+			t.Fatalf("Expected data from an unknown slot not to overwrite, got %+v", media)
+		}
+	})
+}
+
+func TestRPCCommitmentsFrom(t *testing.T) {
+	if defaultRPCCommitments.Metadata != rpc.CommitmentConfirmed || defaultRPCCommitments.Blockhash != rpc.CommitmentFinalized {
+		t.Fatalf("Unexpected default commitments %+v", defaultRPCCommitments)
+	}
+
+	c := rpcCommitmentsFrom(config.Commitments{Metadata: "finalized", Blockhash: "confirmed"})
+	if c.Metadata != rpc.CommitmentFinalized || c.Blockhash != rpc.CommitmentConfirmed {
+		t.Fatalf("Unexpected commitments %+v", c)
+	}
+}
+
+func TestSolanaImageService_CacheExtensions(t *testing.T) {
+	svc := SolanaImageService{sql: newTestSqlite(t)}
+	mint := "CJ9AXYbSUPoR95oMvWzgCV3GbG3ZubQjFUpRHN7xqAVb"
+
+	exts := &nft_proxy.TokenExtensions{
+		NonTransferable:    true,
+		AdditionalMetadata: []nft_proxy.MetadataField{{Key: "rarity", Value: "legendary"}},
+		TransferFee:        &nft_proxy.TransferFeeConfig{Newer: nft_proxy.TransferFee{Epoch: 200, BasisPoints: 75}},
+	}
+	_, err := svc.cache(mint, &nft_proxy.NFTMetadataSimple{Name: "fee", Extensions: exts}, "", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stored nft_proxy.SolanaMedia
+	svc.sql.Db().First(&stored, "mint = ?", mint)
+	media := stored.Media()
+	if media.Extensions == nil || !media.Extensions.NonTransferable || media.Extensions.TransferFee.Newer.BasisPoints != 75 || media.Extensions.AdditionalMetadata[0].Value != "legendary" {
+		t.Fatalf("Expected extensions to be stored with the media row, got %+v", media.Extensions)
+	}
+}
@@ -1,89 +1,399 @@
 package services
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	nft_proxy "github.com/alphabatem/nft-proxy"
+	"github.com/alphabatem/nft-proxy/config"
+	"github.com/alphabatem/nft-proxy/libreplex"
+	token_extensions "github.com/alphabatem/nft-proxy/token-extensions"
+	token_metadata "github.com/alphabatem/nft-proxy/token-metadata"
 	"github.com/gagliardetto/solana-go"
-	"github.com/joho/godotenv"
-	"log"
-	"os"
+	"github.com/gagliardetto/solana-go/rpc"
+	"strings"
 	"testing"
 )
 
-// Add more tests in here
-
-func init() {
-	err := godotenv.Load("../.env")
-	if err != nil {
-		log.Print("Error loading .env file")
-	}
-}
+// Mints recorded under testdata/rpc, replayed by newRPCReplay
+var (
+	replayLegacyMint    = solana.MustPublicKeyFromBase58("5AtF9aBZZHiRtKZfDu7sfPz1dtxPF2gYZCQQAMWWM4QH")
+	replayToken2022Mint = solana.MustPublicKeyFromBase58("2Bj4xDXyCob6zLrUD8fKnrbHxmzNaDjq784CVbtoaz6C")
+	replayCoreAsset     = solana.MustPublicKeyFromBase58("32ycN8ja443MfynwcrqGqRJHe5qBn91Rv5VSzRaudj8u")
+)
 
-// Helper function to initialize the service with RPC_URL check
-func initSolanaService() (*SolanaService, error) {
-	svc := SolanaService{}
-	err := svc.Start()
-	if err != nil {
-		return nil, err
-	}
-	return &svc, nil
+// newReplaySolana returns a SolanaService reading from the recorded fixture
+func newReplaySolana(t *testing.T, fixture string) *SolanaService {
+	r := newRPCReplay(t, fixture)
+	return &SolanaService{client: rpc.New(r.URL), commitments: defaultRPCCommitments}
 }
 
 func TestSolanaService_FetchMetadata(t *testing.T) {
-	// Initialize SolanaService
-	svc, err := initSolanaService()
-	if err != nil {
-		t.Fatalf("Failed to start SolanaService: %v", err)
-	}
+	t.Run("Legacy", func(t *testing.T) {
+		svc := newReplaySolana(t, "token_data_legacy")
+		meta, decimals, err := svc.TokenData(replayLegacyMint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if meta.Protocol != token_metadata.ProtocolLegacy || meta.Mint != replayLegacyMint || decimals != 0 {
+			t.Fatalf("Unexpected metadata %+v", meta)
+		}
+		if strings.TrimRight(meta.Data.Name, "\x00") != "Replay Legacy #1" || meta.Data.SellerFeeBasisPoints != 500 || len(meta.Data.Creators) != 1 {
+			t.Fatalf("Unexpected data %+v", meta.Data)
+		}
+		if meta.Edition == nil || !meta.Edition.IsMaster() || meta.Edition.MaxSupply == nil || *meta.Edition.MaxSupply != 0 {
+			t.Fatalf("Expected a master edition without prints, got %+v", meta.Edition)
+		}
+	})
+
+	t.Run("Token-2022", func(t *testing.T) {
+		svc := newReplaySolana(t, "token_data_token2022")
+		meta, decimals, err := svc.TokenData(replayToken2022Mint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if meta.Protocol != token_metadata.ProtocolToken22Mint || meta.Data.Name != "Replay Token" || meta.Data.Symbol != "RPLY" || decimals != 6 {
+			t.Fatalf("Unexpected metadata %+v (decimals %d)", meta, decimals)
+		}
+		if meta.Extensions == nil || meta.Extensions.TokenMetadata == nil || len(meta.Extensions.TokenMetadata.AdditionalMetadata) != 1 || meta.Extensions.TokenMetadata.AdditionalMetadata[0] != (token_extensions.MetadataField{Key: "tier", Value: "gold"}) {
+			t.Fatalf("Unexpected extensions %+v", meta.Extensions)
+		}
+	})
 
-	// Test valid public key
-	t.Run("Valid Public Key", func(t *testing.T) {
-		pk := solana.MustPublicKeyFromBase58("CJ9AXYbSUPoR95oMvWzgCV3GbG3ZubQjFUpRHN7xqAVb")
-		data, _, err := svc.TokenData(pk)
+	t.Run("Core", func(t *testing.T) {
+		svc := newReplaySolana(t, "token_data_core")
+		meta, _, err := svc.TokenData(replayCoreAsset)
 		if err != nil {
-			t.Fatalf("Error fetching token data for valid public key: %v", err)
+			t.Fatal(err)
+		}
+		if meta.Protocol != token_metadata.ProtocolMetaplexCore || meta.Data.Name != "Replay Core #3" || meta.Data.Uri != "https://example.com/core-3.json" {
+			t.Fatalf("Unexpected metadata %+v", meta)
 		}
-		if data == nil {
-			t.Fatal("Expected valid metadata, got nil")
+		if meta.CoreRoyalties == nil || meta.CoreRoyalties.BasisPoints != 750 {
+			t.Fatalf("Unexpected royalties %+v", meta.CoreRoyalties)
 		}
-		t.Logf("Metadata: %+v\n", data)
 	})
 
-	// Test invalid public key
 	t.Run("Invalid Public Key", func(t *testing.T) {
-		invalidPk := solana.MustPublicKeyFromBase58("invalidPublicKey1234567890")
-		_, _, err := svc.TokenData(invalidPk)
-		if err == nil {
+		if _, err := solana.PublicKeyFromBase58("invalidPublicKey1234567890"); err == nil {
 			t.Fatal("Expected error for invalid public key, got none")
 		}
-		t.Logf("Error for invalid public key: %v\n", err)
 	})
 
-	// Test empty public key
 	t.Run("Empty Public Key", func(t *testing.T) {
-		emptyPk := solana.PublicKey{}
-		_, _, err := svc.TokenData(emptyPk)
-		if err == nil {
-			t.Fatal("Expected error for empty public key, got none")
+		svc := newReplaySolana(t, "token_data_empty")
+		_, _, err := svc.TokenData(solana.PublicKey{})
+		if !errors.Is(err, ErrTokenDataNotFound) {
+			t.Fatalf("Expected ErrTokenDataNotFound for empty public key, got %v", err)
 		}
-		t.Logf("Error for empty public key: %v\n", err)
 	})
 }
 
-// Test for environment loading and service initialization
 func TestSolanaService_Init(t *testing.T) {
-	err := godotenv.Load("../.env")
-	if err != nil {
-		t.Fatal("Error loading .env file")
+	t.Run("No Config", func(t *testing.T) {
+		svc := SolanaService{}
+		if err := svc.Start(); !errors.Is(err, config.ErrMissingConfig) {
+			t.Fatalf("Expected ErrMissingConfig, got %v", err)
+		}
+	})
+
+	t.Run("No Endpoints", func(t *testing.T) {
+		svc := SolanaService{Config: config.Default()}
+		if err := svc.Start(); err == nil {
+			t.Fatal("Expected an error without rpc endpoints")
+		}
+	})
+}
+
+// libreplexAccount encodes a libreplex metadata account, asset is the borsh encoded Asset enum
+func libreplexAccount(mint, authority solana.PublicKey, group *solana.PublicKey, name, symbol string, asset []byte) *rpc.Account {
+	var buf bytes.Buffer
+	str := func(s string) {
+		binary.Write(&buf, binary.LittleEndian, uint32(len(s)))
+		buf.WriteString(s)
+	}
+
+	buf.Write(libreplex.MetadataDiscriminator)
+	buf.Write(mint[:])
+	buf.Write(authority[:])
+	buf.Write(authority[:]) //Creator
+	buf.WriteByte(1)        //Mutable
+	if group != nil {
+		buf.WriteByte(1)
+		buf.Write(group[:])
+	} else {
+		buf.WriteByte(0)
 	}
+	str(name)
+	str(symbol)
+	buf.Write(asset)
+	buf.Write([]byte{0, 0, 0, 0}) //No extensions
+
+	return &rpc.Account{Owner: libreplex.ProgramID, Data: rpc.DataBytesOrJSONFromBytes(buf.Bytes())}
+}
+
+func TestSolanaService_DecodeLibreplex(t *testing.T) {
+	svc := SolanaService{}
+	mint := solana.NewWallet().PublicKey()
+	authority := solana.NewWallet().PublicKey()
+	group := solana.NewWallet().PublicKey()
 
-	rpcURL := os.Getenv("RPC_URL")
-	if rpcURL == "" {
-		t.Fatal("RPC_URL not set in .env file")
+	rustString := func(variant byte, s string, extra ...byte) []byte {
+		b := []byte{variant, byte(len(s)), 0, 0, 0}
+		return append(append(b, s...), extra...)
 	}
 
-	svc, err := initSolanaService()
+	t.Run("Json", func(t *testing.T) {
+		acc := libreplexAccount(mint, authority, &group, "Libre #1", "LIBRE", rustString(byte(libreplex.AssetJson), "https://example.com/1.json"))
+		meta, _, err := svc.decodeTokenData(mint, []*rpc.Account{nil, nil, nil, acc})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if meta.Protocol != token_metadata.ProtocolLibreplex || meta.Data.Name != "Libre #1" || meta.Data.Symbol != "LIBRE" || meta.Data.Uri != "https://example.com/1.json" || meta.UriIsImage {
+			t.Fatalf("Unexpected metadata %+v", meta)
+		}
+		if meta.UpdateAuthority != authority || meta.Mint != mint {
+			t.Fatalf("Unexpected authority %s or mint %s", meta.UpdateAuthority, meta.Mint)
+		}
+		if meta.Collection == nil || meta.Collection.Key != group {
+			t.Fatalf("Expected group membership, got %+v", meta.Collection)
+		}
+	})
+
+	t.Run("Image", func(t *testing.T) {
+		acc := libreplexAccount(mint, authority, nil, "Libre #2", "LIBRE", rustString(byte(libreplex.AssetImage), "https://example.com/2.png", 1, 4, 0, 0, 0, 'd', 'e', 's', 'c'))
+		meta, _, err := svc.decodeTokenData(mint, []*rpc.Account{nil, nil, nil, acc})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if meta.Data.Uri != "https://example.com/2.png" || !meta.UriIsImage || meta.Collection != nil {
+			t.Fatalf("Unexpected metadata %+v", meta)
+		}
+	})
+
+	t.Run("Wrong Account", func(t *testing.T) {
+		acc := libreplexAccount(mint, authority, nil, "Libre #3", "LIBRE", []byte{0})
+		acc.Data = rpc.DataBytesOrJSONFromBytes(append([]byte{1, 2, 3, 4, 5, 6, 7, 8}, acc.Data.GetBinary()[8:]...))
+		if _, _, err := svc.decodeTokenData(mint, []*rpc.Account{nil, nil, nil, acc}); err == nil {
+			t.Fatal("Expected account without the metadata discriminator to be rejected")
+		}
+	})
+}
+
+// token2022Mint encodes a Token-2022 mint account followed by the given TLV extensions
+func token2022Mint(decimals uint8, extensions ...[]byte) []byte {
+	data := make([]byte, 166)
+	data[44] = decimals
+	data[45] = 1  //Initialized
+	data[165] = 1 //Mint account type
+	for _, ext := range extensions {
+		data = append(data, ext...)
+	}
+	return data
+}
+
+func tlv(typ uint16, value []byte) []byte {
+	b := binary.LittleEndian.AppendUint16(nil, typ)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	return append(b, value...)
+}
+
+func TestSolanaService_DecodeToken2022Extensions(t *testing.T) {
+	svc := SolanaService{}
+	mint := solana.NewWallet().PublicKey()
+	authority := solana.NewWallet().PublicKey()
+	delegate := solana.NewWallet().PublicKey()
+	group := solana.NewWallet().PublicKey()
+
+	var fees bytes.Buffer
+	fees.Write(authority[:])
+	fees.Write(make([]byte, 32)) //No withdraw authority
+	binary.Write(&fees, binary.LittleEndian, uint64(5))
+	for _, fee := range []struct {
+		epoch, max uint64
+		bps        uint16
+	}{{100, 1000, 50}, {200, 2000, 75}} {
+		binary.Write(&fees, binary.LittleEndian, fee.epoch)
+		binary.Write(&fees, binary.LittleEndian, fee.max)
+		binary.Write(&fees, binary.LittleEndian, fee.bps)
+	}
+
+	var tokenMeta bytes.Buffer
+	str := func(s string) {
+		binary.Write(&tokenMeta, binary.LittleEndian, uint32(len(s)))
+		tokenMeta.WriteString(s)
+	}
+	tokenMeta.Write(authority[:])
+	tokenMeta.Write(mint[:])
+	str("Fee Token")
+	str("FEE")
+	str("https://example.com/fee.json")
+	binary.Write(&tokenMeta, binary.LittleEndian, uint32(2))
+	str("rarity")
+	str("legendary")
+	str("edition")
+	str("1")
+
+	member := append(append(append([]byte{}, mint[:]...), group[:]...), binary.LittleEndian.AppendUint64(nil, 42)...)
+
+	data := token2022Mint(6,
+		tlv(1, fees.Bytes()),
+		tlv(9, nil),
+		tlv(12, delegate[:]),
+		tlv(19, tokenMeta.Bytes()),
+		tlv(23, member),
+	)
+
+	//Metadata held by the metaplex program, extensions are still read from the mint
+	var metadataAccount bytes.Buffer
+	metadataAccount.WriteByte(4) //MetadataV1
+	metadataAccount.Write(authority[:])
+	metadataAccount.Write(mint[:])
+	for _, v := range []string{"Fee Token", "FEE", "https://example.com/fee.json"} {
+		binary.Write(&metadataAccount, binary.LittleEndian, uint32(len(v)))
+		metadataAccount.WriteString(v)
+	}
+	metadataAccount.Write(make([]byte, 679-metadataAccount.Len())) //Metadata accounts are allocated at their max size
+
+	meta, _, err := svc.decodeTokenData(mint, []*rpc.Account{
+		{Owner: nft_proxy.TOKEN_2022, Data: rpc.DataBytesOrJSONFromBytes(data)},
+		{Owner: solana.TokenMetadataProgramID, Data: rpc.DataBytesOrJSONFromBytes(metadataAccount.Bytes())},
+		nil,
+		nil,
+	})
 	if err != nil {
-		t.Fatalf("Failed to initialize SolanaService: %v", err)
+		t.Fatal(err)
+	}
+	if meta.Extensions == nil {
+		t.Fatalf("Expected extensions to be decoded, got %+v", meta)
+	}
+
+	exts := tokenExtensions(meta.Extensions)
+	if !exts.NonTransferable || exts.PermanentDelegate != delegate.String() {
+		t.Fatalf("Expected transfer restrictions, got %+v", exts)
+	}
+	if fee := exts.TransferFee; fee == nil || fee.ConfigAuthority != authority.String() || fee.WithdrawAuthority != "" || fee.Newer.BasisPoints != 75 || fee.Older.MaximumFee != 1000 {
+		t.Fatalf("Unexpected transfer fee %+v", exts.TransferFee)
+	}
+	if len(exts.AdditionalMetadata) != 2 || exts.AdditionalMetadata[0] != (nft_proxy.MetadataField{Key: "rarity", Value: "legendary"}) {
+		t.Fatalf("Unexpected additional metadata %+v", exts.AdditionalMetadata)
+	}
+	if exts.GroupMember == nil || exts.GroupMember.Group != group.String() || exts.GroupMember.MemberNumber != 42 {
+		t.Fatalf("Unexpected group member %+v", exts.GroupMember)
+	}
+
+	t.Run("Truncated", func(t *testing.T) {
+		if _, err := token_extensions.Decode(data[:len(data)-10]); err == nil {
+			t.Fatal("Expected truncated extensions to be rejected")
+		}
+	})
+
+	t.Run("No Update Authority", func(t *testing.T) {
+		var immutable bytes.Buffer
+		immutable.Write(make([]byte, 32)) //Metadata without an update authority can no longer change
+		immutable.Write(mint[:])
+		for _, v := range []string{"Fixed Token", "FIX", "https://example.com/fixed.json"} {
+			binary.Write(&immutable, binary.LittleEndian, uint32(len(v)))
+			immutable.WriteString(v)
+		}
+		immutable.Write(make([]byte, 4)) //No additional metadata
+
+		meta, _, err := svc.decodeTokenData(mint, []*rpc.Account{
+			{Owner: nft_proxy.TOKEN_2022, Data: rpc.DataBytesOrJSONFromBytes(token2022Mint(0, tlv(19, immutable.Bytes())))},
+			nil,
+			nil,
+			nil,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if meta.Protocol != token_metadata.ProtocolToken22Mint || meta.Data.Name != "Fixed Token" || !meta.UpdateAuthority.IsZero() {
+			t.Fatalf("Unexpected metadata %+v", meta)
+		}
+	})
+
+	t.Run("No Extensions", func(t *testing.T) {
+		exts, err := token_extensions.Decode(make([]byte, 82))
+		if err != nil || exts != nil {
+			t.Fatalf("Expected plain mint to have no extensions, got %+v (%v)", exts, err)
+		}
+	})
+}
+
+func masterEditionAccount(supply uint64, maxSupply *uint64) []byte {
+	data := binary.LittleEndian.AppendUint64([]byte{6}, supply) //MasterEditionV2
+	if maxSupply == nil {
+		return append(data, 0)
 	}
+	return binary.LittleEndian.AppendUint64(append(data, 1), *maxSupply)
+}
+
+func printEditionAccount(parent solana.PublicKey, number uint64) []byte {
+	return binary.LittleEndian.AppendUint64(append([]byte{1}, parent[:]...), number) //EditionV1
+}
+
+func TestSolanaService_Editions(t *testing.T) {
+	standIn := newAccountStandIn()
+	defer standIn.Close()
+	svc := SolanaService{client: rpc.New(standIn.URL), commitments: defaultRPCCommitments}
 
-	t.Logf("SolanaService initialized successfully with RPC_URL: %s", rpcURL)
+	creators := []testCreator{{address: solana.NewWallet().PublicKey(), verified: true, share: 100}}
+	setNFT := func(mint solana.PublicKey, edition []byte) {
+		metadata, _, _ := svc.FindTokenMetadataAddress(mint, solana.TokenMetadataProgramID)
+		editionKey, _, _ := token_metadata.FindEditionAddress(mint)
+		standIn.setMint(mint, solana.TokenProgramID, 1, 0, nil)
+		standIn.setAccount(metadata, solana.TokenMetadataProgramID, programmableMetadataAccount(mint, 500, creators, solana.NewWallet().PublicKey()))
+		standIn.setAccount(editionKey, solana.TokenMetadataProgramID, edition)
+	}
+
+	t.Run("Master", func(t *testing.T) {
+		mint := solana.NewWallet().PublicKey()
+		max := uint64(50)
+		setNFT(mint, masterEditionAccount(12, &max))
+
+		meta, _, _, err := svc.TokenDataSlot(mint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if standard := tokenStandardOf(meta); standard != nft_proxy.TokenStandardProgrammableNonFungible {
+			t.Fatalf("Unexpected token standard %s", standard)
+		}
+		edition := editionOf(meta)
+		if edition == nil || edition.Type != nft_proxy.EditionMaster || *edition.Supply != 12 || *edition.MaxSupply != 50 {
+			t.Fatalf("Unexpected edition %+v", edition)
+		}
+	})
+
+	t.Run("Print", func(t *testing.T) {
+		mint := solana.NewWallet().PublicKey()
+		master := solana.NewWallet().PublicKey()
+		standIn.setAccount(master, solana.TokenMetadataProgramID, masterEditionAccount(7, nil))
+		setNFT(mint, printEditionAccount(master, 3))
+
+		meta, _, _, err := svc.TokenDataSlot(mint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		edition := editionOf(meta)
+		if edition == nil || edition.Type != nft_proxy.EditionPrint || edition.Number != 3 || edition.MasterEdition != master.String() {
+			t.Fatalf("Unexpected edition %+v", edition)
+		}
+		if edition.Supply == nil || *edition.Supply != 7 || edition.MaxSupply != nil {
+			t.Fatalf("Expected the supply of an unlimited master edition, got %+v", edition)
+		}
+	})
+
+	t.Run("Missing Master", func(t *testing.T) {
+		mint := solana.NewWallet().PublicKey()
+		setNFT(mint, printEditionAccount(solana.NewWallet().PublicKey(), 9))
+
+		meta, _, _, err := svc.TokenDataSlot(mint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if edition := editionOf(meta); edition == nil || edition.Number != 9 || edition.Supply != nil {
+			t.Fatalf("Expected a print without supply, got %+v", edition)
+		}
+	})
 }
@@ -10,7 +10,8 @@ import (
     "time"
 
     nft_proxy "github.com/alphabatem/nft-proxy"
-    "github.com/babilu-online/common/context"
+    "github.com/alphabatem/nft-proxy/config"
+    bctx "github.com/babilu-online/common/context"
     "gorm.io/driver/sqlite"
     "gorm.io/gorm"
     "gorm.io/gorm/logger"
@@ -58,7 +59,8 @@ func DefaultConfig() SqliteConfig {
 // SqliteService provides SQLite database operations
 // ISSUE: Original lacked proper connection management and configuration
 type SqliteService struct {
-    context.Context
+    bctx.DefaultService
+    Config *config.Config
     mu     sync.RWMutex
     db     *gorm.DB
     config SqliteConfig
@@ -67,13 +69,13 @@ type SqliteService struct {
 const SQLITE_SVC = "sqlite_svc"
 
 // Id returns Service ID
-func (s SqliteService) Id() string {
+func (s *SqliteService) Id() string {
     return SQLITE_SVC
 }
 
 // Db provides access to raw SqliteService db
 // ISSUE: Original didn't protect against race conditions
-func (s SqliteService) Db() *gorm.DB {
+func (s *SqliteService) Db() *gorm.DB {
     s.mu.RLock()
     defer s.mu.RUnlock()
     return s.db
@@ -81,15 +83,19 @@ func (s SqliteService) Db() *gorm.DB {
 
 // Configure sets up the service
 // ISSUE: Original had minimal configuration and no validation
-func (s *SqliteService) Configure(ctx *context.Context) error {
+func (s *SqliteService) Configure(ctx *bctx.Context) error {
+    if s.Config == nil {
+        return config.ErrMissingConfig
+    }
+
     s.config = DefaultConfig()
-    s.config.Database = os.Getenv("DB_DATABASE")
+    s.config.Database = s.Config.Database.Path
 
     if s.config.Database == "" {
         return ErrDatabaseNotConfigured
     }
 
-    return s.db.Configure(ctx)
+    return s.DefaultService.Configure(ctx)
 }
 
 // Start initializes the database connection and runs migrations
@@ -130,7 +136,7 @@ func (s *SqliteService) Start() error {
     sqlDB.SetConnMaxLifetime(s.config.MaxLifetime)
 
     // Run migrations
-    if err := s.migrate(&nft_proxy.SolanaMedia{}); err != nil {
+    if err := s.migrate(&nft_proxy.SolanaMedia{}, &nft_proxy.MediaOriginal{}, &nft_proxy.MediaBlob{}, &nft_proxy.SolanaToken{}, &nft_proxy.SolanaRoyalties{}); err != nil {
         return fmt.Errorf("failed to run migrations: %w", err)
     }
 
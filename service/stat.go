@@ -2,30 +2,58 @@ package services
 
 import (
 	"log"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	nft_proxy "github.com/alphabatem/nft-proxy"
+	"github.com/alphabatem/nft-proxy/config"
 	"github.com/babilu-online/common/context"
 )
 
 type StatService struct {
 	context.DefaultService
+	Config *config.Config
 
 	imageFilesServed uint64
 	mediaFilesServed uint64
 	requestsServed   uint64
 
-	sql *SqliteService
+	resizeJobs         uint64
+	resizeQueueWait    uint64 //Nanoseconds
+	resizeQueueWaitMax uint64 //Nanoseconds
+	resizeRejected     uint64
+	resizeTimeouts     uint64
+
+	retryMu sync.Mutex
+	retries map[string]*retryStats //Keyed by dependency
+
+	sql     *SqliteService
+	store   *StoreService
+	gateway *GatewayService
+	solana  *SolanaService
 }
 
 const STAT_SVC = "stat_svc"
 
-func (svc StatService) Id() string {
+type retryStats struct {
+	Retries   uint64 `json:"retries"`
+	Exhausted uint64 `json:"exhausted"`
+}
+
+func (svc *StatService) Id() string {
 	return STAT_SVC
 }
 
 func (svc *StatService) Start() error {
+	if svc.Config == nil {
+		return config.ErrMissingConfig
+	}
+
 	svc.sql = svc.DefaultService(SQLITE_SVC).(*SqliteService)
+	svc.store = svc.DefaultService(STORE_SVC).(*StoreService)
+	svc.gateway = svc.DefaultService(GATEWAY_SVC).(*GatewayService)
+	svc.solana = svc.DefaultService(SOLANA_SVC).(*SolanaService)
 
 	return nil
 }
@@ -42,6 +70,63 @@ func (svc *StatService) IncrementMediaRequests() {
 	atomic.AddUint64(&svc.requestsServed, 1)
 }
 
+// RecordResizeQueueWait tracks how long a resize job waited for a free worker
+func (svc *StatService) RecordResizeQueueWait(wait time.Duration) {
+	atomic.AddUint64(&svc.resizeJobs, 1)
+	atomic.AddUint64(&svc.resizeQueueWait, uint64(wait))
+
+	for {
+		prev := atomic.LoadUint64(&svc.resizeQueueWaitMax)
+		if uint64(wait) <= prev || atomic.CompareAndSwapUint64(&svc.resizeQueueWaitMax, prev, uint64(wait)) {
+			return
+		}
+	}
+}
+
+func (svc *StatService) IncrementResizeRejected() {
+	atomic.AddUint64(&svc.resizeRejected, 1)
+}
+
+func (svc *StatService) IncrementResizeTimeouts() {
+	atomic.AddUint64(&svc.resizeTimeouts, 1)
+}
+
+// IncrementRetries counts a retried request to dependency
+func (svc *StatService) IncrementRetries(dependency string) {
+	if svc == nil {
+		return
+	}
+
+	svc.retryMu.Lock()
+	svc.retryStats(dependency).Retries++
+	svc.retryMu.Unlock()
+}
+
+// IncrementRetriesExhausted counts a request to dependency that still failed after retrying
+func (svc *StatService) IncrementRetriesExhausted(dependency string) {
+	if svc == nil {
+		return
+	}
+
+	svc.retryMu.Lock()
+	svc.retryStats(dependency).Exhausted++
+	svc.retryMu.Unlock()
+}
+
+// retryStats returns the counters for dependency. Callers must hold retryMu.
+func (svc *StatService) retryStats(dependency string) *retryStats {
+	if svc.retries == nil {
+		svc.retries = map[string]*retryStats{}
+	}
+
+	s, ok := svc.retries[dependency]
+	if !ok {
+		s = &retryStats{}
+		svc.retries[dependency] = s
+	}
+	return s
+}
+
 // The counters are now returned as atomically loaded values, ensuring thread-safety during stat retrieval.
 func (svc *StatService) ServiceStats() (map[string]interface{}, error) {
 	// Retrieve image count from the database
@@ -51,11 +136,42 @@ func (svc *StatService) ServiceStats() (map[string]interface{}, error) {
 		return nil, err
 	}
 
+	storeStats, err := svc.store.Stats()
+	if err != nil {
+		log.Printf("Error retrieving store stats from database: %v", err)
+		return nil, err
+	}
+
+	var avgWait float64
+	resizeJobs := atomic.LoadUint64(&svc.resizeJobs)
+	if resizeJobs > 0 {
+		avgWait = float64(atomic.LoadUint64(&svc.resizeQueueWait)) / float64(resizeJobs) / float64(time.Millisecond)
+	}
+
+	svc.retryMu.Lock()
+	retries := make(map[string]retryStats, len(svc.retries))
+	for dependency, s := range svc.retries {
+		retries[dependency] = *s
+	}
+	svc.retryMu.Unlock()
+
 	// Return the stats with atomically loaded values for thread safety
 	return map[string]interface{}{
-		"images_stored":      imgCount,
-		"requests_served":    atomic.LoadUint64(&svc.requestsServed),
-		"image_files_served": atomic.LoadUint64(&svc.imageFilesServed),
-		"media_files_served": atomic.LoadUint64(&svc.mediaFilesServed),
+		"images_stored":            imgCount,
+		"requests_served":          atomic.LoadUint64(&svc.requestsServed),
+		"image_files_served":       atomic.LoadUint64(&svc.imageFilesServed),
+		"media_files_served":       atomic.LoadUint64(&svc.mediaFilesServed),
+		"resize_jobs":              resizeJobs,
+		"resize_queue_wait_avg_ms": avgWait,
+		"resize_queue_wait_max_ms": float64(atomic.LoadUint64(&svc.resizeQueueWaitMax)) / float64(time.Millisecond),
+		"resize_rejected":          atomic.LoadUint64(&svc.resizeRejected),
+		"resize_timeouts":          atomic.LoadUint64(&svc.resizeTimeouts),
+		"originals_stored":         storeStats.Blobs,
+		"originals_referenced":     storeStats.Mints,
+		"originals_bytes":          storeStats.BytesStored,
+		"originals_bytes_saved":    storeStats.BytesSaved,
+		"gateway_health":           svc.gateway.Health(),
+		"retries":                  retries,
+		"rpc_endpoints":            svc.solana.RPCHealth(),
 	}, nil
 }
\ No newline at end of file
@@ -0,0 +1,366 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	nft_proxy "github.com/alphabatem/nft-proxy"
+	"github.com/alphabatem/nft-proxy/config"
+	"github.com/babilu-online/common/context"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// StoreService keeps the original downloaded image bytes content-addressed by SHA-256
+// alongside the resized variants derived from them, keyed by (mint, size, format).
+// Mints with identical images reference the same reference counted blob.
+type StoreService struct {
+	context.DefaultService
+	Config *config.Config
+
+	sql *SqliteService
+
+	root string
+
+	mu sync.RWMutex //Guards blob reference counts & file removal, held for reading while originals are read
+}
+
+const STORE_SVC = "store_svc"
+
+var ErrOriginalNotFound = errors.New("original not found")
+
+// StoreStats describes how much space deduplication is saving
+type StoreStats struct {
+	Blobs           int64 `json:"blobs"`
+	Mints           int64 `json:"mints"`
+	BytesStored     int64 `json:"bytes_stored"`
+	BytesReferenced int64 `json:"bytes_referenced"`
+	BytesSaved      int64 `json:"bytes_saved"`
+}
+
+func (svc *StoreService) Id() string {
+	return STORE_SVC
+}
+
+func (svc *StoreService) Start() error {
+	if svc.Config == nil {
+		return config.ErrMissingConfig
+	}
+
+	svc.sql = svc.DefaultService(SQLITE_SVC).(*SqliteService)
+
+	svc.root = svc.Config.Media.CacheDir
+
+	for _, dir := range []string{svc.originalsDir(), svc.variantsDir()} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	return svc.removePartial()
+}
+
+// removePartial deletes temporary files left behind by writes interrupted by a crash
+func (svc *StoreService) removePartial() error {
+	for _, dir := range []string{svc.originalsDir(), svc.variantsDir()} {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && strings.HasPrefix(d.Name(), ".tmp-") {
+				return os.Remove(path)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (svc *StoreService) originalsDir() string {
+	return filepath.Join(svc.root, "originals")
+}
+
+func (svc *StoreService) variantsDir() string {
+	return filepath.Join(svc.root, "variants")
+}
+
+// OriginalPath returns the content-addressed location of an original
+func (svc *StoreService) OriginalPath(hash string) string {
+	return filepath.Join(svc.originalsDir(), hash[:2], hash)
+}
+
+// VariantPath returns the location of a resized variant of the original of mint
+func (svc *StoreService) VariantPath(mint string, size int, format string) string {
+	return filepath.Join(svc.variantsDir(), fmt.Sprintf("%v", size), fmt.Sprintf("%s.%s", mint, format))
+}
+
+// SaveOriginal stores data once per unique content & records it as the original image of mint
+func (svc *StoreService) SaveOriginal(mint string, uri string, data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	path := svc.OriginalPath(hash)
+	if _, err := os.Stat(path); err != nil {
+		err = svc.writeAtomic(path, func(w io.Writer) error {
+			_, err := w.Write(data)
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return hash, svc.link(mint, uri, hash, int64(len(data)))
+}
+
+// LinkByUri points mint at an original already downloaded from the same uri by another mint
+func (svc *StoreService) LinkByUri(mint string, uri string) (*nft_proxy.MediaOriginal, error) {
+	if uri == "" || strings.HasPrefix(uri, "data:") {
+		return nil, ErrOriginalNotFound
+	}
+
+	var existing nft_proxy.MediaOriginal
+	err := svc.sql.Db().Where("image_uri = ? AND mint != ?", uri, mint).First(&existing).Error
+	if err != nil {
+		return nil, ErrOriginalNotFound
+	}
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	if _, err := os.Stat(svc.OriginalPath(existing.Hash)); err != nil {
+		return nil, ErrOriginalNotFound
+	}
+
+	err = svc.link(mint, uri, existing.Hash, existing.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	return svc.OriginalInfo(mint)
+}
+
+// link points mint at hash, moving its reference off any previous blob & dropping variants of the previous
+// image. Callers must hold mu.
+func (svc *StoreService) link(mint string, uri string, hash string, size int64) error {
+	if strings.HasPrefix(uri, "data:") {
+		uri = "" //Inline images are deduplicated by content, no need to keep them twice
+	}
+
+	var changed bool
+	var released string
+	err := svc.sql.Db().Transaction(func(tx *gorm.DB) error {
+		var previous nft_proxy.MediaOriginal
+		err := tx.First(&previous, "mint = ?", mint).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		changed = previous.Hash != hash
+		if changed {
+			err = tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "hash"}},
+				DoUpdates: clause.Assignments(map[string]interface{}{"ref_count": gorm.Expr("ref_count + 1")}),
+			}).Create(&nft_proxy.MediaBlob{Hash: hash, Size: size, RefCount: 1}).Error
+			if err != nil {
+				return err
+			}
+
+			if previous.Hash != "" {
+				released, err = svc.release(tx, previous.Hash)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "mint"}},
+			DoUpdates: clause.AssignmentColumns([]string{"image_uri", "hash", "size", "updated_at"}),
+		}).Create(&nft_proxy.MediaOriginal{
+			Mint:     mint,
+			ImageUri: uri,
+			Hash:     hash,
+			Size:     size,
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	if changed {
+		err = svc.RemoveVariants(mint)
+		if err != nil {
+			return err
+		}
+	}
+	return svc.removeBlobFiles(released)
+}
+
+// release drops a reference to hash, returning the hash if it is no longer referenced
+func (svc *StoreService) release(tx *gorm.DB, hash string) (string, error) {
+	err := tx.Model(&nft_proxy.MediaBlob{}).Where("hash = ?", hash).Update("ref_count", gorm.Expr("ref_count - 1")).Error
+	if err != nil {
+		return "", err
+	}
+
+	res := tx.Where("hash = ? AND ref_count <= 0", hash).Delete(&nft_proxy.MediaBlob{})
+	if res.Error != nil {
+		return "", res.Error
+	}
+	if res.RowsAffected == 0 {
+		return "", nil
+	}
+	return hash, nil
+}
+
+// Release removes the original & variants of mint, deleting the blob once no other mint references it
+func (svc *StoreService) Release(mint string) error {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	var released string
+	err := svc.sql.Db().Transaction(func(tx *gorm.DB) error {
+		var original nft_proxy.MediaOriginal
+		err := tx.First(&original, "mint = ?", mint).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		err = tx.Delete(&original).Error
+		if err != nil {
+			return err
+		}
+
+		released, err = svc.release(tx, original.Hash)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	err = svc.RemoveVariants(mint)
+	if err != nil {
+		return err
+	}
+	return svc.removeBlobFiles(released)
+}
+
+func (svc *StoreService) removeBlobFiles(hash string) error {
+	if hash == "" {
+		return nil
+	}
+
+	err := os.Remove(svc.OriginalPath(hash))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// OriginalInfo returns the original record for mint without reading its data
+func (svc *StoreService) OriginalInfo(mint string) (*nft_proxy.MediaOriginal, error) {
+	var original nft_proxy.MediaOriginal
+	err := svc.sql.Db().First(&original, "mint = ?", mint).Error
+	if err != nil {
+		return nil, ErrOriginalNotFound
+	}
+	return &original, nil
+}
+
+// Original returns the original image bytes stored for mint
+func (svc *StoreService) Original(mint string) (*nft_proxy.MediaOriginal, []byte, error) {
+	//The blob can't be released between looking up its hash & reading it
+	svc.mu.RLock()
+	defer svc.mu.RUnlock()
+
+	original, err := svc.OriginalInfo(mint)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := os.ReadFile(svc.OriginalPath(original.Hash))
+	if err != nil {
+		return nil, nil, ErrOriginalNotFound
+	}
+
+	return original, data, nil
+}
+
+// WriteVariant atomically writes a variant so readers never see a partial file
+func (svc *StoreService) WriteVariant(mint string, size int, format string, fn func(w io.Writer) error) (string, error) {
+	path := svc.VariantPath(mint, size, format)
+	return path, svc.writeAtomic(path, fn)
+}
+
+// RemoveVariants deletes every derived variant of mint, they can be regenerated from the original
+func (svc *StoreService) RemoveVariants(mint string) error {
+	matches, err := filepath.Glob(filepath.Join(svc.variantsDir(), "*", mint+".*"))
+	if err != nil {
+		return err
+	}
+
+	for _, m := range matches {
+		if err := os.Remove(m); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stats reports stored vs referenced bytes across all blobs
+func (svc *StoreService) Stats() (*StoreStats, error) {
+	var stats StoreStats
+	err := svc.sql.Db().Model(&nft_proxy.MediaBlob{}).
+		Select("COUNT(*) AS blobs, COALESCE(SUM(ref_count), 0) AS mints, COALESCE(SUM(size), 0) AS bytes_stored, COALESCE(SUM(size * ref_count), 0) AS bytes_referenced").
+		Scan(&stats).Error
+	if err != nil {
+		return nil, err
+	}
+
+	stats.BytesSaved = stats.BytesReferenced - stats.BytesStored
+	return &stats, nil
+}
+
+func (svc *StoreService) writeAtomic(path string, fn func(w io.Writer) error) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) //No-op once renamed
+
+	err = fn(tmp)
+	if err == nil {
+		err = tmp.Sync() //Renamed files must be complete even if we crash
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
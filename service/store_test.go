@@ -0,0 +1,181 @@
+package services
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestSqlite(t *testing.T) *SqliteService {
+	t.Helper()
+
+	svc := SqliteService{config: DefaultConfig()}
+	svc.config.Database = filepath.Join(t.TempDir(), "test.db")
+	err := svc.Start()
+	if err != nil {
+		t.Fatalf("Failed to start SqliteService: %v", err)
+	}
+	return &svc
+}
+
+func newTestStore(t *testing.T, sql *SqliteService) *StoreService {
+	t.Helper()
+
+	return &StoreService{sql: sql, root: t.TempDir()}
+}
+
+func writeTestVariant(t *testing.T, svc *StoreService, mint string) string {
+	t.Helper()
+
+	path, err := svc.WriteVariant(mint, 720, "png", func(w io.Writer) error {
+		_, err := w.Write([]byte("variant"))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestStoreService_Dedup(t *testing.T) {
+	svc := newTestStore(t, newTestSqlite(t))
+	image := []byte("shared image bytes")
+
+	hashA, err := svc.SaveOriginal("mintA", "https://example.com/a.png", image)
+	if err != nil {
+		t.Fatalf("Failed to save original: %v", err)
+	}
+
+	hashB, err := svc.SaveOriginal("mintB", "https://example.com/b.png", image)
+	if err != nil {
+		t.Fatalf("Failed to save original: %v", err)
+	}
+
+	t.Run("Content Hash", func(t *testing.T) {
+		if hashA != hashB {
+			t.Fatalf("Expected identical content to share a hash, got %s & %s", hashA, hashB)
+		}
+
+		stats, err := svc.Stats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Blobs != 1 || stats.Mints != 2 {
+			t.Fatalf("Expected 1 blob referenced by 2 mints, got %+v", stats)
+		}
+		if stats.BytesSaved != int64(len(image)) {
+			t.Fatalf("Expected %v bytes saved, got %v", len(image), stats.BytesSaved)
+		}
+	})
+
+	t.Run("Uri", func(t *testing.T) {
+		original, err := svc.LinkByUri("mintC", "https://example.com/a.png")
+		if err != nil {
+			t.Fatalf("Expected mint to link to existing uri: %v", err)
+		}
+		if original.Hash != hashA {
+			t.Fatalf("Expected linked hash %s, got %s", hashA, original.Hash)
+		}
+
+		_, err = svc.LinkByUri("mintD", "https://example.com/unknown.png")
+		if err != ErrOriginalNotFound {
+			t.Fatalf("Expected ErrOriginalNotFound for unknown uri, got %v", err)
+		}
+	})
+
+	t.Run("Release", func(t *testing.T) {
+		variant := writeTestVariant(t, svc, "mintA")
+
+		for _, mint := range []string{"mintA", "mintB"} {
+			if err := svc.Release(mint); err != nil {
+				t.Fatalf("Failed to release %s: %v", mint, err)
+			}
+			if _, err := os.Stat(svc.OriginalPath(hashA)); err != nil {
+				t.Fatalf("Expected blob to remain while referenced: %v", err)
+			}
+		}
+		if _, err := os.Stat(variant); !os.IsNotExist(err) {
+			t.Fatal("Expected variants to be removed with their mint")
+		}
+
+		if err := svc.Release("mintC"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(svc.OriginalPath(hashA)); !os.IsNotExist(err) {
+			t.Fatal("Expected blob to be removed once unreferenced")
+		}
+	})
+
+	t.Run("Replace", func(t *testing.T) {
+		oldHash, err := svc.SaveOriginal("mintE", "https://example.com/e.png", []byte("before reveal"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		//Variants are kept while the image is unchanged
+		variant := writeTestVariant(t, svc, "mintE")
+		_, err = svc.SaveOriginal("mintE", "https://example.com/e.png", []byte("before reveal"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(variant); err != nil {
+			t.Fatalf("Expected variant of an unchanged original to be kept: %v", err)
+		}
+
+		_, err = svc.SaveOriginal("mintE", "https://example.com/e.png", []byte("after reveal"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := os.Stat(svc.OriginalPath(oldHash)); !os.IsNotExist(err) {
+			t.Fatal("Expected replaced original to be removed")
+		}
+		if _, err := os.Stat(variant); !os.IsNotExist(err) {
+			t.Fatal("Expected variants of the replaced original to be removed")
+		}
+
+		_, data, err := svc.Original("mintE")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "after reveal" {
+			t.Fatalf("Expected updated original, got %s", data)
+		}
+	})
+}
+
+func TestStoreService_RemovePartial(t *testing.T) {
+	svc := newTestStore(t, newTestSqlite(t))
+
+	hash, err := svc.SaveOriginal("mintA", "https://example.com/a.png", []byte("image bytes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//Left behind by writes interrupted by a crash
+	partial := []string{
+		filepath.Join(filepath.Dir(svc.OriginalPath(hash)), ".tmp-1"),
+		filepath.Join(svc.variantsDir(), "720", ".tmp-2"),
+	}
+	for _, p := range partial {
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("partial"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := svc.removePartial(); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range partial {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Fatalf("Expected %s to be removed, got %v", p, err)
+		}
+	}
+	if _, err := os.Stat(svc.OriginalPath(hash)); err != nil {
+		t.Fatalf("Expected the original to be kept, got %v", err)
+	}
+}
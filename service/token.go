@@ -0,0 +1,231 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	nft_proxy "github.com/alphabatem/nft-proxy"
+	"github.com/alphabatem/nft-proxy/config"
+	token_extensions "github.com/alphabatem/nft-proxy/token-extensions"
+	"github.com/babilu-online/common/context"
+	"github.com/gagliardetto/solana-go"
+	"gorm.io/gorm/clause"
+)
+
+// TokenService caches the mint accounts of tokens, periodically refreshing the supply of fungible tokens
+type TokenService struct {
+	context.DefaultService
+	Config *config.Config
+
+	refreshInterval time.Duration //Supply older than this is refreshed
+
+	sql    *SqliteService
+	sol    *SolanaService
+	solImg *SolanaImageService
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	running  sync.WaitGroup
+}
+
+const TOKEN_SVC = "token_svc"
+
+var ErrNotAMint = errors.New("not a token mint")
+
+func (svc *TokenService) Id() string {
+	return TOKEN_SVC
+}
+
+func (svc *TokenService) Configure(ctx *context.Context) error {
+	if svc.Config == nil {
+		return config.ErrMissingConfig
+	}
+
+	svc.refreshInterval = svc.Config.Tokens.SupplyRefresh
+
+	return svc.DefaultService.Configure(ctx)
+}
+
+func (svc *TokenService) Start() error {
+	svc.sql = svc.DefaultService(SQLITE_SVC).(*SqliteService)
+	svc.sol = svc.DefaultService(SOLANA_SVC).(*SolanaService)
+	svc.solImg = svc.DefaultService(SOLANA_IMG_SVC).(*SolanaImageService)
+
+	svc.stop = make(chan struct{})
+	svc.running.Add(1)
+	go func() {
+		defer svc.running.Done()
+		svc.monitor()
+	}()
+	return nil
+}
+
+// Close stops the supply monitor, waiting for an in-flight refresh
+func (svc *TokenService) Close() {
+	svc.stopOnce.Do(func() {
+		if svc.stop != nil {
+			close(svc.stop)
+		}
+	})
+	svc.running.Wait()
+}
+
+// Token returns the media of key along with the supply & authorities of its mint
+func (svc *TokenService) Token(key string, skipCache bool) (*nft_proxy.Token, error) {
+	media, err := svc.solImg.Media(key, skipCache)
+	if err != nil {
+		return nil, err
+	}
+
+	var token nft_proxy.SolanaToken
+	err = svc.sql.Db().First(&token, "mint = ?", key).Error
+	if err != nil || skipCache {
+		tokens, err := svc.fetch([]string{key})
+		if err != nil {
+			return nil, err
+		}
+		if tokens[0] == nil {
+			return nil, ErrNotAMint
+		}
+		token = *tokens[0]
+	}
+
+	return token.Token(media), nil
+}
+
+// fetch reads & caches the mint accounts of keys, returning nil entries for keys that are not mints
+func (svc *TokenService) fetch(keys []string) ([]*nft_proxy.SolanaToken, error) {
+	pks := make([]solana.PublicKey, len(keys))
+	for i, key := range keys {
+		pk, err := solana.PublicKeyFromBase58(key)
+		if err != nil {
+			return nil, err
+		}
+		pks[i] = pk
+	}
+
+	accounts, slot, err := svc.sol.Accounts(pks)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make([]*nft_proxy.SolanaToken, len(keys))
+	for i, acc := range accounts {
+		if acc == nil {
+			continue
+		}
+
+		var program string
+		switch acc.Owner {
+		case solana.TokenProgramID:
+			program = nft_proxy.TokenProgramSPL
+		case nft_proxy.TOKEN_2022:
+			program = nft_proxy.TokenProgramToken2022
+		default:
+			continue
+		}
+
+		mint, err := token_extensions.DecodeMint(acc.Data.GetBinary())
+		if err != nil {
+			log.Printf("%s decode mint err: %s", keys[i], err)
+			continue
+		}
+
+		tokens[i], err = svc.cache(&nft_proxy.SolanaToken{
+			Mint:            keys[i],
+			Program:         program,
+			Supply:          strconv.FormatUint(mint.Supply, 10),
+			Decimals:        mint.Decimals,
+			MintAuthority:   pkString(mint.MintAuthority),
+			FreezeAuthority: pkString(mint.FreezeAuthority),
+			TokenStandard:   tokenStandard(mint),
+			Slot:            slot,
+			SupplyUpdatedAt: time.Now(),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tokens, nil
+}
+
+// cache upserts token, keeping the stored row if it was read at a later slot
+func (svc *TokenService) cache(token *nft_proxy.SolanaToken) (*nft_proxy.SolanaToken, error) {
+	res := svc.sql.Db().Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "mint"}},
+		DoUpdates: clause.AssignmentColumns([]string{"program", "supply", "decimals", "mint_authority", "freeze_authority", "token_standard", "slot", "supply_updated_at"}),
+		Where: clause.Where{Exprs: []clause.Expression{
+			clause.Expr{SQL: "excluded.slot >= solana_tokens.slot"},
+		}},
+	}).Create(token)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		var current nft_proxy.SolanaToken
+		err := svc.sql.Db().First(&current, "mint = ?", token.Mint).Error
+		if err != nil {
+			return nil, err
+		}
+		return &current, nil
+	}
+	return token, nil
+}
+
+// monitor refreshes the supply of fungible tokens every refreshInterval until Close is called
+func (svc *TokenService) monitor() {
+	ticker := time.NewTicker(svc.refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-svc.stop:
+			return
+		case <-ticker.C:
+			err := svc.refreshSupply()
+			if err != nil {
+				log.Printf("Token supply refresh err: %s", err)
+			}
+		}
+	}
+}
+
+// refreshSupply re-reads the mints with a stale supply, NFT supply only changes when burnt so they are left as is
+func (svc *TokenService) refreshSupply() error {
+	var mints []string
+	err := svc.sql.Db().Model(&nft_proxy.SolanaToken{}).
+		Where("token_standard != ? AND supply_updated_at < ?", nft_proxy.TokenStandardNonFungible, time.Now().Add(-svc.refreshInterval)).
+		Order("supply_updated_at").
+		Limit(1000).
+		Pluck("mint", &mints).Error
+	if err != nil || len(mints) == 0 {
+		return err
+	}
+
+	_, err = svc.fetch(mints)
+	return err
+}
+
+// tokenStandard infers the standard from the mint, following Metaplex: decimals make a token fungible,
+// otherwise a supply above one makes it a semi-fungible asset
+func tokenStandard(mint *token_extensions.Mint) nft_proxy.TokenStandard {
+	switch {
+	case mint.Decimals > 0:
+		return nft_proxy.TokenStandardFungible
+	case mint.Supply > 1:
+		return nft_proxy.TokenStandardFungibleAsset
+	default:
+		return nft_proxy.TokenStandardNonFungible
+	}
+}
+
+func pkString(pk *solana.PublicKey) string {
+	if pk == nil {
+		return ""
+	}
+	return pk.String()
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	nft_proxy "github.com/alphabatem/nft-proxy"
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+)
+
+// accountStandIn is a local JSON-RPC node serving getMultipleAccounts & getAccountInfo from a set of accounts
+type accountStandIn struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	slot     uint64
+	accounts map[string]map[string]interface{}
+	requests map[string]int //Account -> times requested
+}
+
+func newAccountStandIn() *accountStandIn {
+	s := &accountStandIn{slot: 100, accounts: map[string]map[string]interface{}{}, requests: map[string]int{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     interface{}       `json:"id"`
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+
+		if req.Method != "getMultipleAccounts" && req.Method != "getAccountInfo" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "error": map[string]interface{}{"code": -32601, "message": "Method not found"}})
+			return
+		}
+
+		var keys []string
+		if req.Method == "getAccountInfo" {
+			keys = make([]string, 1)
+			json.Unmarshal(req.Params[0], &keys[0])
+		} else {
+			json.Unmarshal(req.Params[0], &keys)
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		value := make([]interface{}, len(keys))
+		for i, k := range keys {
+			s.requests[k]++
+			if acc, ok := s.accounts[k]; ok {
+				value[i] = acc
+			}
+		}
+
+		var result interface{} = map[string]interface{}{"context": map[string]interface{}{"slot": s.slot}, "value": value}
+		if req.Method == "getAccountInfo" {
+			result = map[string]interface{}{"context": map[string]interface{}{"slot": s.slot}, "value": value[0]}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": result})
+	}))
+	return s
+}
+
+// setAccount stores an account owned by program
+func (s *accountStandIn) setAccount(key solana.PublicKey, program solana.PublicKey, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accounts[key.String()] = map[string]interface{}{"lamports": 1, "owner": program.String(), "data": []string{base64.StdEncoding.EncodeToString(data), "base64"}, "executable": false, "rentEpoch": 0}
+}
+
+// setMint stores a mint account owned by program
+func (s *accountStandIn) setMint(mint solana.PublicKey, program solana.PublicKey, supply uint64, decimals uint8, authority *solana.PublicKey) {
+	data := make([]byte, 82)
+	if authority != nil {
+		binary.LittleEndian.PutUint32(data, 1)
+		copy(data[4:36], authority[:])
+	}
+	binary.LittleEndian.PutUint64(data[36:], supply)
+	data[44] = decimals
+	data[45] = 1
+	s.setAccount(mint, program, data)
+}
+
+// Requests returns how many times mint was requested since the last reset
+func (s *accountStandIn) Requests(mint solana.PublicKey) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[mint.String()]
+}
+
+func (s *accountStandIn) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = map[string]int{}
+}
+
+func TestTokenService(t *testing.T) {
+	standIn := newAccountStandIn()
+	defer standIn.Close()
+
+	authority := solana.NewWallet().PublicKey()
+	fungible := solana.NewWallet().PublicKey()
+	sft := solana.NewWallet().PublicKey()
+	nft := solana.NewWallet().PublicKey()
+	wallet := solana.NewWallet().PublicKey()
+
+	standIn.setMint(fungible, solana.TokenProgramID, 1_000_000_000, 6, &authority)
+	standIn.setMint(sft, nft_proxy.TOKEN_2022, 50, 0, nil)
+	standIn.setMint(nft, solana.TokenProgramID, 1, 0, nil)
+	standIn.accounts[wallet.String()] = map[string]interface{}{"lamports": 1, "owner": solana.SystemProgramID.String(), "data": []string{"", "base64"}, "executable": false, "rentEpoch": 0}
+
+	sql := newTestSqlite(t)
+	svc := TokenService{
+		refreshInterval: time.Minute,
+		sql:             sql,
+		sol:             &SolanaService{client: rpc.New(standIn.URL), commitments: defaultRPCCommitments},
+		solImg:          &SolanaImageService{sql: sql},
+	}
+
+	t.Run("Fetch", func(t *testing.T) {
+		missing := solana.NewWallet().PublicKey()
+		tokens, err := svc.fetch([]string{fungible.String(), sft.String(), nft.String(), wallet.String(), missing.String()})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if f := tokens[0]; f.Program != nft_proxy.TokenProgramSPL || f.Supply != "1000000000" || f.Decimals != 6 || f.MintAuthority != authority.String() || f.TokenStandard != nft_proxy.TokenStandardFungible {
+			t.Fatalf("Unexpected fungible token %+v", f)
+		}
+		if s := tokens[1]; s.Program != nft_proxy.TokenProgramToken2022 || s.TokenStandard != nft_proxy.TokenStandardFungibleAsset || s.MintAuthority != "" {
+			t.Fatalf("Unexpected semi-fungible token %+v", s)
+		}
+		if n := tokens[2]; n.TokenStandard != nft_proxy.TokenStandardNonFungible {
+			t.Fatalf("Unexpected nft %+v", n)
+		}
+		if tokens[3] != nil || tokens[4] != nil {
+			t.Fatalf("Expected accounts that are not mints to be skipped, got %+v & %+v", tokens[3], tokens[4])
+		}
+	})
+
+	t.Run("Token", func(t *testing.T) {
+		standIn.reset()
+		sql.Db().Create(&nft_proxy.SolanaMedia{Mint: fungible.String(), Name: "Fungible", ImageUri: "https://example.com/logo.png", MintDecimals: 6})
+
+		token, err := svc.Token(fungible.String(), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token.Name != "Fungible" || token.Logo != "https://example.com/logo.png" || token.Supply != "1000000000" || token.TokenStandard != nft_proxy.TokenStandardFungible {
+			t.Fatalf("Unexpected token %+v", token)
+		}
+		if standIn.Requests(fungible) != 0 {
+			t.Fatal("Expected the cached mint to be served without an rpc call")
+		}
+	})
+
+	t.Run("Refresh Supply", func(t *testing.T) {
+		standIn.setMint(fungible, solana.TokenProgramID, 2_000_000_000, 6, &authority)
+		standIn.setMint(nft, solana.TokenProgramID, 0, 0, nil)
+		standIn.slot = 200
+		standIn.reset()
+
+		sql.Db().Model(&nft_proxy.SolanaToken{}).Where("1 = 1").Update("supply_updated_at", time.Now().Add(-time.Hour))
+		if err := svc.refreshSupply(); err != nil {
+			t.Fatal(err)
+		}
+
+		if standIn.Requests(nft) != 0 {
+			t.Fatal("Expected nft supply not to be refreshed")
+		}
+		if standIn.Requests(fungible) != 1 || standIn.Requests(sft) != 1 {
+			t.Fatal("Expected stale fungible supplies to be refreshed")
+		}
+
+		var stored nft_proxy.SolanaToken
+		sql.Db().First(&stored, "mint = ?", fungible.String())
+		if stored.Supply != "2000000000" || time.Since(stored.SupplyUpdatedAt) > time.Minute {
+			t.Fatalf("Expected refreshed supply, got %+v", stored)
+		}
+	})
+
+	t.Run("Earlier Slot", func(t *testing.T) {
+		standIn.setMint(fungible, solana.TokenProgramID, 5, 6, &authority)
+		standIn.slot = 150
+
+		tokens, err := svc.fetch([]string{fungible.String()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tokens[0].Supply != "2000000000" {
+			t.Fatalf("Expected supply from a lagging node to be ignored, got %+v", tokens[0])
+		}
+	})
+}
@@ -0,0 +1,245 @@
+package services
+
+import (
+	ctx "context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+
+	nft_proxy "github.com/alphabatem/nft-proxy"
+	"github.com/alphabatem/nft-proxy/config"
+	"github.com/babilu-online/common/context"
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+)
+
+// WalletService lists the NFTs & tokens held by a wallet, joined with their cached media
+type WalletService struct {
+	context.DefaultService
+	Config *config.Config
+
+	sql    *SqliteService
+	sol    *SolanaService
+	solImg *SolanaImageService
+}
+
+const WALLET_SVC = "wallet_svc"
+
+const (
+	DefaultHoldingsLimit = 50
+	MaxHoldingsLimit     = 100 //Uncached media on a page is fetched before responding
+)
+
+var ErrInvalidPage = errors.New("invalid page")
+
+func (svc *WalletService) Id() string {
+	return WALLET_SVC
+}
+
+func (svc *WalletService) Start() error {
+	if svc.Config == nil {
+		return config.ErrMissingConfig
+	}
+
+	svc.sql = svc.DefaultService(SQLITE_SVC).(*SqliteService)
+	svc.sol = svc.DefaultService(SOLANA_SVC).(*SolanaService)
+	svc.solImg = svc.DefaultService(SOLANA_IMG_SVC).(*SolanaImageService)
+	return nil
+}
+
+// NFTs returns a page of the single supply token accounts & metaplex core assets owned by owner
+func (svc *WalletService) NFTs(c ctx.Context, owner string, page, limit int) (*nft_proxy.Holdings, error) {
+	pk, err := solana.PublicKeyFromBase58(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts, err := svc.sol.TokenAccountsByOwner(c, pk)
+	if err != nil {
+		return nil, err
+	}
+	assets, err := svc.sol.CoreAssetsByOwner(c, pk)
+	if err != nil {
+		return nil, err
+	}
+
+	var holdings []*nft_proxy.Holding
+	for _, h := range accounts {
+		if isNFTHolding(h) {
+			holdings = append(holdings, h)
+		}
+	}
+	for _, asset := range assets {
+		holdings = append(holdings, &nft_proxy.Holding{Mint: asset.String(), Program: nft_proxy.TokenProgramCore, Amount: "1"})
+	}
+	return svc.page(owner, holdings, page, limit)
+}
+
+// Tokens returns a page of the fungible token accounts owned by owner
+func (svc *WalletService) Tokens(c ctx.Context, owner string, page, limit int) (*nft_proxy.Holdings, error) {
+	pk, err := solana.PublicKeyFromBase58(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts, err := svc.sol.TokenAccountsByOwner(c, pk)
+	if err != nil {
+		return nil, err
+	}
+
+	var holdings []*nft_proxy.Holding
+	for _, h := range accounts {
+		if !isNFTHolding(h) {
+			holdings = append(holdings, h)
+		}
+	}
+	return svc.page(owner, holdings, page, limit)
+}
+
+// page orders holdings by mint & joins the requested page with its media, fetching media that is not cached yet
+func (svc *WalletService) page(owner string, holdings []*nft_proxy.Holding, page, limit int) (*nft_proxy.Holdings, error) {
+	if page < 1 || limit < 1 || limit > MaxHoldingsLimit {
+		return nil, ErrInvalidPage
+	}
+
+	sort.Slice(holdings, func(i, j int) bool {
+		if holdings[i].Mint == holdings[j].Mint {
+			return holdings[i].TokenAccount < holdings[j].TokenAccount
+		}
+		return holdings[i].Mint < holdings[j].Mint
+	})
+
+	res := nft_proxy.Holdings{Owner: owner, Page: page, Limit: limit, Total: len(holdings), Items: []*nft_proxy.Holding{}}
+	start := (page - 1) * limit
+	if start >= len(holdings) {
+		return &res, nil
+	}
+	end := start + limit
+	if end > len(holdings) {
+		end = len(holdings)
+	}
+	res.Items = holdings[start:end]
+
+	mints := make([]string, len(res.Items))
+	for i, h := range res.Items {
+		mints[i] = h.Mint
+	}
+
+	var cached []*nft_proxy.SolanaMedia
+	err := svc.sql.Db().Where("mint IN ?", mints).Find(&cached).Error
+	if err != nil {
+		return nil, err
+	}
+	media := map[string]*nft_proxy.SolanaMedia{}
+	for _, m := range cached {
+		media[m.Mint] = m
+	}
+
+	var missing []string
+	for _, mint := range mints {
+		if _, ok := media[mint]; !ok {
+			missing = append(missing, mint)
+		}
+	}
+	if len(missing) > 0 {
+		fetched, err := svc.solImg.FetchMetadataBatch(missing)
+		if err != nil {
+			log.Printf("Wallet %s media err: %s", owner, err) //Still list the holdings
+		}
+		for _, m := range fetched {
+			if m != nil {
+				media[m.Mint] = m
+			}
+		}
+	}
+
+	for _, h := range res.Items {
+		if m, ok := media[h.Mint]; ok {
+			h.Media = m.Media()
+		}
+	}
+	return &res, nil
+}
+
+// isNFTHolding reports whether a token account holds an NFT, a single token of a mint without decimals
+func isNFTHolding(h *nft_proxy.Holding) bool {
+	return h.Decimals == 0 && h.Amount == "1"
+}
+
+// parsedTokenAccount is the jsonParsed encoding of a token account
+type parsedTokenAccount struct {
+	Parsed struct {
+		Info struct {
+			Mint        string `json:"mint"`
+			TokenAmount struct {
+				Amount   string `json:"amount"`
+				Decimals uint8  `json:"decimals"`
+			} `json:"tokenAmount"`
+		} `json:"info"`
+	} `json:"parsed"`
+}
+
+// TokenAccountsByOwner returns the token & token-2022 accounts of owner holding a balance
+func (svc *SolanaService) TokenAccountsByOwner(c ctx.Context, owner solana.PublicKey) ([]*nft_proxy.Holding, error) {
+	var holdings []*nft_proxy.Holding
+	for _, program := range []solana.PublicKey{solana.TokenProgramID, nft_proxy.TOKEN_2022} {
+		programId := program
+		res, err := svc.client.GetTokenAccountsByOwner(c, owner, &rpc.GetTokenAccountsConfig{ProgramId: &programId}, &rpc.GetTokenAccountsOpts{
+			Commitment: svc.commitments.Metadata,
+			Encoding:   solana.EncodingJSONParsed, //Includes the decimals of the mint
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		name := nft_proxy.TokenProgramSPL
+		if program == nft_proxy.TOKEN_2022 {
+			name = nft_proxy.TokenProgramToken2022
+		}
+
+		for _, acc := range res.Value {
+			var parsed parsedTokenAccount
+			err = json.Unmarshal(acc.Account.Data.GetRawJSON(), &parsed)
+			if err != nil {
+				return nil, err
+			}
+
+			info := parsed.Parsed.Info
+			if info.TokenAmount.Amount == "" || info.TokenAmount.Amount == "0" {
+				continue //Empty accounts left open
+			}
+
+			holdings = append(holdings, &nft_proxy.Holding{
+				Mint:         info.Mint,
+				Program:      name,
+				TokenAccount: acc.Pubkey.String(),
+				Amount:       info.TokenAmount.Amount,
+				Decimals:     info.TokenAmount.Decimals,
+			})
+		}
+	}
+	return holdings, nil
+}
+
+// CoreAssetsByOwner returns the metaplex core assets owned by owner
+func (svc *SolanaService) CoreAssetsByOwner(c ctx.Context, owner solana.PublicKey) ([]solana.PublicKey, error) {
+	res, err := svc.client.GetProgramAccountsWithOpts(c, nft_proxy.METAPLEX_CORE, &rpc.GetProgramAccountsOpts{
+		Commitment: svc.commitments.Metadata,
+		Encoding:   solana.EncodingBase64,
+		DataSlice:  &rpc.DataSlice{Offset: new(uint64), Length: new(uint64)}, //Keys only
+		Filters: []rpc.RPCFilter{
+			{Memcmp: &rpc.RPCFilterMemcmp{Offset: 0, Bytes: solana.Base58{coreAssetKey}}},
+			{Memcmp: &rpc.RPCFilterMemcmp{Offset: 1, Bytes: owner.Bytes()}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	assets := make([]solana.PublicKey, len(res))
+	for i, acc := range res {
+		assets[i] = acc.Pubkey
+	}
+	return assets, nil
+}
@@ -0,0 +1,195 @@
+package services
+
+import (
+	ctx "context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	nft_proxy "github.com/alphabatem/nft-proxy"
+	"github.com/gagliardetto/solana-go"
+)
+
+type testHolding struct {
+	mint     solana.PublicKey
+	amount   string
+	decimals uint8
+}
+
+// newWalletStandIn serves the token accounts & core assets of owner, reporting every other account as missing
+func newWalletStandIn(t *testing.T, owner solana.PublicKey, holdings map[solana.PublicKey][]testHolding, assets []solana.PublicKey) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     interface{}       `json:"id"`
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+
+		var result interface{}
+		switch req.Method {
+		case "getTokenAccountsByOwner":
+			var conf struct {
+				ProgramId solana.PublicKey `json:"programId"`
+			}
+			json.Unmarshal(req.Params[1], &conf)
+
+			value := []interface{}{}
+			for _, h := range holdings[conf.ProgramId] {
+				info := map[string]interface{}{
+					"mint":        h.mint.String(),
+					"owner":       owner.String(),
+					"tokenAmount": map[string]interface{}{"amount": h.amount, "decimals": h.decimals},
+				}
+				value = append(value, map[string]interface{}{
+					"pubkey":  solana.NewWallet().PublicKey().String(),
+					"account": map[string]interface{}{"lamports": 1, "owner": conf.ProgramId.String(), "data": map[string]interface{}{"parsed": map[string]interface{}{"info": info, "type": "account"}}, "executable": false, "rentEpoch": 0},
+				})
+			}
+			result = map[string]interface{}{"context": map[string]interface{}{"slot": 1}, "value": value}
+		case "getProgramAccounts":
+			var opts struct {
+				Filters []struct {
+					Memcmp struct {
+						Offset uint64 `json:"offset"`
+						Bytes  string `json:"bytes"`
+					} `json:"memcmp"`
+				} `json:"filters"`
+			}
+			json.Unmarshal(req.Params[1], &opts)
+			if len(opts.Filters) != 2 || opts.Filters[1].Memcmp.Offset != 1 || opts.Filters[1].Memcmp.Bytes != owner.String() {
+				t.Errorf("Unexpected core asset filters %+v", opts.Filters)
+			}
+
+			value := []interface{}{}
+			for _, asset := range assets {
+				value = append(value, map[string]interface{}{"pubkey": asset.String(), "account": map[string]interface{}{"lamports": 1, "owner": nft_proxy.METAPLEX_CORE.String(), "data": []string{"", "base64"}, "executable": false, "rentEpoch": 0}})
+			}
+			result = value
+		case "getMultipleAccounts":
+			var keys []string
+			json.Unmarshal(req.Params[0], &keys)
+			result = map[string]interface{}{"context": map[string]interface{}{"slot": 1}, "value": make([]interface{}, len(keys))}
+		case "getAssetBatch":
+			result = []interface{}{nil}
+		default:
+			t.Errorf("Unexpected method %s", req.Method)
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": result})
+	}))
+}
+
+func TestWalletService(t *testing.T) {
+	owner := solana.NewWallet().PublicKey()
+	nft := solana.NewWallet().PublicKey()
+	nft2022 := solana.NewWallet().PublicKey() //No cached media & no account
+	fungible := solana.NewWallet().PublicKey()
+	sft := solana.NewWallet().PublicKey()
+	asset := solana.NewWallet().PublicKey()
+
+	standIn := newWalletStandIn(t, owner, map[solana.PublicKey][]testHolding{
+		solana.TokenProgramID: {
+			{mint: nft, amount: "1"},
+			{mint: fungible, amount: "5000000", decimals: 6},
+			{mint: solana.NewWallet().PublicKey(), amount: "0", decimals: 6},
+		},
+		nft_proxy.TOKEN_2022: {
+			{mint: nft2022, amount: "1"},
+			{mint: sft, amount: "10"},
+		},
+	}, []solana.PublicKey{asset})
+	defer standIn.Close()
+
+	sql := newTestSqlite(t)
+	for _, mint := range []solana.PublicKey{nft, fungible, sft, asset} {
+		sql.Db().Create(&nft_proxy.SolanaMedia{Mint: mint.String(), Name: "Cached " + mint.String()})
+	}
+
+	sol := newTestDASSolana(standIn.URL)
+	svc := WalletService{sql: sql, sol: sol, solImg: &SolanaImageService{sql: sql, sol: sol}}
+
+	mints := func(h *nft_proxy.Holdings) map[string]*nft_proxy.Holding {
+		res := map[string]*nft_proxy.Holding{}
+		for _, item := range h.Items {
+			res[item.Mint] = item
+		}
+		return res
+	}
+
+	t.Run("NFTs", func(t *testing.T) {
+		holdings, err := svc.NFTs(ctx.Background(), owner.String(), 1, DefaultHoldingsLimit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if holdings.Total != 3 || len(holdings.Items) != 3 {
+			t.Fatalf("Expected 3 nfts, got %+v", holdings)
+		}
+		for i := 1; i < len(holdings.Items); i++ {
+			if holdings.Items[i-1].Mint > holdings.Items[i].Mint {
+				t.Fatal("Expected holdings ordered by mint")
+			}
+		}
+
+		items := mints(holdings)
+		if h := items[nft.String()]; h == nil || h.Program != nft_proxy.TokenProgramSPL || h.TokenAccount == "" || h.Media == nil || h.Media.Name != "Cached "+nft.String() {
+			t.Fatalf("Unexpected spl nft %+v", h)
+		}
+		if h := items[nft2022.String()]; h == nil || h.Program != nft_proxy.TokenProgramToken2022 || h.Media != nil {
+			t.Fatalf("Unexpected token-2022 nft %+v", h)
+		}
+		if h := items[asset.String()]; h == nil || h.Program != nft_proxy.TokenProgramCore || h.TokenAccount != "" || h.Media == nil {
+			t.Fatalf("Unexpected core asset %+v", h)
+		}
+	})
+
+	t.Run("Tokens", func(t *testing.T) {
+		holdings, err := svc.Tokens(ctx.Background(), owner.String(), 1, DefaultHoldingsLimit)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		items := mints(holdings)
+		if holdings.Total != 2 || items[fungible.String()] == nil || items[sft.String()] == nil {
+			t.Fatalf("Expected the fungible & semi-fungible tokens, got %+v", holdings)
+		}
+		if h := items[fungible.String()]; h.Amount != "5000000" || h.Decimals != 6 || h.Media == nil {
+			t.Fatalf("Unexpected fungible token %+v", h)
+		}
+	})
+
+	t.Run("Pages", func(t *testing.T) {
+		first, err := svc.NFTs(ctx.Background(), owner.String(), 1, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := svc.NFTs(ctx.Background(), owner.String(), 2, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(first.Items) != 2 || len(second.Items) != 1 || second.Total != 3 || second.Items[0].Mint <= first.Items[1].Mint {
+			t.Fatalf("Unexpected pages %+v & %+v", first.Items, second.Items)
+		}
+
+		past, err := svc.NFTs(ctx.Background(), owner.String(), 3, 2)
+		if err != nil || len(past.Items) != 0 || past.Total != 3 {
+			t.Fatalf("Expected an empty page past the end, got %+v (%v)", past, err)
+		}
+
+		for _, p := range [][2]int{{0, 10}, {1, 0}, {1, MaxHoldingsLimit + 1}} {
+			if _, err := svc.NFTs(ctx.Background(), owner.String(), p[0], p[1]); !errors.Is(err, ErrInvalidPage) {
+				t.Fatalf("Expected ErrInvalidPage for page %d limit %d, got %v", p[0], p[1], err)
+			}
+		}
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		c, cancel := ctx.WithCancel(ctx.Background())
+		cancel() //Client went away or the server is shutting down
+		if _, err := svc.NFTs(c, owner.String(), 1, DefaultHoldingsLimit); !errors.Is(err, ctx.Canceled) {
+			t.Fatalf("Expected the cancelled request to abort its rpc calls, got %v", err)
+		}
+	})
+}
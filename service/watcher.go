@@ -0,0 +1,431 @@
+package services
+
+import (
+	ctx "context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	nft_proxy "github.com/alphabatem/nft-proxy"
+	"github.com/alphabatem/nft-proxy/config"
+	"github.com/alphabatem/nft-proxy/libreplex"
+	"github.com/babilu-online/common/context"
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+	"github.com/gagliardetto/solana-go/rpc/ws"
+)
+
+// WatcherService subscribes to on-chain changes of the mints we have cached & refreshes their
+// metadata & images as soon as they change. Disabled unless RPC_WS_URL is set.
+//
+// Small caches use accountSubscribe on each watched account, larger ones programSubscribe on the
+// metadata programs, filtered by the node to the account types metadata is read from & locally to
+// cached mints. Program subscriptions still stream every metadata change on mainnet, so large caches
+// are better served by the webhook.
+type WatcherService struct {
+	context.DefaultService
+	Config *config.Config
+
+	wsURL          string
+	maxMints       int           //Above this many cached mints switch to program subscriptions
+	reloadInterval time.Duration //How often newly cached mints are picked up
+	debounce       time.Duration //Updates to a mint within this window cause a single refresh
+	commitment     rpc.CommitmentType
+
+	sql *SqliteService
+	img *ImageService
+
+	refresh func(mint string)
+
+	index accountIndex
+
+	pendingMu sync.Mutex
+	pending   map[string]struct{}
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	running  sync.WaitGroup //Session & debounced refreshes, waited on by Close
+}
+
+const WATCHER_SVC = "watcher_svc"
+
+var errWatchResubscribe = errors.New("watched accounts changed")
+
+var token2022MetadataProgram = solana.MustPublicKeyFromBase58("META4s4fSmpkTbZoUsgC1oBnWB31vQcmnN8giPw51Zu")
+
+const (
+	metadataKey           = 4   //token metadata Key::MetadataV1
+	coreAssetKey          = 1   //metaplex core Key::AssetV1, followed by the owner
+	mintAccountType       = 1   //token-2022 AccountType::Mint
+	mintAccountTypeOffset = 165 //Extended mints are padded to the size of a token account before their account type
+)
+
+// watchedProgram owns accounts metadata is read from, filters limit its subscription to those account types
+type watchedProgram struct {
+	Program solana.PublicKey
+	Filters []rpc.RPCFilter
+}
+
+// watchedPrograms own every account metadata is read from. Without filters the token-2022 subscription
+// alone would stream every token-2022 transfer.
+var watchedPrograms = []watchedProgram{
+	{solana.TokenMetadataProgramID, memcmpFilter(0, metadataKey)},
+	{token2022MetadataProgram, memcmpFilter(0, metadataKey)},
+	{nft_proxy.TOKEN_2022, memcmpFilter(mintAccountTypeOffset, mintAccountType)},
+	{nft_proxy.METAPLEX_CORE, memcmpFilter(0, coreAssetKey)},
+	{libreplex.ProgramID, memcmpFilter(0, libreplex.MetadataDiscriminator...)},
+}
+
+func memcmpFilter(offset uint64, prefix ...byte) []rpc.RPCFilter {
+	return []rpc.RPCFilter{{Memcmp: &rpc.RPCFilterMemcmp{Offset: offset, Bytes: prefix}}}
+}
+
+// watchEvent is a change notification for a single account
+type watchEvent struct {
+	Account solana.PublicKey
+	Slot    uint64
+}
+
+func (svc *WatcherService) Id() string {
+	return WATCHER_SVC
+}
+
+func (svc *WatcherService) Configure(ctx *context.Context) error {
+	if svc.Config == nil {
+		return config.ErrMissingConfig
+	}
+
+	svc.wsURL = svc.Config.RPC.WSURL
+	svc.maxMints = svc.Config.Watcher.MaxMints
+
+	return svc.DefaultService.Configure(ctx)
+}
+
+func (svc *WatcherService) Start() error {
+	svc.sql = svc.DefaultService(SQLITE_SVC).(*SqliteService)
+	svc.img = svc.DefaultService(IMG_SVC).(*ImageService)
+
+	svc.commitment = svc.DefaultService(SOLANA_SVC).(*SolanaService).commitments.Metadata
+	svc.refresh = func(mint string) {
+		err := svc.img.Refresh(mint)
+		if err != nil {
+			log.Printf("Watcher refresh %s err: %s", mint, err)
+		}
+	}
+
+	if svc.wsURL == "" {
+		log.Printf("RPC_WS_URL not set, metadata watcher disabled")
+		return nil
+	}
+
+	svc.init()
+	svc.running.Add(1)
+	go func() {
+		defer svc.running.Done()
+		svc.run()
+	}()
+	return nil
+}
+
+func (svc *WatcherService) init() {
+	if svc.reloadInterval == 0 {
+		svc.reloadInterval = time.Minute
+	}
+	if svc.debounce == 0 {
+		svc.debounce = 2 * time.Second
+	}
+	svc.pending = map[string]struct{}{}
+	svc.stop = make(chan struct{})
+}
+
+// Close ends the subscription session, waiting for in-flight refreshes. Debounced refreshes not yet started are dropped.
+func (svc *WatcherService) Close() {
+	svc.pendingMu.Lock()
+	svc.stopOnce.Do(func() {
+		if svc.stop != nil {
+			close(svc.stop)
+		}
+	})
+	svc.pendingMu.Unlock()
+	svc.running.Wait()
+}
+
+func (svc *WatcherService) stopped() bool {
+	select {
+	case <-svc.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// run keeps a subscription session open, reconnecting with backoff when it drops
+func (svc *WatcherService) run() {
+	backoff := time.Second
+	for {
+		start := time.Now()
+		err := svc.session()
+		if err == nil {
+			return //Stopped
+		}
+
+		if time.Since(start) > time.Minute {
+			backoff = time.Second //Session was healthy, reconnect promptly
+		}
+
+		wait := backoff
+		if errors.Is(err, errWatchResubscribe) {
+			wait = 0
+		} else {
+			log.Printf("Watcher session ended: %s, reconnecting in %s", err, wait)
+			backoff *= 2
+			if backoff > time.Minute {
+				backoff = time.Minute
+			}
+		}
+
+		select {
+		case <-svc.stop:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// session subscribes to every watched account, or the metadata programs when there are too many,
+// handling notifications until the connection fails, Close is called or the watched set changes
+func (svc *WatcherService) session() error {
+	accounts, mints, err := svc.index.reload(svc.sql)
+	if err != nil {
+		return err
+	}
+
+	c, cancel := ctx.WithTimeout(ctx.Background(), 10*time.Second)
+	client, err := ws.Connect(c, svc.wsURL)
+	cancel()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	events := make(chan watchEvent, 256)
+	errs := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+
+	perAccount := mints <= svc.maxMints
+	if perAccount {
+		for _, account := range accounts {
+			sub, err := client.AccountSubscribe(account, svc.commitment)
+			if err != nil {
+				return err
+			}
+			go svc.receiveAccount(sub, account, events, errs, done)
+		}
+	} else {
+		for _, p := range watchedPrograms {
+			sub, err := client.ProgramSubscribeWithOpts(p.Program, svc.commitment, "", p.Filters)
+			if err != nil {
+				return err
+			}
+			go svc.receiveProgram(sub, events, errs, done)
+		}
+	}
+	log.Printf("Watching %v accounts of %v mints (per account subscriptions: %v)", len(accounts), mints, perAccount)
+
+	reload := time.NewTicker(svc.reloadInterval)
+	defer reload.Stop()
+
+	for {
+		select {
+		case <-svc.stop:
+			return nil
+		case err := <-errs:
+			return err
+		case ev := <-events:
+			svc.handle(ev)
+		case <-reload.C:
+			updated, updatedMints, err := svc.index.reload(svc.sql)
+			if err != nil {
+				log.Printf("Watcher reload err: %s", err)
+				continue
+			}
+			//Program subscriptions already cover new mints unless we can drop back to per account
+			if !sameAccounts(accounts, updated) && (perAccount || updatedMints <= svc.maxMints) {
+				return errWatchResubscribe
+			}
+		}
+	}
+}
+
+func (svc *WatcherService) receiveAccount(sub *ws.AccountSubscription, account solana.PublicKey, events chan<- watchEvent, errs chan<- error, done <-chan struct{}) {
+	defer sub.Unsubscribe()
+	for {
+		res, err := sub.Recv()
+		if err != nil {
+			svc.fail(err, errs)
+			return
+		}
+
+		select {
+		case events <- watchEvent{Account: account, Slot: res.Context.Slot}:
+		case <-done:
+			return
+		}
+	}
+}
+
+func (svc *WatcherService) receiveProgram(sub *ws.ProgramSubscription, events chan<- watchEvent, errs chan<- error, done <-chan struct{}) {
+	defer sub.Unsubscribe()
+	for {
+		res, err := sub.Recv()
+		if err != nil {
+			svc.fail(err, errs)
+			return
+		}
+
+		//Programs update far more accounts than we cache, drop them before they reach the queue
+		if _, ok := svc.index.mint(res.Value.Pubkey); !ok {
+			continue
+		}
+
+		select {
+		case events <- watchEvent{Account: res.Value.Pubkey, Slot: res.Context.Slot}:
+		case <-done:
+			return
+		}
+	}
+}
+
+func (svc *WatcherService) fail(err error, errs chan<- error) {
+	if err == nil {
+		err = errors.New("subscription closed")
+	}
+
+	select {
+	case errs <- err:
+	default: //Another subscription already ended the session
+	}
+}
+
+// accountIndex maps the accounts metadata is read from back to their cached mint
+type accountIndex struct {
+	mu       sync.RWMutex
+	watched  map[solana.PublicKey]string   //Account -> mint
+	accounts map[string][]solana.PublicKey //Mint -> accounts
+}
+
+func (idx *accountIndex) mint(account solana.PublicKey) (string, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	mint, ok := idx.watched[account]
+	return mint, ok
+}
+
+// reload picks up the accounts of every cached mint, deriving metadata addresses only for new mints.
+// The number of mints watched is returned with their accounts.
+func (idx *accountIndex) reload(sql *SqliteService) ([]solana.PublicKey, int, error) {
+	var mints []string
+	err := sql.Db().Model(&nft_proxy.SolanaMedia{}).Pluck("mint", &mints).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	watched := make(map[solana.PublicKey]string, len(mints)*3)
+	accounts := make(map[string][]solana.PublicKey, len(mints))
+	for _, mint := range mints {
+		accs, ok := idx.accounts[mint]
+		if !ok {
+			accs, err = watchAccounts(mint)
+			if err != nil {
+				continue
+			}
+		}
+
+		accounts[mint] = accs
+		for _, a := range accs {
+			watched[a] = mint
+		}
+	}
+	idx.watched = watched
+	idx.accounts = accounts
+
+	list := make([]solana.PublicKey, 0, len(watched))
+	for a := range watched {
+		list = append(list, a)
+	}
+	return list, len(accounts), nil
+}
+
+func sameAccounts(a, b []solana.PublicKey) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	set := make(map[solana.PublicKey]struct{}, len(a))
+	for _, k := range a {
+		set[k] = struct{}{}
+	}
+	for _, k := range b {
+		if _, ok := set[k]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// watchAccounts returns every account the metadata of mint is read from
+func watchAccounts(mint string) ([]solana.PublicKey, error) {
+	pk, err := solana.PublicKeyFromBase58(mint)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := []solana.PublicKey{pk} //Token-2022 metadata extension & Core assets live on the mint itself
+	for _, program := range []solana.PublicKey{solana.TokenMetadataProgramID, token2022MetadataProgram} {
+		pda, _, err := solana.FindProgramAddress([][]byte{[]byte("metadata"), program[:], pk[:]}, program)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, pda)
+	}
+
+	pda, _, err := libreplex.FindMetadataAddress(pk)
+	if err != nil {
+		return nil, err
+	}
+	return append(accounts, pda), nil
+}
+
+// handle schedules a refresh of the mint owning the changed account, coalescing bursts of updates
+func (svc *WatcherService) handle(ev watchEvent) {
+	mint, ok := svc.index.mint(ev.Account)
+	if !ok {
+		return
+	}
+
+	svc.pendingMu.Lock()
+	defer svc.pendingMu.Unlock()
+	if _, ok := svc.pending[mint]; ok || svc.stopped() {
+		return
+	}
+	svc.pending[mint] = struct{}{}
+
+	svc.running.Add(1)
+	time.AfterFunc(svc.debounce, func() {
+		defer svc.running.Done()
+
+		svc.pendingMu.Lock()
+		delete(svc.pending, mint)
+		stopped := svc.stopped()
+		svc.pendingMu.Unlock()
+
+		if !stopped {
+			svc.refresh(mint)
+		}
+	})
+}
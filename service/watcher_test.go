@@ -0,0 +1,233 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	nft_proxy "github.com/alphabatem/nft-proxy"
+	"github.com/gagliardetto/solana-go"
+	"github.com/gorilla/websocket"
+)
+
+// wsStandIn is a local Solana websocket endpoint accepting account & program subscriptions
+type wsStandIn struct {
+	*httptest.Server
+
+	mu            sync.Mutex
+	conn          *websocket.Conn
+	subscriptions map[string]uint64 //Subscribed account or program -> subscription id
+	methods       map[string]string //Subscribed account or program -> method
+	filters       map[string]int    //Subscribed program -> number of filters
+	subscribed    chan struct{}
+}
+
+func newWSStandIn(t *testing.T) *wsStandIn {
+	s := &wsStandIn{subscribed: make(chan struct{}, 64)}
+	upgrader := websocket.Upgrader{}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		s.mu.Lock()
+		s.conn = conn
+		s.subscriptions = map[string]uint64{}
+		s.methods = map[string]string{}
+		s.filters = map[string]int{}
+		s.mu.Unlock()
+
+		for {
+			var req struct {
+				ID     uint64        `json:"id"`
+				Method string        `json:"method"`
+				Params []interface{} `json:"params"`
+			}
+			if err := conn.ReadJSON(&req); err != nil {
+				return
+			}
+			if !strings.HasSuffix(req.Method, "Subscribe") {
+				continue
+			}
+
+			s.mu.Lock()
+			key := req.Params[0].(string)
+			subID := uint64(len(s.subscriptions) + 1)
+			s.subscriptions[key] = subID
+			s.methods[key] = req.Method
+			if len(req.Params) > 1 {
+				conf, _ := req.Params[1].(map[string]interface{})
+				filters, _ := conf["filters"].([]interface{})
+				s.filters[key] = len(filters)
+			}
+			conn.WriteJSON(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": subID})
+			s.mu.Unlock()
+
+			s.subscribed <- struct{}{}
+		}
+	}))
+	return s
+}
+
+func (s *wsStandIn) WSURL() string {
+	return "ws" + strings.TrimPrefix(s.URL, "http")
+}
+
+// waitSubscribed waits for n subscriptions on the current connection
+func (s *wsStandIn) waitSubscribed(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-s.subscribed:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for subscription %v of %v", i+1, n)
+		}
+	}
+}
+
+// notify sends an account change for account, via the subscription on key
+func (s *wsStandIn) notify(t *testing.T, key string, account solana.PublicKey, slot uint64) {
+	t.Helper()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subID, ok := s.subscriptions[key]
+	if !ok {
+		t.Fatalf("No subscription for %s", key)
+	}
+
+	acc := map[string]interface{}{"lamports": 1, "owner": solana.TokenMetadataProgramID.String(), "data": []string{"", "base64"}, "executable": false, "rentEpoch": 0}
+	value := interface{}(acc)
+	method := "accountNotification"
+	if s.methods[key] == "programSubscribe" {
+		value = map[string]interface{}{"pubkey": account.String(), "account": acc}
+		method = "programNotification"
+	}
+
+	msg, _ := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  method,
+		"params": map[string]interface{}{
+			"subscription": subID,
+			"result":       map[string]interface{}{"context": map[string]interface{}{"slot": slot}, "value": value},
+		},
+	})
+	s.conn.WriteMessage(websocket.TextMessage, msg)
+}
+
+func (s *wsStandIn) disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conn.Close()
+}
+
+func newTestWatcher(t *testing.T, wsURL string, maxMints int, mints ...string) (*WatcherService, chan string) {
+	t.Helper()
+
+	sql := newTestSqlite(t)
+	for _, mint := range mints {
+		sql.Db().Create(&nft_proxy.SolanaMedia{Mint: mint})
+	}
+
+	refreshed := make(chan string, 16)
+	svc := &WatcherService{
+		wsURL:          wsURL,
+		maxMints:       maxMints,
+		reloadInterval: time.Hour,
+		debounce:       10 * time.Millisecond,
+		sql:            sql,
+		refresh: func(mint string) {
+			refreshed <- mint
+		},
+	}
+	svc.init()
+	t.Cleanup(svc.Close)
+	return svc, refreshed
+}
+
+func expectRefresh(t *testing.T, refreshed chan string, mint string) {
+	t.Helper()
+	select {
+	case m := <-refreshed:
+		if m != mint {
+			t.Fatalf("Expected %s to be refreshed, got %s", mint, m)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for %s to be refreshed", mint)
+	}
+}
+
+func expectNoRefresh(t *testing.T, refreshed chan string) {
+	t.Helper()
+	select {
+	case m := <-refreshed:
+		t.Fatalf("Unexpected refresh of %s", m)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWatcherService(t *testing.T) {
+	mint := "CJ9AXYbSUPoR95oMvWzgCV3GbG3ZubQjFUpRHN7xqAVb"
+	accounts, err := watchAccounts(mint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadataPDA := accounts[1]
+
+	t.Run("Account Subscriptions", func(t *testing.T) {
+		standIn := newWSStandIn(t)
+		defer standIn.Close()
+
+		svc, refreshed := newTestWatcher(t, standIn.WSURL(), 1, mint)
+		go svc.run()
+		standIn.waitSubscribed(t, len(accounts))
+
+		//A burst of updates coalesces into a single refresh
+		for slot := uint64(1); slot <= 3; slot++ {
+			standIn.notify(t, metadataPDA.String(), metadataPDA, slot)
+		}
+		expectRefresh(t, refreshed, mint)
+		expectNoRefresh(t, refreshed)
+
+		t.Run("Reconnect", func(t *testing.T) {
+			standIn.disconnect()
+			standIn.waitSubscribed(t, len(accounts))
+
+			standIn.notify(t, mint, solana.MustPublicKeyFromBase58(mint), 4)
+			expectRefresh(t, refreshed, mint)
+		})
+	})
+
+	t.Run("Program Subscriptions", func(t *testing.T) {
+		standIn := newWSStandIn(t)
+		defer standIn.Close()
+
+		svc, refreshed := newTestWatcher(t, standIn.WSURL(), 0, mint)
+		go svc.run()
+		standIn.waitSubscribed(t, len(watchedPrograms))
+
+		//The node only streams the account types metadata is read from
+		standIn.mu.Lock()
+		for _, p := range watchedPrograms {
+			if standIn.filters[p.Program.String()] == 0 {
+				t.Errorf("Expected %s subscription to be filtered", p.Program)
+			}
+		}
+		standIn.mu.Unlock()
+
+		//Accounts of mints we don't cache are ignored
+		standIn.notify(t, solana.TokenMetadataProgramID.String(), solana.NewWallet().PublicKey(), 1)
+		expectNoRefresh(t, refreshed)
+
+		standIn.notify(t, solana.TokenMetadataProgramID.String(), metadataPDA, 2)
+		expectRefresh(t, refreshed, mint)
+	})
+}
@@ -0,0 +1,318 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alphabatem/nft-proxy/config"
+	"github.com/babilu-online/common/context"
+	"github.com/gagliardetto/solana-go"
+)
+
+// WebhookService accepts signed change notifications from our indexer & refreshes the metadata of the
+// affected mints in the background. Disabled unless a webhook secret is configured.
+//
+// Requests are signed with hex(HMAC-SHA256(secret, timestamp + "." + body)), sent in the
+// X-Webhook-Signature header alongside the unix X-Webhook-Timestamp. Signatures are queued with
+// the mints & resolved by the workers, a transaction that can't be fetched is logged & skipped.
+type WebhookService struct {
+	context.DefaultService
+	Config *config.Config
+
+	secret        []byte
+	workers       int
+	maxAge        time.Duration //Signed requests older than this are rejected as replays
+	indexInterval time.Duration //How often the account index is rebuilt when resolving signatures
+
+	sql    *SqliteService
+	solana *SolanaService
+	img    *ImageService
+
+	refresh             func(mint string)
+	transactionAccounts func(sig solana.Signature) ([]solana.PublicKey, error)
+
+	index        accountIndex
+	indexMu      sync.Mutex
+	indexUpdated time.Time
+
+	queue     chan webhookEntry
+	pendingMu sync.Mutex
+	pending   map[webhookEntry]struct{} //Queued entries, removed once a worker picks them up
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	running  sync.WaitGroup //Workers, waited on by Close so refreshes are not cut off
+}
+
+const WEBHOOK_SVC = "webhook_svc"
+
+const (
+	maxWebhookBody    = 1 << 20
+	maxWebhookEntries = 1000
+)
+
+var (
+	ErrWebhookDisabled = errors.New("webhooks disabled")
+	ErrInvalidWebhook  = errors.New("invalid webhook payload")
+)
+
+// WebhookPayload lists the mints to refresh, directly or via the transactions that changed them
+type WebhookPayload struct {
+	Mints      []string `json:"mints"`
+	Signatures []string `json:"signatures"`
+}
+
+type WebhookResult struct {
+	Accepted  int `json:"accepted"`  //Mints & signatures queued
+	Duplicate int `json:"duplicate"` //Entries already queued or repeated in the payload
+	Dropped   int `json:"dropped"`   //Entries not queued as the queue is full
+}
+
+// webhookEntry is a mint to refresh, or a transaction whose cached mints are refreshed
+type webhookEntry struct {
+	Mint      string
+	Signature solana.Signature
+}
+
+func (svc *WebhookService) Id() string {
+	return WEBHOOK_SVC
+}
+
+func (svc *WebhookService) Configure(ctx *context.Context) error {
+	if svc.Config == nil {
+		return config.ErrMissingConfig
+	}
+
+	svc.secret = []byte(svc.Config.Webhook.Secret)
+	svc.workers = svc.Config.Webhook.Workers
+
+	return svc.DefaultService.Configure(ctx)
+}
+
+func (svc *WebhookService) Start() error {
+	svc.sql = svc.DefaultService(SQLITE_SVC).(*SqliteService)
+	svc.solana = svc.DefaultService(SOLANA_SVC).(*SolanaService)
+	svc.img = svc.DefaultService(IMG_SVC).(*ImageService)
+
+	svc.transactionAccounts = svc.solana.TransactionAccounts
+	svc.refresh = func(mint string) {
+		err := svc.img.Refresh(mint)
+		if err != nil {
+			log.Printf("Webhook refresh %s err: %s", mint, err)
+		}
+	}
+
+	if len(svc.secret) == 0 {
+		log.Printf("WEBHOOK_SECRET not set, metadata webhook disabled")
+		return nil
+	}
+
+	svc.init()
+	return nil
+}
+
+func (svc *WebhookService) init() {
+	if svc.maxAge == 0 {
+		svc.maxAge = 5 * time.Minute
+	}
+	if svc.indexInterval == 0 {
+		svc.indexInterval = time.Minute
+	}
+	svc.queue = make(chan webhookEntry, maxWebhookEntries)
+	svc.pending = map[webhookEntry]struct{}{}
+	svc.stop = make(chan struct{})
+
+	svc.running.Add(svc.workers)
+	for i := 0; i < svc.workers; i++ {
+		go func() {
+			defer svc.running.Done()
+			svc.work()
+		}()
+	}
+}
+
+// Close stops the workers, waiting for in-flight refreshes. Queued entries are dropped.
+func (svc *WebhookService) Close() {
+	svc.stopOnce.Do(func() {
+		if svc.stop != nil {
+			close(svc.stop)
+		}
+	})
+	svc.running.Wait()
+}
+
+func (svc *WebhookService) Enabled() bool {
+	return svc.queue != nil
+}
+
+// Handle verifies & parses a webhook request, queueing its mints & signatures without waiting on the rpc
+func (svc *WebhookService) Handle(header http.Header, body []byte) (*WebhookResult, error) {
+	if !svc.Enabled() {
+		return nil, ErrWebhookDisabled
+	}
+
+	err := svc.verify(header, body, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	var payload WebhookPayload
+	err = json.Unmarshal(body, &payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidWebhook, err)
+	}
+	if len(payload.Mints)+len(payload.Signatures) > maxWebhookEntries {
+		return nil, fmt.Errorf("%w: more than %v entries", ErrInvalidWebhook, maxWebhookEntries)
+	}
+
+	entries := make([]webhookEntry, 0, len(payload.Mints)+len(payload.Signatures))
+	for _, m := range payload.Mints {
+		pk, err := solana.PublicKeyFromBase58(m)
+		if err != nil {
+			return nil, fmt.Errorf("%w: mint %s", ErrInvalidWebhook, m)
+		}
+		entries = append(entries, webhookEntry{Mint: pk.String()})
+	}
+	for _, s := range payload.Signatures {
+		sig, err := solana.SignatureFromBase58(s)
+		if err != nil {
+			return nil, fmt.Errorf("%w: signature %s", ErrInvalidWebhook, s)
+		}
+		entries = append(entries, webhookEntry{Signature: sig})
+	}
+
+	return svc.enqueue(entries), nil
+}
+
+// verify checks the request was signed with our secret within maxAge of now
+func (svc *WebhookService) verify(header http.Header, body []byte, now time.Time) error {
+	ts := header.Get("X-Webhook-Timestamp")
+	sent, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ErrUnauthorized
+	}
+
+	age := now.Sub(time.Unix(sent, 0))
+	if age > svc.maxAge || age < -svc.maxAge {
+		return ErrUnauthorized
+	}
+
+	signature, err := hex.DecodeString(strings.TrimPrefix(header.Get("X-Webhook-Signature"), "sha256="))
+	if err != nil {
+		return ErrUnauthorized
+	}
+
+	mac := hmac.New(sha256.New, svc.secret)
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+// signatureMints returns the cached mints whose accounts were referenced by the transaction sig
+func (svc *WebhookService) signatureMints(sig solana.Signature) ([]string, error) {
+	accounts, err := svc.transactionAccounts(sig)
+	if err != nil {
+		return nil, err
+	}
+
+	svc.indexMu.Lock()
+	if time.Since(svc.indexUpdated) > svc.indexInterval {
+		_, _, err = svc.index.reload(svc.sql)
+		if err == nil {
+			svc.indexUpdated = time.Now()
+		}
+	}
+	svc.indexMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var mints []string
+	for _, a := range accounts {
+		if mint, ok := svc.index.mint(a); ok {
+			mints = append(mints, mint)
+		}
+	}
+	return mints, nil
+}
+
+func (svc *WebhookService) enqueue(entries []webhookEntry) *WebhookResult {
+	var res WebhookResult
+
+	svc.pendingMu.Lock()
+	defer svc.pendingMu.Unlock()
+
+	for _, entry := range entries {
+		if _, ok := svc.pending[entry]; ok {
+			res.Duplicate++
+			continue
+		}
+
+		select {
+		case svc.queue <- entry:
+			svc.pending[entry] = struct{}{}
+			res.Accepted++
+		default:
+			res.Dropped++
+		}
+	}
+
+	if res.Dropped > 0 {
+		log.Printf("Webhook queue full, dropped %v entries", res.Dropped)
+	}
+	return &res
+}
+
+// mintEntries queues the cached mints changed by a transaction
+func mintEntries(mints []string) []webhookEntry {
+	entries := make([]webhookEntry, len(mints))
+	for i, mint := range mints {
+		entries[i] = webhookEntry{Mint: mint}
+	}
+	return entries
+}
+
+func (svc *WebhookService) work() {
+	for {
+		select {
+		case <-svc.stop:
+			return
+		case entry := <-svc.queue:
+			select {
+			case <-svc.stop:
+				return //Both were ready, stop takes priority
+			default:
+			}
+
+			//Changes arriving while we refresh queue another refresh rather than being lost
+			svc.pendingMu.Lock()
+			delete(svc.pending, entry)
+			svc.pendingMu.Unlock()
+
+			if entry.Mint != "" {
+				svc.refresh(entry.Mint)
+				continue
+			}
+
+			mints, err := svc.signatureMints(entry.Signature)
+			if err != nil {
+				log.Printf("Webhook signature %s err: %s", entry.Signature, err)
+				continue
+			}
+			svc.enqueue(mintEntries(mints))
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	nft_proxy "github.com/alphabatem/nft-proxy"
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+)
+
+func signWebhook(secret string, ts time.Time, body []byte) http.Header {
+	timestamp := strconv.FormatInt(ts.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	h := http.Header{}
+	h.Set("X-Webhook-Timestamp", timestamp)
+	h.Set("X-Webhook-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	return h
+}
+
+// newTestWebhook returns a webhook service without workers, so queued entries stay pending
+func newTestWebhook(t *testing.T, queueSize int, accounts map[solana.Signature][]solana.PublicKey, mints ...string) *WebhookService {
+	t.Helper()
+
+	sql := newTestSqlite(t)
+	for _, mint := range mints {
+		sql.Db().Create(&nft_proxy.SolanaMedia{Mint: mint})
+	}
+
+	return &WebhookService{
+		secret:        []byte("secret"),
+		maxAge:        5 * time.Minute,
+		indexInterval: time.Minute,
+		sql:           sql,
+		transactionAccounts: func(sig solana.Signature) ([]solana.PublicKey, error) {
+			accs, ok := accounts[sig]
+			if !ok {
+				return nil, rpc.ErrNotFound
+			}
+			return accs, nil
+		},
+		queue:   make(chan webhookEntry, queueSize),
+		pending: map[webhookEntry]struct{}{},
+	}
+}
+
+func TestWebhookService_Verify(t *testing.T) {
+	svc := WebhookService{secret: []byte("secret"), maxAge: 5 * time.Minute}
+	body := []byte(`{"mints":[]}`)
+	now := time.Now()
+
+	if err := svc.verify(signWebhook("secret", now, body), body, now); err != nil {
+		t.Fatalf("Expected valid signature, got %v", err)
+	}
+
+	tests := map[string]http.Header{
+		"Wrong Secret": signWebhook("other", now, body),
+		"Stale":        signWebhook("secret", now.Add(-10*time.Minute), body),
+		"Tampered":     signWebhook("secret", now, []byte(`{"mints":["x"]}`)),
+		"Missing":      {},
+	}
+	for name, header := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := svc.verify(header, body, now); !errors.Is(err, ErrUnauthorized) {
+				t.Fatalf("Expected unauthorized, got %v", err)
+			}
+		})
+	}
+}
+
+func TestWebhookService_Handle(t *testing.T) {
+	cached := "CJ9AXYbSUPoR95oMvWzgCV3GbG3ZubQjFUpRHN7xqAVb"
+	uncached := solana.NewWallet().PublicKey().String()
+
+	accounts, err := watchAccounts(cached)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig := solana.SignatureFromBytes(make([]byte, 64))
+	svc := newTestWebhook(t, 10, map[solana.Signature][]solana.PublicKey{
+		sig: {solana.NewWallet().PublicKey(), accounts[1]}, //Metadata update only references the metadata account
+	}, cached)
+
+	handle := func(t *testing.T, payload WebhookPayload) (*WebhookResult, error) {
+		t.Helper()
+		body, _ := json.Marshal(payload)
+		return svc.Handle(signWebhook("secret", time.Now(), body), body)
+	}
+
+	t.Run("Accepted", func(t *testing.T) {
+		res, err := handle(t, WebhookPayload{Mints: []string{uncached, uncached}, Signatures: []string{sig.String()}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Accepted != 2 || res.Duplicate != 1 || len(svc.queue) != 2 {
+			t.Fatalf("Unexpected result %+v", res)
+		}
+	})
+
+	t.Run("Duplicate", func(t *testing.T) {
+		res, err := handle(t, WebhookPayload{Mints: []string{uncached}, Signatures: []string{sig.String()}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Accepted != 0 || res.Duplicate != 2 {
+			t.Fatalf("Expected queued mint & signature to be duplicates, got %+v", res)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		if _, err := handle(t, WebhookPayload{Mints: []string{"not-a-mint"}}); !errors.Is(err, ErrInvalidWebhook) {
+			t.Fatalf("Expected invalid mint to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("Invalid Signature", func(t *testing.T) {
+		if _, err := handle(t, WebhookPayload{Signatures: []string{"not-a-signature"}}); !errors.Is(err, ErrInvalidWebhook) {
+			t.Fatalf("Expected invalid signature to be rejected, got %v", err)
+		}
+		if len(svc.queue) != 2 {
+			t.Fatal("Expected nothing to be queued from a rejected payload")
+		}
+	})
+
+	t.Run("Dropped", func(t *testing.T) {
+		full := newTestWebhook(t, 1, nil)
+		res := full.enqueue(mintEntries([]string{cached, uncached}))
+		if res.Accepted != 1 || res.Dropped != 1 {
+			t.Fatalf("Expected mint beyond the queue size to be dropped, got %+v", res)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		if _, err := (&WebhookService{}).Handle(http.Header{}, nil); !errors.Is(err, ErrWebhookDisabled) {
+			t.Fatalf("Expected disabled webhook, got %v", err)
+		}
+	})
+}
+
+func TestWebhookService_Work(t *testing.T) {
+	cached := "CJ9AXYbSUPoR95oMvWzgCV3GbG3ZubQjFUpRHN7xqAVb"
+	accounts, err := watchAccounts(cached)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig := solana.SignatureFromBytes(make([]byte, 64))
+	unresolved := solana.SignatureFromBytes(append(make([]byte, 63), 1))
+	svc := newTestWebhook(t, 10, map[solana.Signature][]solana.PublicKey{
+		sig: {solana.NewWallet().PublicKey(), accounts[1]},
+	}, cached)
+
+	refreshed := make(chan string, 10)
+	svc.workers = 1
+	svc.refresh = func(mint string) {
+		refreshed <- mint
+	}
+	svc.init()
+	defer svc.Close()
+
+	//A transaction that can't be fetched is skipped without holding up the rest of the queue
+	res := svc.enqueue([]webhookEntry{{Signature: unresolved}, {Signature: sig}})
+	if res.Accepted != 2 {
+		t.Fatalf("Unexpected result %+v", res)
+	}
+
+	select {
+	case mint := <-refreshed:
+		if mint != cached {
+			t.Fatalf("Expected %s to be refreshed, got %s", cached, mint)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the signature to be resolved")
+	}
+
+	select {
+	case mint := <-refreshed:
+		t.Fatalf("Unexpected refresh of %s", mint)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestWebhookService_Close(t *testing.T) {
+	started := make(chan string, 1)
+	release := make(chan struct{})
+	svc := &WebhookService{workers: 1, refresh: func(mint string) {
+		started <- mint
+		<-release
+	}}
+	svc.init()
+
+	mint := solana.NewWallet().PublicKey().String()
+	svc.enqueue(mintEntries([]string{mint}))
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the refresh to start")
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		svc.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+		t.Fatal("Expected Close to wait for the in-flight refresh")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for Close")
+	}
+
+	//Nothing is refreshed once closed
+	svc.enqueue(mintEntries([]string{solana.NewWallet().PublicKey().String()}))
+	select {
+	case m := <-started:
+		t.Fatalf("Unexpected refresh of %s after Close", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSolanaService_TransactionAccounts(t *testing.T) {
+	payer := solana.NewWallet().PublicKey()
+	metadata := solana.NewWallet().PublicKey()
+	loaded := solana.NewWallet().PublicKey()
+
+	tx, err := solana.NewTransaction([]solana.Instruction{
+		solana.NewInstruction(solana.TokenMetadataProgramID, solana.AccountMetaSlice{solana.Meta(metadata).WRITE()}, []byte{15}),
+	}, solana.Hash{}, solana.TransactionPayer(payer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := tx.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "result": map[string]interface{}{
+			"slot":        10,
+			"transaction": []string{base64.StdEncoding.EncodeToString(raw), "base64"},
+			"meta":        map[string]interface{}{"err": nil, "loadedAddresses": map[string]interface{}{"writable": []string{loaded.String()}, "readonly": []string{}}},
+			"version":     0,
+		}})
+	}))
+	defer node.Close()
+
+	svc := SolanaService{client: rpc.New(node.URL), commitments: defaultRPCCommitments}
+	accounts, err := svc.TransactionAccounts(solana.Signature{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := map[solana.PublicKey]bool{}
+	for _, a := range accounts {
+		found[a] = true
+	}
+	if !found[payer] || !found[metadata] || !found[loaded] {
+		t.Fatalf("Expected payer, instruction & lookup table accounts, got %v", accounts)
+	}
+}
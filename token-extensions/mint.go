@@ -0,0 +1,47 @@
+package token_extensions
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/gagliardetto/solana-go"
+)
+
+var ErrInvalidMint = errors.New("invalid mint account")
+
+// Mint is the base mint layout shared by the token & token-2022 programs
+type Mint struct {
+	MintAuthority   *solana.PublicKey
+	Supply          uint64
+	Decimals        uint8
+	IsInitialized   bool
+	FreezeAuthority *solana.PublicKey
+}
+
+// DecodeMint reads the base mint from a token or token-2022 mint account
+func DecodeMint(data []byte) (*Mint, error) {
+	if len(data) < mintSize {
+		return nil, ErrInvalidMint
+	}
+
+	m := Mint{
+		MintAuthority:   readCOptionPublicKey(data[0:36]),
+		Supply:          binary.LittleEndian.Uint64(data[36:44]),
+		Decimals:        data[44],
+		IsInitialized:   data[45] == 1,
+		FreezeAuthority: readCOptionPublicKey(data[46:82]),
+	}
+	if !m.IsInitialized {
+		return nil, ErrInvalidMint
+	}
+	return &m, nil
+}
+
+// readCOptionPublicKey reads a COption<Pubkey>, a u32 tag followed by the key
+func readCOptionPublicKey(b []byte) *solana.PublicKey {
+	if binary.LittleEndian.Uint32(b) != 1 {
+		return nil
+	}
+	pk := solana.PublicKeyFromBytes(b[4:36])
+	return &pk
+}
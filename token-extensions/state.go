@@ -0,0 +1,281 @@
+package token_extensions
+
+import (
+	"encoding/binary"
+	"errors"
+
+	bin "github.com/gagliardetto/binary"
+	"github.com/gagliardetto/solana-go"
+)
+
+// ExtensionType identifies a Token-2022 extension in the TLV data following the base mint
+type ExtensionType uint16
+
+const (
+	ExtensionUninitialized      ExtensionType = 0
+	ExtensionTransferFeeConfig  ExtensionType = 1
+	ExtensionNonTransferable    ExtensionType = 9
+	ExtensionPermanentDelegate  ExtensionType = 12
+	ExtensionTransferHook       ExtensionType = 14
+	ExtensionMetadataPointer    ExtensionType = 18
+	ExtensionTokenMetadata      ExtensionType = 19
+	ExtensionGroupPointer       ExtensionType = 20
+	ExtensionTokenGroup         ExtensionType = 21
+	ExtensionGroupMemberPointer ExtensionType = 22
+	ExtensionTokenGroupMember   ExtensionType = 23
+)
+
+const (
+	mintSize          = 82
+	accountTypeOffset = 165 //Base mints are padded to the size of a token account before the account type
+	accountTypeMint   = 1
+)
+
+var ErrInvalidExtensions = errors.New("invalid token-2022 extension data")
+
+type TransferFee struct {
+	Epoch       uint64
+	MaximumFee  uint64
+	BasisPoints uint16
+}
+
+type TransferFeeConfig struct {
+	ConfigAuthority   *solana.PublicKey
+	WithdrawAuthority *solana.PublicKey
+	WithheldAmount    uint64
+	OlderTransferFee  TransferFee
+	NewerTransferFee  TransferFee //Takes effect from its epoch
+}
+
+type Pointer struct {
+	Authority *solana.PublicKey
+	Address   *solana.PublicKey
+}
+
+type TransferHook struct {
+	Authority *solana.PublicKey
+	ProgramId *solana.PublicKey
+}
+
+type TokenGroup struct {
+	UpdateAuthority *solana.PublicKey
+	Mint            solana.PublicKey
+	Size            uint64
+	MaxSize         uint64
+}
+
+type TokenGroupMember struct {
+	Mint         solana.PublicKey
+	Group        solana.PublicKey
+	MemberNumber uint64
+}
+
+// MetadataField is an additional key/value pair of the token metadata extension
+type MetadataField struct {
+	Key   string
+	Value string
+}
+
+type TokenMetadata struct {
+	UpdateAuthority    *solana.PublicKey
+	Mint               solana.PublicKey
+	Name               string
+	Symbol             string
+	Uri                string
+	AdditionalMetadata []MetadataField
+}
+
+// Extensions holds the mint extensions we decode, nil fields are not present on the mint
+type Extensions struct {
+	TransferFeeConfig  *TransferFeeConfig
+	NonTransferable    bool
+	PermanentDelegate  *solana.PublicKey
+	TransferHook       *TransferHook
+	MetadataPointer    *Pointer
+	TokenMetadata      *TokenMetadata
+	GroupPointer       *Pointer
+	TokenGroup         *TokenGroup
+	GroupMemberPointer *Pointer
+	TokenGroupMember   *TokenGroupMember
+}
+
+// Decode reads the extensions of a Token-2022 mint account, returning nil for mints without any
+func Decode(data []byte) (*Extensions, error) {
+	if len(data) <= mintSize {
+		return nil, nil
+	}
+	if len(data) <= accountTypeOffset || data[accountTypeOffset] != accountTypeMint {
+		return nil, ErrInvalidExtensions
+	}
+
+	var exts Extensions
+	tlv := data[accountTypeOffset+1:]
+	for len(tlv) >= 4 {
+		typ := ExtensionType(binary.LittleEndian.Uint16(tlv))
+		length := int(binary.LittleEndian.Uint16(tlv[2:]))
+		if typ == ExtensionUninitialized {
+			break
+		}
+		if len(tlv) < 4+length {
+			return nil, ErrInvalidExtensions
+		}
+
+		err := exts.decode(typ, bin.NewBinDecoder(tlv[4:4+length]))
+		if err != nil {
+			return nil, err
+		}
+		tlv = tlv[4+length:]
+	}
+	return &exts, nil
+}
+
+func (exts *Extensions) decode(typ ExtensionType, dec *bin.Decoder) (err error) {
+	switch typ {
+	case ExtensionTransferFeeConfig:
+		var c TransferFeeConfig
+		if c.ConfigAuthority, err = readOptionalPublicKey(dec); err != nil {
+			return err
+		}
+		if c.WithdrawAuthority, err = readOptionalPublicKey(dec); err != nil {
+			return err
+		}
+		if c.WithheldAmount, err = dec.ReadUint64(bin.LE); err != nil {
+			return err
+		}
+		for _, fee := range []*TransferFee{&c.OlderTransferFee, &c.NewerTransferFee} {
+			if fee.Epoch, err = dec.ReadUint64(bin.LE); err != nil {
+				return err
+			}
+			if fee.MaximumFee, err = dec.ReadUint64(bin.LE); err != nil {
+				return err
+			}
+			if fee.BasisPoints, err = dec.ReadUint16(bin.LE); err != nil {
+				return err
+			}
+		}
+		exts.TransferFeeConfig = &c
+	case ExtensionNonTransferable:
+		exts.NonTransferable = true
+	case ExtensionPermanentDelegate:
+		exts.PermanentDelegate, err = readOptionalPublicKey(dec)
+	case ExtensionTransferHook:
+		var h TransferHook
+		if h.Authority, err = readOptionalPublicKey(dec); err != nil {
+			return err
+		}
+		h.ProgramId, err = readOptionalPublicKey(dec)
+		exts.TransferHook = &h
+	case ExtensionMetadataPointer:
+		exts.MetadataPointer, err = readPointer(dec)
+	case ExtensionGroupPointer:
+		exts.GroupPointer, err = readPointer(dec)
+	case ExtensionGroupMemberPointer:
+		exts.GroupMemberPointer, err = readPointer(dec)
+	case ExtensionTokenGroup:
+		var g TokenGroup
+		if g.UpdateAuthority, err = readOptionalPublicKey(dec); err != nil {
+			return err
+		}
+		if g.Mint, err = readPublicKey(dec); err != nil {
+			return err
+		}
+		if g.Size, err = dec.ReadUint64(bin.LE); err != nil {
+			return err
+		}
+		g.MaxSize, err = dec.ReadUint64(bin.LE)
+		exts.TokenGroup = &g
+	case ExtensionTokenGroupMember:
+		var m TokenGroupMember
+		if m.Mint, err = readPublicKey(dec); err != nil {
+			return err
+		}
+		if m.Group, err = readPublicKey(dec); err != nil {
+			return err
+		}
+		m.MemberNumber, err = dec.ReadUint64(bin.LE)
+		exts.TokenGroupMember = &m
+	case ExtensionTokenMetadata:
+		exts.TokenMetadata, err = readTokenMetadata(dec)
+	}
+	return err
+}
+
+func readTokenMetadata(dec *bin.Decoder) (*TokenMetadata, error) {
+	var m TokenMetadata
+	var err error
+	if m.UpdateAuthority, err = readOptionalPublicKey(dec); err != nil {
+		return nil, err
+	}
+	if m.Mint, err = readPublicKey(dec); err != nil {
+		return nil, err
+	}
+	for _, s := range []*string{&m.Name, &m.Symbol, &m.Uri} {
+		if *s, err = readString(dec); err != nil {
+			return nil, err
+		}
+	}
+
+	count, err := dec.ReadUint32(bin.LE)
+	if err != nil {
+		return nil, err
+	}
+	if int(count) > dec.Remaining()/8 { //Each field is at least two length prefixes
+		return nil, ErrInvalidExtensions
+	}
+
+	m.AdditionalMetadata = make([]MetadataField, count)
+	for i := range m.AdditionalMetadata {
+		if m.AdditionalMetadata[i].Key, err = readString(dec); err != nil {
+			return nil, err
+		}
+		if m.AdditionalMetadata[i].Value, err = readString(dec); err != nil {
+			return nil, err
+		}
+	}
+	return &m, nil
+}
+
+func readPointer(dec *bin.Decoder) (*Pointer, error) {
+	var p Pointer
+	var err error
+	if p.Authority, err = readOptionalPublicKey(dec); err != nil {
+		return nil, err
+	}
+	if p.Address, err = readOptionalPublicKey(dec); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// readString reads a borsh string, prefixed with a u32 length
+func readString(dec *bin.Decoder) (string, error) {
+	size, err := dec.ReadUint32(bin.LE)
+	if err != nil {
+		return "", err
+	}
+	if int(size) > dec.Remaining() {
+		return "", ErrInvalidExtensions
+	}
+	b, err := dec.ReadBytes(int(size))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func readPublicKey(dec *bin.Decoder) (solana.PublicKey, error) {
+	b, err := dec.ReadBytes(32)
+	if err != nil {
+		return solana.PublicKey{}, err
+	}
+	return solana.PublicKeyFromBytes(b), nil
+}
+
+// readOptionalPublicKey reads an OptionalNonZeroPubkey, where all zeroes is none
+func readOptionalPublicKey(dec *bin.Decoder) (*solana.PublicKey, error) {
+	pk, err := readPublicKey(dec)
+	if err != nil || pk.IsZero() {
+		return nil, err
+	}
+	return &pk, nil
+}
@@ -0,0 +1,230 @@
+package token_metadata
+
+import (
+	"errors"
+	"fmt"
+
+	bin "github.com/gagliardetto/binary"
+	token_metadata "github.com/gagliardetto/metaplex-go/clients/token-metadata"
+	"github.com/gagliardetto/solana-go"
+)
+
+var (
+	errLength = errors.New("length exceeds the remaining data")
+	errOption = errors.New("option flag is neither 0 nor 1")
+)
+
+// DecodeMetadata decodes a metaplex metadata account
+func DecodeMetadata(data []byte) (*Metadata, error) {
+	var m Metadata
+	err := m.UnmarshalWithDecoder(bin.NewBinDecoder(data))
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// UnmarshalWithDecoder decodes a metaplex metadata account. The reflection based decoder skips the option
+// flag of fields with their own decoder, so every field is read by hand.
+func (m *Metadata) UnmarshalWithDecoder(dec *bin.Decoder) (err error) {
+	defer func() { err = invalid(err) }()
+
+	key, err := dec.ReadUint8()
+	if err != nil {
+		return err
+	}
+	m.Key = token_metadata.Key(key)
+	if m.Key != token_metadata.KeyMetadataV1 {
+		return fmt.Errorf("%w: key %d", ErrInvalidMetadata, key)
+	}
+	if m.UpdateAuthority, err = readPublicKey(dec); err != nil {
+		return err
+	}
+	if m.Mint, err = readPublicKey(dec); err != nil {
+		return err
+	}
+	if err = m.Data.unmarshal(dec); err != nil {
+		return err
+	}
+	if m.PrimarySaleHappened, err = readBool(dec); err != nil {
+		return err
+	}
+	if m.IsMutable, err = readBool(dec); err != nil {
+		return err
+	}
+
+	//Fields added in later versions, accounts created before them end here. Accounts updated with shorter data
+	//keep stale bytes after the fields in use, so as in the metaplex program a field that fails to decode is
+	//left unset rather than failing the account.
+	err = readOptional(dec, func() error {
+		nonce, err := dec.ReadUint8()
+		m.EditionNonce = &nonce
+		return err
+	})
+	if err != nil {
+		m.EditionNonce = nil
+	}
+
+	//Stale bytes can decode as a valid collection, so these are dropped together when any of them fails
+	errStandard := readOptional(dec, func() error {
+		standard, err := dec.ReadUint8()
+		if err != nil {
+			return err
+		}
+		if TokenStandard(standard) > TokenStandardProgrammableNonFungibleEdition {
+			return fmt.Errorf("%w: token standard %d", ErrInvalidMetadata, standard)
+		}
+		ts := TokenStandard(standard)
+		m.TokenStandard = &ts
+		return nil
+	})
+	errCollection := readOptional(dec, func() (err error) {
+		m.Collection = new(token_metadata.Collection)
+		if m.Collection.Verified, err = readBool(dec); err != nil {
+			return err
+		}
+		m.Collection.Key, err = readPublicKey(dec)
+		return err
+	})
+	errUses := readOptional(dec, func() error {
+		m.Uses = new(token_metadata.Uses)
+		return readUses(dec, m.Uses)
+	})
+	if errStandard != nil || errCollection != nil || errUses != nil {
+		m.TokenStandard, m.Collection, m.Uses = nil, nil, nil
+	}
+
+	err = readOptional(dec, func() error {
+		m.CollectionDetails = new(CollectionDetails)
+		return m.CollectionDetails.UnmarshalWithDecoder(dec)
+	})
+	if err != nil {
+		m.CollectionDetails = nil
+	}
+
+	err = readOptional(dec, func() error {
+		m.ProgrammableConfig = new(ProgrammableConfig)
+		return m.ProgrammableConfig.UnmarshalWithDecoder(dec)
+	})
+	if err != nil {
+		m.ProgrammableConfig = nil
+	}
+	return nil
+}
+
+func (d *Data) unmarshal(dec *bin.Decoder) (err error) {
+	for _, s := range []*string{&d.Name, &d.Symbol, &d.Uri} {
+		if *s, err = readString(dec); err != nil {
+			return err
+		}
+	}
+
+	bps, err := dec.ReadUint16(bin.LE)
+	if err != nil {
+		return err
+	}
+	d.SellerFeeBasisPoints = SellerFeeBasisPoints(bps)
+
+	hasCreators, err := readBool(dec)
+	if err != nil || !hasCreators {
+		return err
+	}
+	count, err := readLength(dec, 34) //Address, verified & share
+	if err != nil {
+		return err
+	}
+	d.Creators = make([]token_metadata.Creator, count)
+	for i := range d.Creators {
+		if d.Creators[i].Address, err = readPublicKey(dec); err != nil {
+			return err
+		}
+		if d.Creators[i].Verified, err = readBool(dec); err != nil {
+			return err
+		}
+		if d.Creators[i].Share, err = dec.ReadUint8(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readUses(dec *bin.Decoder, uses *token_metadata.Uses) error {
+	method, err := dec.ReadUint8()
+	if err != nil {
+		return err
+	}
+	if token_metadata.UseMethod(method) > token_metadata.UseMethodSingle {
+		return fmt.Errorf("%w: use method %d", ErrInvalidMetadata, method)
+	}
+	uses.UseMethod = token_metadata.UseMethod(method)
+	if uses.Remaining, err = dec.ReadUint64(bin.LE); err != nil {
+		return err
+	}
+	uses.Total, err = dec.ReadUint64(bin.LE)
+	return err
+}
+
+// readOptional reads a trailing borsh option, calling read when it is present. Data ending before it reads as absent.
+func readOptional(dec *bin.Decoder, read func() error) error {
+	if dec.Remaining() == 0 {
+		return nil
+	}
+	present, err := readBool(dec)
+	if err != nil || !present {
+		return err
+	}
+	return read()
+}
+
+// readBool reads a borsh bool or option flag, rejecting anything but 0 or 1
+func readBool(dec *bin.Decoder) (bool, error) {
+	b, err := dec.ReadUint8()
+	if err != nil {
+		return false, err
+	}
+	if b > 1 {
+		return false, errOption
+	}
+	return b == 1, nil
+}
+
+// readLength reads a borsh vec length, rejecting lengths the remaining data cannot hold
+func readLength(dec *bin.Decoder, itemSize int) (int, error) {
+	count, err := dec.ReadUint32(bin.LE)
+	if err != nil {
+		return 0, err
+	}
+	if int(count) > dec.Remaining()/itemSize {
+		return 0, errLength
+	}
+	return int(count), nil
+}
+
+// readString reads a borsh string, prefixed with a u32 length
+func readString(dec *bin.Decoder) (string, error) {
+	size, err := readLength(dec, 1)
+	if err != nil {
+		return "", err
+	}
+	b, err := dec.ReadBytes(size)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func readPublicKey(dec *bin.Decoder) (solana.PublicKey, error) {
+	b, err := dec.ReadBytes(32)
+	if err != nil {
+		return solana.PublicKey{}, err
+	}
+	return solana.PublicKeyFromBytes(b), nil
+}
+
+// invalid wraps decoding errors, such as running out of data, in ErrInvalidMetadata
+func invalid(err error) error {
+	if err == nil || errors.Is(err, ErrInvalidMetadata) {
+		return err
+	}
+	return fmt.Errorf("%w: %s", ErrInvalidMetadata, err)
+}
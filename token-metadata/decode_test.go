@@ -0,0 +1,172 @@
+package token_metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	token_metadata "github.com/gagliardetto/metaplex-go/clients/token-metadata"
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+)
+
+// loadAccount reads the data of a getAccountInfo shaped account fixture
+func loadAccount(t testing.TB, name string) []byte {
+	t.Helper()
+	raw, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var acc rpc.KeyedAccount
+	if err := json.Unmarshal(raw, &acc); err != nil {
+		t.Fatal(err)
+	}
+	return acc.Account.Data.GetBinary()
+}
+
+// trim strips the null padding metaplex allocates strings with
+func trim(s string) string {
+	return strings.TrimRight(s, "\x00")
+}
+
+func TestMetadata_Golden(t *testing.T) {
+	t.Run("Programmable", func(t *testing.T) {
+		meta, err := DecodeMetadata(loadAccount(t, "metadata_pnft.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if trim(meta.Data.Name) != "Programmable #7" || trim(meta.Data.Symbol) != "PNFT" || meta.Data.SellerFeeBasisPoints != 500 || len(meta.Data.Creators) != 2 {
+			t.Fatalf("Unexpected data %+v", meta.Data)
+		}
+		if !meta.Data.Creators[0].Verified || meta.Data.Creators[1].Verified || meta.Data.Creators[1].Share != 100 {
+			t.Fatalf("Unexpected creators %+v", meta.Data.Creators)
+		}
+		if !meta.PrimarySaleHappened || !meta.IsMutable || meta.EditionNonce == nil || *meta.EditionNonce != 253 {
+			t.Fatalf("Unexpected flags %+v", meta)
+		}
+		if meta.TokenStandard == nil || *meta.TokenStandard != TokenStandardProgrammableNonFungible || meta.Collection == nil || !meta.Collection.Verified {
+			t.Fatalf("Unexpected standard or collection %+v", meta)
+		}
+		if meta.ProgrammableConfig == nil || meta.ProgrammableConfig.RuleSet == nil || *meta.ProgrammableConfig.RuleSet != solana.MustPublicKeyFromBase58("eBJLFYPxJmMGKuFwpDWkzxZeUrad92kZRC5BJLpzyT9") {
+			t.Fatalf("Unexpected programmable config %+v", meta.ProgrammableConfig)
+		}
+	})
+
+	t.Run("Collection", func(t *testing.T) {
+		meta, err := DecodeMetadata(loadAccount(t, "metadata_collection.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if meta.PrimarySaleHappened || meta.Collection != nil || meta.Uses != nil || meta.ProgrammableConfig != nil {
+			t.Fatalf("Unexpected metadata %+v", meta)
+		}
+		if meta.CollectionDetails == nil || meta.CollectionDetails.Size != 3333 {
+			t.Fatalf("Unexpected collection details %+v", meta.CollectionDetails)
+		}
+	})
+
+	t.Run("Legacy", func(t *testing.T) {
+		meta, err := DecodeMetadata(loadAccount(t, "metadata_legacy.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if meta.IsMutable || meta.EditionNonce != nil || meta.TokenStandard != nil || len(meta.Data.Creators) != 3 || meta.Data.Creators[2].Share != 20 {
+			t.Fatalf("Unexpected metadata %+v", meta)
+		}
+	})
+
+	t.Run("Stale Trailing Bytes", func(t *testing.T) {
+		//Updated down to one creator, the bytes of the other two are left after is_mutable
+		meta, err := DecodeMetadata(loadAccount(t, "metadata_legacy_stale.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if trim(meta.Data.Name) != "Legacy #1" || trim(meta.Data.Uri) != "https://arweave.net/legacy-1.json" || len(meta.Data.Creators) != 1 || meta.Data.Creators[0].Share != 100 {
+			t.Fatalf("Unexpected data %+v", meta.Data)
+		}
+		if meta.EditionNonce != nil || meta.TokenStandard != nil || meta.Collection != nil || meta.Uses != nil {
+			t.Fatalf("Expected stale bytes to be ignored, got %+v", meta)
+		}
+	})
+
+	t.Run("Editions", func(t *testing.T) {
+		//Master editions are allocated their max length, zero padded past the max supply
+		data := loadAccount(t, "master_edition.json")
+		if len(data) != 282 {
+			t.Fatalf("Expected a 282 byte master edition, got %v", len(data))
+		}
+		master, err := DecodeEdition(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !master.IsMaster() || master.Supply != 42 || master.MaxSupply == nil || *master.MaxSupply != 100 {
+			t.Fatalf("Unexpected master edition %+v", master)
+		}
+
+		print, err := DecodeEdition(loadAccount(t, "print_edition.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if print.IsMaster() || print.Key != token_metadata.KeyEditionV1 || print.Number != 17 || print.Parent.IsZero() {
+			t.Fatalf("Unexpected print edition %+v", print)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		data := loadAccount(t, "metadata_pnft.json")
+		for name, b := range map[string][]byte{
+			"Empty":     nil,
+			"Truncated": data[:100],
+			"Key":       append([]byte{6}, data[1:]...),
+			"Name":      append(append([]byte{}, data[:65]...), 0xff, 0xff, 0xff, 0x7f),
+		} {
+			if _, err := DecodeMetadata(b); !errors.Is(err, ErrInvalidMetadata) {
+				t.Fatalf("%s: expected ErrInvalidMetadata, got %v", name, err)
+			}
+		}
+		if _, err := DecodeEdition([]byte{4}); !errors.Is(err, ErrInvalidMetadata) {
+			t.Fatalf("Expected ErrInvalidMetadata for a metadata key, got %v", err)
+		}
+	})
+}
+
+func seedAccounts(f *testing.F, names ...string) {
+	for _, name := range names {
+		data := loadAccount(f, name)
+		f.Add(data)
+		for _, n := range []int{1, 65, len(data) / 2, len(data) - 1} {
+			if n < len(data) {
+				f.Add(data[:n])
+			}
+		}
+	}
+}
+
+func FuzzMetadata(f *testing.F) {
+	seedAccounts(f, "metadata_pnft.json", "metadata_collection.json", "metadata_legacy.json", "metadata_legacy_stale.json")
+	f.Fuzz(func(t *testing.T, data []byte) {
+		meta, err := DecodeMetadata(data)
+		if err != nil {
+			if !errors.Is(err, ErrInvalidMetadata) {
+				t.Fatalf("Expected ErrInvalidMetadata, got %v", err)
+			}
+			return
+		}
+		d := meta.Data
+		if len(d.Name)+len(d.Symbol)+len(d.Uri)+len(d.Creators)*34 > len(data) {
+			t.Fatalf("Decoded more than %d bytes hold", len(data))
+		}
+	})
+}
+
+func FuzzEdition(f *testing.F) {
+	seedAccounts(f, "master_edition.json", "print_edition.json")
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if _, err := DecodeEdition(data); err != nil && !errors.Is(err, ErrInvalidMetadata) {
+			t.Fatalf("Expected ErrInvalidMetadata, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package token_metadata
+
+import (
+	"fmt"
+
+	bin "github.com/gagliardetto/binary"
+	token_metadata "github.com/gagliardetto/metaplex-go/clients/token-metadata"
+	"github.com/gagliardetto/solana-go"
+)
+
+// Edition is a master edition, which prints are minted from, or a print of one
+type Edition struct {
+	Key       token_metadata.Key
+	Supply    uint64           //Master: prints minted so far
+	MaxSupply *uint64          //Master: nil when unlimited
+	Parent    solana.PublicKey //Print: master edition account it was printed from
+	Number    uint64           //Print: edition number
+}
+
+func (e *Edition) IsMaster() bool {
+	return e.Key == token_metadata.KeyMasterEditionV1 || e.Key == token_metadata.KeyMasterEditionV2
+}
+
+// DecodeEdition decodes a master edition or print edition account
+func DecodeEdition(data []byte) (_ *Edition, err error) {
+	defer func() { err = invalid(err) }()
+
+	dec := bin.NewBinDecoder(data)
+	key, err := dec.ReadUint8()
+	if err != nil {
+		return nil, err
+	}
+
+	edition := Edition{Key: token_metadata.Key(key)}
+	switch edition.Key {
+	case token_metadata.KeyMasterEditionV1, token_metadata.KeyMasterEditionV2:
+		if edition.Supply, err = dec.ReadUint64(bin.LE); err != nil {
+			return nil, err
+		}
+		err = readOptional(dec, func() error {
+			max, err := dec.ReadUint64(bin.LE)
+			edition.MaxSupply = &max
+			return err
+		})
+	case token_metadata.KeyEditionV1:
+		if edition.Parent, err = readPublicKey(dec); err != nil {
+			return nil, err
+		}
+		edition.Number, err = dec.ReadUint64(bin.LE)
+	default:
+		return nil, fmt.Errorf("%w: edition key %d", ErrInvalidMetadata, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &edition, nil
+}
+
+// FindEditionAddress returns the edition account of mint, holding either its master or print edition
+func FindEditionAddress(mint solana.PublicKey) (solana.PublicKey, uint8, error) {
+	return solana.FindProgramAddress([][]byte{
+		[]byte("metadata"),
+		solana.TokenMetadataProgramID[:],
+		mint[:],
+		[]byte("edition"),
+	}, solana.TokenMetadataProgramID)
+}
@@ -1,10 +1,17 @@
 package token_metadata
 
 import (
+	"errors"
+
+	"github.com/alphabatem/nft-proxy/metaplex_core"
+	token_extensions "github.com/alphabatem/nft-proxy/token-extensions"
+	bin "github.com/gagliardetto/binary"
 	token_metadata "github.com/gagliardetto/metaplex-go/clients/token-metadata"
 	"github.com/gagliardetto/solana-go"
 )
 
+var ErrInvalidMetadata = errors.New("invalid metadata account")
+
 type Protocol uint8  // More specific size since we only need few values
 
 const (
@@ -28,14 +35,22 @@ type Metadata struct {
 	// Whether or not the data struct is mutable, default is not
 	IsMutable bool
 
-	// Collection
-	// Issues:
-	// Using pointers for optional fields can lead to nil pointer dereferences
-	// No validation for optional fields
-	// No documentation about nil behavior
-	
+	// Nonce of the edition PDA, if present
+	EditionNonce *uint8 `bin:"optional"`
+
+	TokenStandard *TokenStandard `bin:"optional"`
+
+	// Collection, nil when the NFT is not part of one
 	Collection *token_metadata.Collection `bin:"optional"`
 
+	Uses *token_metadata.Uses `bin:"optional"`
+
+	// Size of the collection when this is a collection NFT
+	CollectionDetails *CollectionDetails `bin:"optional"`
+
+	// Rule set enforced on transfers of programmable NFTs
+	ProgrammableConfig *ProgrammableConfig `bin:"optional"`
+
 
 	// Issues:
 
@@ -44,13 +59,86 @@ type Metadata struct {
 	// No validation tags
 
     Protocol Protocol `bin:"-" json:"protocol"`
+
+	// Data.Uri points at the image itself rather than a json file (Libreplex image assets)
+	UriIsImage bool `bin:"-" json:"-"`
+
+	// Token-2022 mint extensions, whichever program holds the metadata
+	Extensions *token_extensions.Extensions `bin:"-" json:"-"`
+
+	// Royalties plugin of a Metaplex Core asset, nil when the asset inherits them from its collection
+	CoreRoyalties *metaplex_core.Royalties `bin:"-" json:"-"`
+
+	// Master or print edition of the mint, read from its edition account
+	Edition *Edition `bin:"-" json:"-"`
+
+	// Master edition a print was minted from, for its max supply
+	MasterEdition *Edition `bin:"-" json:"-"`
+}
+
+type TokenStandard uint8
+
+const (
+	TokenStandardNonFungible TokenStandard = iota
+	TokenStandardFungibleAsset
+	TokenStandardFungible
+	TokenStandardNonFungibleEdition
+	TokenStandardProgrammableNonFungible
+	TokenStandardProgrammableNonFungibleEdition
+)
+
+// CollectionDetails is set on collection NFTs, V2 replaced the size with padding
+type CollectionDetails struct {
+	Version uint8
+	Size    uint64
+}
+
+func (c *CollectionDetails) UnmarshalWithDecoder(dec *bin.Decoder) (err error) {
+	defer func() { err = invalid(err) }()
+
+	if c.Version, err = dec.ReadUint8(); err != nil {
+		return err
+	}
+	if c.Version > 1 {
+		return ErrInvalidMetadata
+	}
+	c.Size, err = dec.ReadUint64(bin.LE)
+	return err
+}
+
+// ProgrammableConfig of a pNFT, V1 is the only version
+type ProgrammableConfig struct {
+	RuleSet *solana.PublicKey
+}
+
+func (c *ProgrammableConfig) UnmarshalWithDecoder(dec *bin.Decoder) (err error) {
+	defer func() { err = invalid(err) }()
+
+	version, err := dec.ReadUint8()
+	if err != nil {
+		return err
+	}
+	if version != 0 {
+		return ErrInvalidMetadata
+	}
+
+	hasRuleSet, err := readBool(dec)
+	if err != nil || !hasRuleSet {
+		return err
+	}
+	ruleSet, err := readPublicKey(dec)
+	if err != nil {
+		return err
+	}
+	c.RuleSet = &ruleSet
+	return nil
 }
 
 
 type SellerFeeBasisPoints uint16
 
 func (s SellerFeeBasisPoints) Valid() bool {
-    return 0 <= s  && s <= 10000
+	return s <= 10000
 }
 
 type Data struct {
@@ -0,0 +1,67 @@
+package nft_proxy
+
+import "time"
+
+// TokenStandard distinguishes fungible tokens, semi-fungible assets & NFTs, following the Metaplex naming
+type TokenStandard string
+
+const (
+	TokenStandardNonFungible                    TokenStandard = "NonFungible"
+	TokenStandardFungibleAsset                  TokenStandard = "FungibleAsset" //Semi-fungible, no decimals & a supply above one
+	TokenStandardFungible                       TokenStandard = "Fungible"
+	TokenStandardNonFungibleEdition             TokenStandard = "NonFungibleEdition" //Print of a master edition
+	TokenStandardProgrammableNonFungible        TokenStandard = "ProgrammableNonFungible"
+	TokenStandardProgrammableNonFungibleEdition TokenStandard = "ProgrammableNonFungibleEdition"
+)
+
+const (
+	TokenProgramSPL       = "spl-token"
+	TokenProgramToken2022 = "token-2022"
+	TokenProgramCore      = "mpl-core"
+)
+
+// SolanaToken is the mint account of a token, cached alongside its SolanaMedia row
+type SolanaToken struct {
+	ID              uint          `json:"-" gorm:"primaryKey"`
+	Mint            string        `json:"mint" gorm:"uniqueIndex"`
+	Program         string        `json:"program"`
+	Supply          string        `json:"supply"` //Raw amount, u64 does not fit sqlite integers
+	Decimals        uint8         `json:"decimals"`
+	MintAuthority   string        `json:"mintAuthority,omitempty"`
+	FreezeAuthority string        `json:"freezeAuthority,omitempty"`
+	TokenStandard   TokenStandard `json:"tokenStandard" gorm:"index"`
+	Slot            uint64        `json:"-"` //Context slot the supply was read at
+	SupplyUpdatedAt time.Time     `json:"supplyUpdatedAt" gorm:"index"`
+	CreatedAt       time.Time     `json:"-"`
+}
+
+// Token is the response for fungible tokens, the media of the mint combined with its supply & authorities
+type Token struct {
+	*Media
+	Logo            string        `json:"logo"`
+	Program         string        `json:"program"`
+	Supply          string        `json:"supply"`
+	MintAuthority   string        `json:"mintAuthority,omitempty"`
+	FreezeAuthority string        `json:"freezeAuthority,omitempty"`
+	TokenStandard   TokenStandard `json:"tokenStandard"`
+	SupplyUpdatedAt time.Time     `json:"supplyUpdatedAt"`
+}
+
+// Token combines the mint with its media, preferring the token standard recorded in the metadata over the inferred one
+func (t *SolanaToken) Token(media *Media) *Token {
+	standard := t.TokenStandard
+	if media.TokenStandard != "" {
+		standard = media.TokenStandard
+	}
+
+	return &Token{
+		Media:           media,
+		Logo:            media.ImageUri,
+		Program:         t.Program,
+		Supply:          t.Supply,
+		MintAuthority:   t.MintAuthority,
+		FreezeAuthority: t.FreezeAuthority,
+		TokenStandard:   standard,
+		SupplyUpdatedAt: t.SupplyUpdatedAt,
+	}
+}
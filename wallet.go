@@ -0,0 +1,20 @@
+package nft_proxy
+
+// Holding is a token or asset held by a wallet, joined with the cached media of its mint
+type Holding struct {
+	Mint         string `json:"mint"`
+	Program      string `json:"program"`
+	TokenAccount string `json:"tokenAccount,omitempty"` //Empty for core assets, which are owned directly
+	Amount       string `json:"amount"`                 //Raw amount
+	Decimals     uint8  `json:"decimals"`
+	Media        *Media `json:"media,omitempty"` //Nil when the metadata could not be fetched
+}
+
+// Holdings is a page of the holdings of a wallet, ordered by mint
+type Holdings struct {
+	Owner string     `json:"owner"`
+	Page  int        `json:"page"`
+	Limit int        `json:"limit"`
+	Total int        `json:"total"`
+	Items []*Holding `json:"items"`
+}